@@ -0,0 +1,63 @@
+package plist
+
+import "sync"
+
+// An Allocator provides the maps and slices a Decoder uses to build the
+// map[string]interface{} and []interface{} values of an interface{} tree.
+// Maps must be empty and slices must have length size. The Decoder owns the
+// values until Decode returns, after which they belong to the caller.
+type Allocator interface {
+	NewMap(size int) map[string]interface{}
+	NewSlice(size int) []interface{}
+}
+
+// A PoolAllocator is an Allocator that reuses the maps and slices of trees
+// handed back to it with Release, to reduce garbage when decoding many
+// similar documents. The zero value is ready to use, and a PoolAllocator is
+// safe for concurrent use.
+type PoolAllocator struct {
+	maps   sync.Pool
+	slices sync.Pool
+}
+
+// NewMap returns an empty map, reusing a released one if possible.
+func (p *PoolAllocator) NewMap(size int) map[string]interface{} {
+	if m, ok := p.maps.Get().(map[string]interface{}); ok {
+		return m
+	}
+	return make(map[string]interface{}, size)
+}
+
+// NewSlice returns a slice of length size, reusing a released one if it is
+// large enough.
+func (p *PoolAllocator) NewSlice(size int) []interface{} {
+	if s, ok := p.slices.Get().(*[]interface{}); ok {
+		if cap(*s) >= size {
+			return (*s)[:size]
+		}
+		p.slices.Put(s)
+	}
+	return make([]interface{}, size)
+}
+
+// Release hands the maps and slices of tree, and of any maps and slices
+// nested in it, back to the pool. Their contents are cleared first. Neither
+// tree nor anything that was taken out of it may be used after calling
+// Release, unless it is a scalar value like a string.
+func (p *PoolAllocator) Release(tree interface{}) {
+	switch v := tree.(type) {
+	case map[string]interface{}:
+		for k, sub := range v {
+			p.Release(sub)
+			delete(v, k)
+		}
+		p.maps.Put(v)
+	case []interface{}:
+		for i, sub := range v {
+			p.Release(sub)
+			v[i] = nil
+		}
+		v = v[:0]
+		p.slices.Put(&v)
+	}
+}
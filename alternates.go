@@ -0,0 +1,54 @@
+package plist
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// The name of a struct field can list alternate keys separated by |, like
+// `plist:"PayloadOrganization|Organization"`, for values whose key changed
+// between versions of a format. Decoding fills the field from the first of
+// the keys that is present, and encoding uses the first key. By default other
+// keys that are also present are ignored, and with DisallowAmbiguousKeys they
+// are an error. Fields tagged with path don't take alternates.
+
+// alternateNames splits name into the key of a field and its alternates.
+func alternateNames(name string, opts tagOptions) (string, []string) {
+	if opts.Contains("path") || !strings.Contains(name, "|") {
+		return name, nil
+	}
+	names := strings.Split(name, "|")
+	return names[0], names[1:]
+}
+
+// DisallowAmbiguousKeys causes Decode to return an error when a dict decoded
+// into a struct has more than one of the keys of a field tagged with
+// alternate keys, instead of using the first one.
+func (d *Decoder) DisallowAmbiguousKeys() {
+	d.disallowAmbiguousKeys = true
+}
+
+// alternateValue returns the value of the first key of f in subvalues, or
+// false if there is none.
+func (d *Decoder) alternateValue(f field, subvalues map[string]*plistValue, typ reflect.Type) (*plistValue, bool, error) {
+	found := ""
+	sval, ok := subvalues[f.name]
+	if ok {
+		found = f.name
+	}
+	for _, name := range f.aliases {
+		alt, present := subvalues[name]
+		if !present {
+			continue
+		}
+		if ok {
+			if d.disallowAmbiguousKeys {
+				return nil, false, fmt.Errorf("plist: keys %q and %q of field %s of Go value of type %v are both present", found, name, f.name, typ)
+			}
+			continue
+		}
+		sval, ok, found = alt, true, name
+	}
+	return sval, ok, nil
+}
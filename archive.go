@@ -0,0 +1,171 @@
+package plist
+
+import (
+	"errors"
+	"fmt"
+)
+
+// An Archive is the object graph of an NSKeyedArchiver archive, like those
+// stored by macOS preferences, with the UID references between its objects
+// resolved.
+type Archive struct {
+	// Top holds the top level objects of the archive by their keys, like
+	// "root".
+	Top map[string]interface{}
+	// Cyclic is set if an object of the graph refers to itself, directly or
+	// through other objects.
+	Cyclic bool
+}
+
+// UnmarshalArchive decodes an NSKeyedArchiver archive and resolves it into
+// an object graph. Each object of the archive's $objects array becomes a
+// single Go value, and every UID referring to it, in the $top dictionary and
+// in the dictionaries and arrays of other objects, is replaced by that value,
+// so that objects referred to more than once are shared, and so are the
+// maps and slices of objects that refer to themselves. Such cycles are
+// resolved without recursion and reported by Archive.Cyclic; code walking the
+// graph of a cyclic archive must track the maps and slices it has visited.
+// The "$null" object is resolved to nil.
+//
+// Objects keep their archived form, like the dictionaries with NS.keys and
+// NS.objects arrays of an NSDictionary, with the exception of the UIDs.
+func UnmarshalArchive(data []byte) (*Archive, error) {
+	var archive map[string]interface{}
+	if err := Unmarshal(data, &archive); err != nil {
+		return nil, err
+	}
+	objects, ok := archive["$objects"].([]interface{})
+	if !ok {
+		return nil, errors.New("plist: not a keyed archive: no $objects array")
+	}
+	top, ok := archive["$top"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("plist: not a keyed archive: no $top dictionary")
+	}
+
+	r := archiveResolver{
+		objects:  objects,
+		resolved: make([]interface{}, len(objects)),
+		refs:     make([][]UID, len(objects)),
+	}
+	// The maps and slices of all objects are made first, so that references
+	// to them can be resolved before they are filled.
+	for i, obj := range objects {
+		switch obj := obj.(type) {
+		case map[string]interface{}:
+			r.resolved[i] = make(map[string]interface{}, len(obj))
+		case []interface{}:
+			r.resolved[i] = make([]interface{}, len(obj))
+		case string:
+			if obj != "$null" {
+				r.resolved[i] = obj
+			}
+		default:
+			r.resolved[i] = obj
+		}
+	}
+	for i, obj := range objects {
+		var err error
+		switch obj := obj.(type) {
+		case map[string]interface{}:
+			m := r.resolved[i].(map[string]interface{})
+			for k, v := range obj {
+				if m[k], err = r.inline(v, &r.refs[i]); err != nil {
+					return nil, err
+				}
+			}
+		case []interface{}:
+			s := r.resolved[i].([]interface{})
+			for j, v := range obj {
+				if s[j], err = r.inline(v, &r.refs[i]); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	var roots []UID
+	resolvedTop, err := r.inline(top, &roots)
+	if err != nil {
+		return nil, err
+	}
+	return &Archive{Top: resolvedTop.(map[string]interface{}), Cyclic: r.cyclic(roots)}, nil
+}
+
+type archiveResolver struct {
+	objects  []interface{}
+	resolved []interface{} // the value of each object
+	refs     [][]UID       // the objects each object refers to
+}
+
+// inline returns the value v held inline by an object, with its UIDs replaced
+// by the objects they refer to, which are added to refs.
+func (r *archiveResolver) inline(v interface{}, refs *[]UID) (interface{}, error) {
+	switch v := v.(type) {
+	case UID:
+		if uint64(v) >= uint64(len(r.resolved)) {
+			return nil, fmt.Errorf("plist: keyed archive refers to object %d of %d", v, len(r.resolved))
+		}
+		*refs = append(*refs, v)
+		return r.resolved[v], nil
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, sv := range v {
+			var err error
+			if m[k], err = r.inline(sv, refs); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, sv := range v {
+			var err error
+			if s[i], err = r.inline(sv, refs); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	}
+	return v, nil
+}
+
+// cyclic reports whether the references between the objects reachable from
+// roots form a cycle, with an iterative depth-first search.
+func (r *archiveResolver) cyclic(roots []UID) bool {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make([]uint8, len(r.objects))
+	type frame struct {
+		obj  UID
+		next int // index of the next reference of obj to follow
+	}
+	for _, root := range roots {
+		if state[root] != unvisited {
+			continue
+		}
+		state[root] = visiting
+		stack := []frame{{root, 0}}
+		for len(stack) > 0 {
+			top := &stack[len(stack)-1]
+			if top.next == len(r.refs[top.obj]) {
+				state[top.obj] = done
+				stack = stack[:len(stack)-1]
+				continue
+			}
+			ref := r.refs[top.obj][top.next]
+			top.next++
+			switch state[ref] {
+			case visiting:
+				return true
+			case unvisited:
+				state[ref] = visiting
+				stack = append(stack, frame{ref, 0})
+			}
+		}
+	}
+	return false
+}
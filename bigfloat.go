@@ -0,0 +1,63 @@
+package plist
+
+import (
+	"math"
+	"math/big"
+	"reflect"
+)
+
+// A big.Float, or a *big.Float, is decoded from an <integer> or <real> by
+// parsing the literal text of the number at arbitrary precision, rather than
+// as a float64, so that reals written with more digits than a float64 holds
+// keep them. The float keeps its precision if it has one; otherwise it gets
+// 4 bits for each character of the literal, and at least 64, which is enough
+// for every digit. Reals of binary plists are float64 values, so they can't
+// hold more.
+//
+// A big.Float is encoded as a <real> of its shortest decimal text that
+// parses back to it, as formatted by Text('g', -1), so that XML plists keep
+// its full precision. Binary plists round it to a float64. Decoding into an
+// empty interface still stores a float64.
+
+var bigFloatType = reflect.TypeOf(big.Float{})
+
+// bigFloatValue returns the plistValue of the big.Float v.
+func bigFloatValue(v reflect.Value) *plistValue {
+	var f *big.Float
+	if v.CanAddr() {
+		f = v.Addr().Interface().(*big.Float)
+	} else {
+		x := v.Interface().(big.Float)
+		f = &x
+	}
+	f64, _ := f.Float64()
+	if f.IsInf() {
+		// Written as the infinities of float64s are.
+		return &plistValue{Real, sizedFloat{f64, 64, ""}}
+	}
+	return &plistValue{Real, sizedFloat{f64, 64, f.Text('g', -1)}}
+}
+
+// unmarshalBigFloat decodes the number pval into the big.Float v.
+func (d *Decoder) unmarshalBigFloat(pval *plistValue, v reflect.Value) error {
+	if pval.kind != Real && pval.kind != Integer {
+		return UnmarshalTypeError{describe(pval), v.Type()}
+	}
+	f := v.Addr().Interface().(*big.Float)
+	if sf, ok := pval.value.(sizedFloat); ok && math.IsNaN(sf.value) {
+		// big.Float has no NaN.
+		return UnmarshalTypeError{describe(pval), v.Type()}
+	}
+	s := numberLiteral(pval)
+	if f.Prec() == 0 {
+		prec := uint(4 * len(s))
+		if prec < 64 {
+			prec = 64
+		}
+		f.SetPrec(prec)
+	}
+	if _, ok := f.SetString(s); !ok {
+		return UnmarshalTypeError{describe(pval), v.Type()}
+	}
+	return nil
+}
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"io"
 	"time"
-	"unicode/utf16"
 )
 
 // plistTrailer is the last 32 bytes of a binary plist
@@ -22,10 +21,37 @@ type plistTrailer struct {
 	OffsetTableOffset uint64  // offset of the offset table
 }
 
+// A Trailer holds the metadata stored in the trailer of a binary plist.
+type Trailer struct {
+	SortVersion       uint8  // unused by CoreFoundation, normally zero
+	OffsetIntSize     uint8  // byte size of the offsets in the offset table
+	ObjectRefSize     uint8  // byte size of the object refs in arrays and dictionaries
+	NumObjects        uint64 // number of objects, and of offsets in the offset table
+	RootObject        uint64 // object ref of the top level object
+	OffsetTableOffset uint64 // byte offset of the offset table
+}
+
+func (t plistTrailer) export() *Trailer {
+	return &Trailer{
+		SortVersion:       t.SortVersion,
+		OffsetIntSize:     t.OffsetIntSize,
+		ObjectRefSize:     t.ObjectRefSize,
+		NumObjects:        t.NumObjects,
+		RootObject:        t.RootObject,
+		OffsetTableOffset: t.OffsetTableOffset,
+	}
+}
+
 type binaryParser struct {
 	OffsetTable   []uint64 // array of offsets for each object in plist
 	plistTrailer           // last 32 bytes of plist
 	io.ReadSeeker          // reader for plist data
+
+	limit     elementLimit
+	keyFilter map[string]bool // keys of the root dict to parse if set, see onlykeys.go
+
+	strict      bool // invalid UTF-8 and UTF-16 strings are an error if set
+	replaceUTF8 bool // invalid UTF-8 in strings is replaced if set, see utf8.go
 }
 
 const numObjectsMax = 4 << 20
@@ -95,9 +121,12 @@ func (bp *binaryParser) parseObjectRef(index uint64) (val *plistValue, err error
 		}
 	}()
 
-	if index > uint64(len(bp.OffsetTable)) {
+	if index >= uint64(len(bp.OffsetTable)) {
 		return nil, fmt.Errorf("plist: offset too large: %d", index)
 	}
+	if err := bp.limit.add(); err != nil {
+		return nil, err
+	}
 	// Move to the start of the object we want to decode.
 	if _, err := bp.Seek(int64(bp.OffsetTable[index]), io.SeekStart); err != nil {
 		return nil, err
@@ -112,6 +141,9 @@ func (bp *binaryParser) parseObjectRef(index uint64) (val *plistValue, err error
 		return nil, err
 	}
 	marker := b[0]
+	// The filter only applies to the root object, which is parsed first.
+	filter := bp.keyFilter
+	bp.keyFilter = nil
 	switch marker >> 4 {
 	case 0x0: // null, bool, or fill
 		return bp.parseSingleton(marker)
@@ -127,14 +159,14 @@ func (bp *binaryParser) parseObjectRef(index uint64) (val *plistValue, err error
 		return bp.parseASCII(marker)
 	case 0x6: // unicode (utf-16) string
 		return bp.parseUTF16(marker)
-	case 0x8: // uid (not supported)
-		return &plistValue{Invalid, nil}, nil
+	case 0x8: // uid
+		return bp.parseUID(marker)
 	case 0xa: // array
 		return bp.parseArray(marker)
 	case 0xc: // set (not supported)
 		return &plistValue{Invalid, nil}, nil
 	case 0xd: // dictionary
-		return bp.parseDict(marker)
+		return bp.parseDict(marker, filter)
 	}
 	return nil, fmt.Errorf("plist: unknown object type %x", marker>>4)
 }
@@ -218,10 +250,13 @@ func (bp *binaryParser) parseInteger(marker byte) (*plistValue, error) {
 	// lets the user decide: if they want to unmarshal into a uint64 value,
 	// we give them the full 8-bytes as such.  If they unmarshal into a signed
 	// int64 value, we again give them the full 8-bytes as such, which will be
-	// interpreted as negative if the top bit is set.  This is achieved by
-	// setting the signed field of the signedInt value below to false
-	// unconditionally. That way, the Decoder.unmarshalInteger method will do
-	// the right thing.
+	// interpreted as negative if the top bit is set.  8-byte values with the
+	// top bit set are marked as signed, so that they decode into an empty
+	// interface as the negative int64 CoreFoundation reads them as, and as
+	// anySign, so that Decoder.unmarshalInteger still accepts them for
+	// unsigned values, even in a binary plist nested in an XML one. Values
+	// larger than math.MaxInt64 are written as 16-byte integers by
+	// CoreFoundation and by this package.
 	//
 	// For XML property list unmarshaling, the presence of the "negative sign"
 	// on an integer value makes the above unambigious, and the current practice
@@ -239,12 +274,11 @@ func (bp *binaryParser) parseInteger(marker byte) (*plistValue, error) {
 	if err != nil {
 		return nil, err
 	}
-	// Truncate values to 64 bits (8 bytes), and treat them all as "unsigned",
-	// so they can be unmarshaled to unsigned and signed integers alike as
-	// discussed above.
-	result := signedInt{binary.BigEndian.Uint64(buf[8:]), false}
-
-	return &plistValue{Integer, result}, nil
+	// Truncate values to 64 bits (8 bytes), so they can be unmarshaled to
+	// unsigned and signed integers alike as discussed above.
+	value := binary.BigEndian.Uint64(buf[8:])
+	negative := nbytes == 8 && int64(value) < 0
+	return &plistValue{Integer, signedInt{value, negative, negative}}, nil
 }
 
 func (bp *binaryParser) parseReal(marker byte) (*plistValue, error) {
@@ -257,7 +291,7 @@ func (bp *binaryParser) parseReal(marker byte) (*plistValue, error) {
 	if err := binary.Read(bytes.NewReader(buf), binary.BigEndian, &r); err != nil {
 		return nil, err
 	}
-	return &plistValue{Real, sizedFloat{r, nbytes * 8}}, nil
+	return &plistValue{Real, sizedFloat{r, nbytes * 8, ""}}, nil
 }
 
 func (bp *binaryParser) parseDate(marker byte) (*plistValue, error) {
@@ -277,7 +311,7 @@ func (bp *binaryParser) parseDate(marker byte) (*plistValue, error) {
 	t += 978307200
 	secs := int64(t)
 	nsecs := int64((t - float64(secs)) * 1e9)
-	return &plistValue{Date, time.Unix(secs, nsecs)}, nil
+	return &plistValue{Date, time.Unix(secs, nsecs).UTC()}, nil
 }
 
 func (bp *binaryParser) parseData(marker byte) (*plistValue, error) {
@@ -297,11 +331,16 @@ func (bp *binaryParser) parseASCII(marker byte) (*plistValue, error) {
 	if err != nil {
 		return nil, err
 	}
+	offset := bp.stringOffset()
 	buf := make([]byte, count)
 	if _, err := bp.Read(buf); err != nil {
 		return nil, err
 	}
-	return &plistValue{String, string(buf)}, nil
+	s, err := bp.checkUTF8(string(buf), offset)
+	if err != nil {
+		return nil, err
+	}
+	return &plistValue{String, s}, nil
 }
 
 func (bp *binaryParser) parseUTF16(marker byte) (*plistValue, error) {
@@ -312,6 +351,7 @@ func (bp *binaryParser) parseUTF16(marker byte) (*plistValue, error) {
 	// Each character in the UTF16 string is 2 bytes.  First we read everything
 	// into a byte slice, then convert this into a slice of uint16, then this
 	// gets converted into a slice of rune, which gets converted to a string.
+	offset := bp.stringOffset()
 	buf := make([]byte, 2*count)
 	if _, err := bp.Read(buf); err != nil {
 		return nil, err
@@ -320,7 +360,11 @@ func (bp *binaryParser) parseUTF16(marker byte) (*plistValue, error) {
 	if err := binary.Read(bytes.NewReader(buf), binary.BigEndian, uni); err != nil {
 		return nil, err
 	}
-	return &plistValue{String, string(utf16.Decode(uni))}, nil
+	s, err := bp.checkUTF16(uni, offset)
+	if err != nil {
+		return nil, err
+	}
+	return &plistValue{String, s}, nil
 }
 
 func (bp *binaryParser) parseArray(marker byte) (*plistValue, error) {
@@ -336,7 +380,7 @@ func (bp *binaryParser) parseArray(marker byte) (*plistValue, error) {
 	return &plistValue{Array, list}, nil
 }
 
-func (bp *binaryParser) parseDict(marker byte) (*plistValue, error) {
+func (bp *binaryParser) parseDict(marker byte, filter map[string]bool) (*plistValue, error) {
 	count, err := bp.readCount(marker)
 	if err != nil {
 		return nil, err
@@ -347,18 +391,28 @@ func (bp *binaryParser) parseDict(marker byte) (*plistValue, error) {
 	if err != nil {
 		return nil, err
 	}
-	vals, err := bp.readObjectList(count)
+	vals, err := bp.readFilteredList(keys, filter)
 	if err != nil {
 		return nil, err
 	}
 	m := make(map[string]*plistValue)
+	var duplicates, order []string
 	for i := uint64(0); i < count; i++ {
 		if keys[i].kind != String {
 			return nil, fmt.Errorf("plist: dictionary key is not a string: %v", keys[i])
 		}
-		m[keys[i].value.(string)] = vals[i]
+		key := keys[i].value.(string)
+		if vals[i] == nil {
+			continue
+		}
+		if _, ok := m[key]; ok {
+			duplicates = append(duplicates, key)
+		} else {
+			order = append(order, key)
+		}
+		m[key] = vals[i]
 	}
-	return &plistValue{Dictionary, &dictionary{m: m}}, nil
+	return &plistValue{Dictionary, &dictionary{m: m, duplicates: duplicates, order: order}}, nil
 }
 
 // readCount reads the variable-length encoded integer count
@@ -423,3 +477,93 @@ func (bp *binaryParser) readObjectList(count uint64) ([]*plistValue, error) {
 	}
 	return list, nil
 }
+
+// readCollectionRefs reads the marker byte of the object with the given index
+// and, if it is an array or dictionary, the object refs it contains without
+// decoding the referenced objects. For dictionaries, the refs of all the keys
+// are listed first, followed by the refs of all the values.
+// Like parseObjectRef, the current plist offset is restored when it's done.
+func (bp *binaryParser) readCollectionRefs(index uint64) (marker byte, refs []uint64, err error) {
+	offset, err := bp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer func() {
+		_, err2 := bp.Seek(offset, io.SeekStart)
+		if err2 != nil {
+			err = err2
+		}
+	}()
+
+	if marker, err = bp.seekObject(index); err != nil {
+		return 0, nil, err
+	}
+	var count uint64
+	switch marker >> 4 {
+	case 0xa: // array
+		if count, err = bp.readCount(marker); err != nil {
+			return 0, nil, err
+		}
+	case 0xd: // dictionary
+		if count, err = bp.readCount(marker); err != nil {
+			return 0, nil, err
+		}
+		count *= 2
+	default:
+		return marker, nil, nil
+	}
+	if count > bp.NumObjects*2 {
+		return 0, nil, fmt.Errorf("plist: object count too large: %d", count)
+	}
+	refs = make([]uint64, count)
+	for i := range refs {
+		if refs[i], err = bp.readObjectRef(); err != nil {
+			return 0, nil, err
+		}
+	}
+	return marker, refs, nil
+}
+
+// seekObject moves to the start of the object with the given index and reads
+// its marker byte, leaving the offset just past the marker.
+func (bp *binaryParser) seekObject(index uint64) (byte, error) {
+	if index >= uint64(len(bp.OffsetTable)) {
+		return 0, fmt.Errorf("plist: offset too large: %d", index)
+	}
+	if _, err := bp.Seek(int64(bp.OffsetTable[index]), io.SeekStart); err != nil {
+		return 0, err
+	}
+	b := make([]byte, 1)
+	if _, err := bp.Read(b); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// markerKind returns the kind of object a marker byte introduces, without
+// validating the rest of the object.
+func markerKind(marker byte) Kind {
+	switch marker >> 4 {
+	case 0x0:
+		if marker == 0x08 || marker == 0x09 {
+			return Boolean
+		}
+	case 0x1:
+		return Integer
+	case 0x2:
+		return Real
+	case 0x3:
+		return Date
+	case 0x4:
+		return Data
+	case 0x5, 0x6:
+		return String
+	case 0x8:
+		return UIDKind
+	case 0xa:
+		return Array
+	case 0xd:
+		return Dictionary
+	}
+	return Invalid
+}
@@ -0,0 +1,117 @@
+package plist
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+)
+
+// A BinaryReader provides random access to the objects of a binary plist.
+// Objects are addressed by their index in the offset table and are only
+// decoded when requested, so a single value can be read out of a large
+// document without decoding the rest of it.
+type BinaryReader struct {
+	parser *binaryParser
+	dec    Decoder
+}
+
+// NewBinaryReader reads the trailer and offset table of the binary plist in r
+// and returns a BinaryReader for its objects.
+func NewBinaryReader(r io.ReadSeeker) (*BinaryReader, error) {
+	parser, err := newBinaryParser(r)
+	if err != nil {
+		return nil, err
+	}
+	return &BinaryReader{parser: parser, dec: Decoder{isBinary: true}}, nil
+}
+
+// Trailer returns the metadata stored in the trailer of the binary plist.
+func (r *BinaryReader) Trailer() *Trailer {
+	return r.parser.plistTrailer.export()
+}
+
+// NumObjects returns the number of objects in the offset table.
+func (r *BinaryReader) NumObjects() int {
+	return len(r.parser.OffsetTable)
+}
+
+// RootObject returns the index of the top level object.
+func (r *BinaryReader) RootObject() uint64 {
+	return r.parser.RootObject
+}
+
+// Offset returns the byte offset of the object with the given index.
+func (r *BinaryReader) Offset(index uint64) (uint64, error) {
+	if index >= uint64(len(r.parser.OffsetTable)) {
+		return 0, fmt.Errorf("plist: offset too large: %d", index)
+	}
+	return r.parser.OffsetTable[index], nil
+}
+
+// DecodeObject decodes the object with the given index, and any objects it
+// references, into the value pointed to by v.
+func (r *BinaryReader) DecodeObject(index uint64, v interface{}) error {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr {
+		return errors.New("plist: non-pointer passed to DecodeObject")
+	}
+	pval, err := r.parser.parseObjectRef(index)
+	if err != nil {
+		return err
+	}
+	return r.dec.unmarshal(pval, val.Elem())
+}
+
+// Resolve walks path starting at the root object and returns the index of the
+// object it leads to. Each element of path is a dictionary key, or a decimal
+// index when the current object is an array. Only the keys of the
+// dictionaries along the path are decoded.
+func (r *BinaryReader) Resolve(path ...string) (uint64, error) {
+	index := r.parser.RootObject
+	for i, key := range path {
+		marker, refs, err := r.parser.readCollectionRefs(index)
+		if err != nil {
+			return 0, err
+		}
+		switch marker >> 4 {
+		case 0xa: // array
+			n, err := strconv.ParseUint(key, 10, 64)
+			if err != nil || n >= uint64(len(refs)) {
+				return 0, errPathNotFound(path[:i+1])
+			}
+			index = refs[n]
+		case 0xd: // dictionary
+			found := false
+			count := len(refs) / 2
+			for j := 0; j < count; j++ {
+				k, err := r.parser.parseObjectRef(refs[j])
+				if err != nil {
+					return 0, err
+				}
+				if k.kind == String && k.value.(string) == key {
+					index = refs[count+j]
+					found = true
+					break
+				}
+			}
+			if !found {
+				return 0, errPathNotFound(path[:i+1])
+			}
+		default:
+			return 0, errPathNotFound(path[:i+1])
+		}
+	}
+	return index, nil
+}
+
+// DecodePath resolves path as described for Resolve and decodes the object
+// found there into the value pointed to by v.
+func (r *BinaryReader) DecodePath(v interface{}, path ...string) error {
+	index, err := r.Resolve(path...)
+	if err != nil {
+		return err
+	}
+	return r.DecodeObject(index, v)
+}
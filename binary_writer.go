@@ -0,0 +1,336 @@
+package plist
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+	"unicode/utf16"
+)
+
+// binaryObject is an entry of the object table of a binary plist. refs holds
+// the object refs of the items of an array, or the keys followed by the values
+// of a dictionary.
+type binaryObject struct {
+	pval *plistValue
+	refs []uint64
+}
+
+// binaryEncoder writes plistValues as a bplist00 binary plist, as described
+// in https://opensource.apple.com/source/CF/CF-550.29/CFBinaryPList.c
+type binaryEncoder struct {
+	w       *bufio.Writer
+	offset  uint64 // number of bytes written so far
+	objects []binaryObject
+	uniques map[objectKey]uint64 // refs of the scalar objects written so far
+
+	objectRefSize uint8
+	minIntSize    uint8 // smallest size of integers, if set
+}
+
+// objectKey identifies the binary encoding of a scalar object, so that equal
+// scalars are only written once.
+type objectKey struct {
+	kind Kind
+	n    uint64
+	s    string
+}
+
+func newBinaryEncoder(w io.Writer) *binaryEncoder {
+	return &binaryEncoder{w: bufio.NewWriter(w), uniques: make(map[objectKey]uint64)}
+}
+
+func (e *binaryEncoder) generateDocument(pval *plistValue) error {
+	root := e.flatten(pval)
+	e.objectRefSize = uintSize(uint64(len(e.objects) - 1))
+
+	if err := e.write([]byte("bplist00")); err != nil {
+		return err
+	}
+	offsets := make([]uint64, len(e.objects))
+	for i, obj := range e.objects {
+		offsets[i] = e.offset
+		if err := e.writeObject(obj); err != nil {
+			return err
+		}
+	}
+
+	// The offset table follows the objects.
+	trailer := plistTrailer{
+		OffsetIntSize:     uintSize(e.offset),
+		ObjectRefSize:     e.objectRefSize,
+		NumObjects:        uint64(len(e.objects)),
+		RootObject:        root,
+		OffsetTableOffset: e.offset,
+	}
+	for _, offset := range offsets {
+		if err := e.writeUint(offset, trailer.OffsetIntSize); err != nil {
+			return err
+		}
+	}
+	if err := binary.Write(e.w, binary.BigEndian, &trailer); err != nil {
+		return err
+	}
+	return e.w.Flush()
+}
+
+// flatten adds pval and everything it contains to the object table and
+// returns the object ref of pval. Like CoreFoundation, scalars that encode
+// the same way are only added once and share their object ref, while arrays
+// and dictionaries are always added. Objects are added in depth-first order,
+// with the keys of a dictionary before its values, so the object table only
+// depends on pval.
+func (e *binaryEncoder) flatten(pval *plistValue) uint64 {
+	if pval.kind == commented {
+		// Comments have no binary representation.
+		return e.flatten(pval.value.(commentedValue).value)
+	}
+	key, unique := uniqueKey(pval)
+	if unique {
+		if ref, ok := e.uniques[key]; ok {
+			return ref
+		}
+	}
+	ref := uint64(len(e.objects))
+	e.objects = append(e.objects, binaryObject{pval: pval})
+	if unique {
+		e.uniques[key] = ref
+	}
+	switch pval.kind {
+	case Array:
+		values := pval.value.([]*plistValue)
+		refs := make([]uint64, len(values))
+		for i, v := range values {
+			refs[i] = e.flatten(v)
+		}
+		e.objects[ref].refs = refs
+	case Dictionary:
+		dict := pval.value.(*dictionary)
+		dict.populateArrays()
+		refs := make([]uint64, 2*len(dict.keys))
+		for i, k := range dict.keys {
+			refs[i] = e.flatten(&plistValue{String, k})
+		}
+		for i, v := range dict.values {
+			refs[len(dict.keys)+i] = e.flatten(v)
+		}
+		e.objects[ref].refs = refs
+	}
+	return ref
+}
+
+// uniqueKey returns the key of a scalar pval, or false for collections.
+func uniqueKey(pval *plistValue) (objectKey, bool) {
+	switch pval.kind {
+	case String:
+		return objectKey{kind: String, s: pval.value.(string)}, true
+	case Boolean:
+		if pval.value.(bool) {
+			return objectKey{kind: Boolean, n: 1}, true
+		}
+		return objectKey{kind: Boolean}, true
+	case Integer:
+		// Negative numbers and unsigned numbers with the same bits are
+		// written differently.
+		i := pval.value.(signedInt)
+		if i.signed && int64(i.value) < 0 {
+			return objectKey{kind: Integer, n: i.value, s: "-"}, true
+		}
+		return objectKey{kind: Integer, n: i.value}, true
+	case Real:
+		return objectKey{kind: Real, n: math.Float64bits(pval.value.(sizedFloat).value)}, true
+	case Date:
+		if t, ok := pval.value.(time.Time); ok {
+			return objectKey{kind: Date, n: math.Float64bits(appleSeconds(t))}, true
+		}
+		return objectKey{kind: Date, s: pval.value.(string)}, true
+	case Data:
+		return objectKey{kind: Data, s: string(pval.value.([]byte))}, true
+	case UIDKind:
+		return objectKey{kind: UIDKind, n: uint64(pval.value.(UID))}, true
+	}
+	return objectKey{}, false
+}
+
+func (e *binaryEncoder) writeObject(obj binaryObject) error {
+	pval := obj.pval
+	switch pval.kind {
+	case Boolean:
+		if pval.value.(bool) {
+			return e.write([]byte{0x09})
+		}
+		return e.write([]byte{0x08})
+	case Integer:
+		return e.writeInteger(pval.value.(signedInt))
+	case Real:
+		// Reals are always written as 8 byte doubles.
+		return e.writeMarkerUint(0x23, math.Float64bits(pval.value.(sizedFloat).value), 8)
+	case Date:
+		return e.writeDate(pval)
+	case Data:
+		data := pval.value.([]byte)
+		if err := e.writeCount(0x40, uint64(len(data))); err != nil {
+			return err
+		}
+		return e.write(data)
+	case String:
+		return e.writeString(pval.value.(string))
+	case UIDKind:
+		return e.writeUID(pval.value.(UID))
+	case Array:
+		if err := e.writeCount(0xa0, uint64(len(obj.refs))); err != nil {
+			return err
+		}
+		return e.writeRefs(obj.refs)
+	case Dictionary:
+		if err := e.writeCount(0xd0, uint64(len(obj.refs)/2)); err != nil {
+			return err
+		}
+		return e.writeRefs(obj.refs)
+	default:
+		return fmt.Errorf("plist: %v can't be encoded as a binary plist object", pval.kind)
+	}
+}
+
+// writeInteger writes an integer using the smallest of the 1, 2, 4 and 8 byte
+// forms that holds its value, like CoreFoundation does. The 1, 2 and 4 byte
+// forms are unsigned, so negative values always use the 8 byte form, which is
+// signed. Unsigned values that don't fit in the signed 8 byte form are written
+// as 16 byte integers with the upper 8 bytes zero. If minIntSize is set,
+// smaller forms are widened to it.
+func (e *binaryEncoder) writeInteger(i signedInt) error {
+	if i.signed && int64(i.value) < 0 {
+		return e.writeMarkerUint(0x13, i.value, 8)
+	}
+	if i.value > math.MaxInt64 {
+		if err := e.writeMarkerUint(0x14, 0, 8); err != nil {
+			return err
+		}
+		return e.writeUint(i.value, 8)
+	}
+	size := uintSize(i.value)
+	if size < e.minIntSize {
+		size = e.minIntSize
+	}
+	return e.writeMarkerUint(0x10|sizeExponent(size), i.value, size)
+}
+
+// writeDate writes a date as the number of seconds since the Apple epoch,
+// Jan 1, 2001 GMT.
+func (e *binaryEncoder) writeDate(pval *plistValue) error {
+	t, ok := pval.value.(time.Time)
+	if !ok {
+		// Strings tagged as dates hold the date's XML text.
+		var err error
+		if t, err = time.Parse(time.RFC3339, pval.value.(string)); err != nil {
+			return fmt.Errorf("plist: invalid date %q: %v", pval.value, err)
+		}
+	}
+	return e.writeMarkerUint(0x33, math.Float64bits(appleSeconds(t)), 8)
+}
+
+// appleSeconds returns t as the number of seconds since the Apple epoch.
+func appleSeconds(t time.Time) float64 {
+	return float64(t.Unix()-978307200) + float64(t.Nanosecond())/1e9
+}
+
+// writeString writes s as an ASCII string if it can, and as a UTF-16 string
+// otherwise.
+func (e *binaryEncoder) writeString(s string) error {
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		if err := e.writeCount(0x50, uint64(len(s))); err != nil {
+			return err
+		}
+		return e.write([]byte(s))
+	}
+	units := utf16.Encode([]rune(s))
+	if err := e.writeCount(0x60, uint64(len(units))); err != nil {
+		return err
+	}
+	buf := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.BigEndian.PutUint16(buf[2*i:], u)
+	}
+	return e.write(buf)
+}
+
+// writeCount writes the marker byte of a data, string, array or dictionary
+// object with the given count. Counts of 15 or more are stored in an integer
+// object following the marker, as read by binaryParser.readCount.
+func (e *binaryEncoder) writeCount(marker byte, count uint64) error {
+	if count < 0xf {
+		return e.write([]byte{marker | byte(count)})
+	}
+	if err := e.write([]byte{marker | 0xf}); err != nil {
+		return err
+	}
+	size := uintSize(count)
+	return e.writeMarkerUint(0x10|sizeExponent(size), count, size)
+}
+
+func (e *binaryEncoder) writeRefs(refs []uint64) error {
+	for _, ref := range refs {
+		if err := e.writeUint(ref, e.objectRefSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *binaryEncoder) writeMarkerUint(marker byte, v uint64, size uint8) error {
+	if err := e.write([]byte{marker}); err != nil {
+		return err
+	}
+	return e.writeUint(v, size)
+}
+
+// writeUint writes the low size bytes of v in big endian order.
+func (e *binaryEncoder) writeUint(v uint64, size uint8) error {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return e.write(buf[8-size:])
+}
+
+func (e *binaryEncoder) write(b []byte) error {
+	n, err := e.w.Write(b)
+	e.offset += uint64(n)
+	return err
+}
+
+// uintSize returns the smallest of 1, 2, 4 and 8 bytes that holds v.
+func uintSize(v uint64) uint8 {
+	switch {
+	case v <= math.MaxUint8:
+		return 1
+	case v <= math.MaxUint16:
+		return 2
+	case v <= math.MaxUint32:
+		return 4
+	default:
+		return 8
+	}
+}
+
+// sizeExponent returns the base 2 logarithm of size, as stored in the low
+// bits of integer marker bytes.
+func sizeExponent(size uint8) byte {
+	switch size {
+	case 1:
+		return 0
+	case 2:
+		return 1
+	case 4:
+		return 2
+	default:
+		return 3
+	}
+}
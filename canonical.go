@@ -0,0 +1,266 @@
+package plist
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Canonicalize decodes the XML or binary plist in data and returns it encoded
+// the way CoreFoundation writes XML plists, as done by
+// `plutil -convert xml1`, so that equal documents have identical bytes:
+//
+//   - elements are indented with one tab per level, with the top level value
+//     not indented inside <plist>
+//   - dictionary keys are sorted
+//   - empty arrays and dictionaries are written as <array/> and <dict/>
+//   - only <, > and & are escaped in strings and keys
+//   - reals are written with 17 significant digits, like %.17g in C, except
+//     for 0.0, nan, +infinity and -infinity
+//   - dates are written in UTC with the fraction of the second dropped
+//   - data is written as base64 on lines of its own, indented like the
+//     element, with at most 76 characters per line including the indentation
+//   - UIDs are written as dictionaries with a CF$UID key
+//   - the document ends with a newline
+func Canonicalize(data []byte) ([]byte, error) {
+	pval, err := parseDocument(data, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var w canonicalWriter
+	w.buf.WriteString(xml.Header)
+	w.buf.WriteString(xmlDOCTYPE)
+	w.buf.WriteString("\n<plist version=\"1.0\">\n")
+	if err := w.writeValue(pval, 0); err != nil {
+		return nil, err
+	}
+	w.buf.WriteString("</plist>\n")
+	return w.buf.Bytes(), nil
+}
+
+// Minify decodes the XML or binary plist in data and returns it as XML with
+// no whitespace between elements, empty arrays and dictionaries written as
+// <array/> and <dict/>, and no newline after </plist>. Dictionary keys are
+// sorted. Strings, data, dates and reals are kept as they are; only the text
+// of integers may change, like 007 becoming 7.
+func Minify(data []byte) ([]byte, error) {
+	pval, err := parseDocument(data, true)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := newXMLEncoder(&buf)
+	enc.selfClosingEmpty = true
+	enc.noFinalNewline = true
+	if err := enc.generateDocument(pval); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MarshalHash writes the canonical encoding of v in format to h, so that
+// values with the same content give the same hash whatever the order of their
+// keys, such as the fields of a struct encoded with PreserveFieldOrder or a
+// Dict. The XML encoding is the one returned by Canonicalize. The binary
+// encoding is that document encoded as a binary plist, so it has the same
+// sorted keys, 64 bit reals and dates truncated to the second. Either way, v
+// is encoded as an XML plist first, so UIDs are hashed as the CF$UID
+// dictionaries they are written as, and a UID hashes the same as a dictionary
+// holding only a CF$UID integer of the same value.
+func MarshalHash(v interface{}, h hash.Hash, format Format) error {
+	data, err := Marshal(v)
+	if err != nil {
+		return err
+	}
+	canonical, err := Canonicalize(data)
+	if err != nil {
+		return err
+	}
+	switch format {
+	case FormatXML:
+		_, err = h.Write(canonical)
+		return err
+	case FormatBinary:
+		pval, err := parseDocument(canonical, false)
+		if err != nil {
+			return err
+		}
+		return newBinaryEncoder(h).generateDocument(pval)
+	default:
+		return fmt.Errorf("plist: unsupported format %v", format)
+	}
+}
+
+// parseDocument parses the XML or binary plist in data. The text of XML
+// dates is kept as is if rawDates is set.
+func parseDocument(data []byte, rawDates bool) (*plistValue, error) {
+	d := Decoder{rawDates: rawDates}
+	if bytes.HasPrefix(data, binaryMagic) {
+		parser, err := d.newBinaryParser(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return parser.parseDocument()
+	}
+	return d.newXMLParser(bytes.NewReader(data)).parseDocument(nil)
+}
+
+type canonicalWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *canonicalWriter) writeValue(pval *plistValue, indent int) error {
+	w.writeIndent(indent)
+	switch pval.kind {
+	case String:
+		w.writeElement("string", canonicalEscape(pval.value.(string)))
+	case Integer:
+		i := pval.value.(signedInt)
+		if i.signed {
+			w.writeElement("integer", strconv.FormatInt(int64(i.value), 10))
+		} else {
+			w.writeElement("integer", strconv.FormatUint(i.value, 10))
+		}
+	case Real:
+		w.writeElement("real", canonicalReal(pval.value.(sizedFloat).value))
+	case Boolean:
+		if pval.value.(bool) {
+			w.buf.WriteString("<true/>\n")
+		} else {
+			w.buf.WriteString("<false/>\n")
+		}
+	case Date:
+		t, ok := pval.value.(time.Time)
+		if !ok {
+			return fmt.Errorf("plist: invalid date %v", pval.value)
+		}
+		w.writeElement("date", t.In(time.UTC).Format("2006-01-02T15:04:05Z"))
+	case Data:
+		w.writeData(pval.value.([]byte), indent)
+	case Array:
+		values := pval.value.([]*plistValue)
+		if len(values) == 0 {
+			w.buf.WriteString("<array/>\n")
+			return nil
+		}
+		w.buf.WriteString("<array>\n")
+		for _, v := range values {
+			if err := w.writeValue(v, indent+1); err != nil {
+				return err
+			}
+		}
+		w.writeIndent(indent)
+		w.buf.WriteString("</array>\n")
+	case Dictionary:
+		m := pval.value.(*dictionary).m
+		if len(m) == 0 {
+			w.buf.WriteString("<dict/>\n")
+			return nil
+		}
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return utf16Less(keys[i], keys[j]) })
+		w.buf.WriteString("<dict>\n")
+		for _, k := range keys {
+			w.writeIndent(indent + 1)
+			w.writeElement("key", canonicalEscape(k))
+			if err := w.writeValue(m[k], indent+1); err != nil {
+				return err
+			}
+		}
+		w.writeIndent(indent)
+		w.buf.WriteString("</dict>\n")
+	case UIDKind:
+		w.buf.WriteString("<dict>\n")
+		w.writeIndent(indent + 1)
+		w.writeElement("key", "CF$UID")
+		w.writeIndent(indent + 1)
+		w.writeElement("integer", strconv.FormatUint(uint64(pval.value.(UID)), 10))
+		w.writeIndent(indent)
+		w.buf.WriteString("</dict>\n")
+	default:
+		return fmt.Errorf("plist: %v can't be canonicalized", pval.kind)
+	}
+	return nil
+}
+
+func (w *canonicalWriter) writeIndent(indent int) {
+	for i := 0; i < indent; i++ {
+		w.buf.WriteByte('\t')
+	}
+}
+
+// writeElement writes an element with the already escaped text and a
+// newline.
+func (w *canonicalWriter) writeElement(name, text string) {
+	w.buf.WriteString("<" + name + ">" + text + "</" + name + ">\n")
+}
+
+// writeData writes a <data> element. Like CoreFoundation, the base64 lines
+// are indented by at most 8 tabs, and the indentation counts against the
+// line length of 76.
+func (w *canonicalWriter) writeData(data []byte, indent int) {
+	w.buf.WriteString("<data>\n")
+	lineIndent := indent
+	if lineIndent > 8 {
+		lineIndent = 8
+	}
+	lineLen := 76 - 8*lineIndent
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 0 {
+		n := lineLen
+		if n > len(encoded) {
+			n = len(encoded)
+		}
+		w.writeIndent(lineIndent)
+		w.buf.WriteString(encoded[:n])
+		w.buf.WriteByte('\n')
+		encoded = encoded[n:]
+	}
+	w.writeIndent(indent)
+	w.buf.WriteString("</data>\n")
+}
+
+var canonicalReplacer = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func canonicalEscape(s string) string {
+	return canonicalReplacer.Replace(s)
+}
+
+// canonicalReal formats f like CoreFoundation's XML writer.
+func canonicalReal(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "nan"
+	case math.IsInf(f, 1):
+		return "+infinity"
+	case math.IsInf(f, -1):
+		return "-infinity"
+	case f == 0:
+		return "0.0"
+	}
+	return strconv.FormatFloat(f, 'g', 17, 64)
+}
+
+// utf16Less reports whether a sorts before b by UTF-16 code units, which is
+// how CoreFoundation orders dictionary keys.
+func utf16Less(a, b string) bool {
+	ua, ub := utf16.Encode([]rune(a)), utf16.Encode([]rune(b))
+	for i := 0; i < len(ua) && i < len(ub); i++ {
+		if ua[i] != ub[i] {
+			return ua[i] < ub[i]
+		}
+	}
+	return len(ua) < len(ub)
+}
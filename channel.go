@@ -0,0 +1,120 @@
+package plist
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"reflect"
+)
+
+// DecodeArrayTo decodes the plist read from r, whose root value must be an
+// array, and sends each of its elements to ch as they would be stored in an
+// empty interface, like map[string]interface{} for dicts. The elements of an
+// XML plist are sent as they are parsed, so that large arrays can be fanned
+// out to other goroutines without holding the whole array in memory; binary
+// plists are read into memory first. The elements are decoded as with a
+// Decoder set up with opts, like WithStrict or WithTimeLocation; options that
+// don't apply to the root array, like WithRootPath, are ignored. ch is closed
+// when DecodeArrayTo returns, after the elements that decoded before any
+// error have been sent, so the error should be checked once ch is drained:
+//
+//	ch := make(chan interface{})
+//	errc := make(chan error, 1)
+//	go func() { errc <- plist.DecodeArrayTo(r, ch) }()
+//	for v := range ch {
+//		...
+//	}
+//	if err := <-errc; err != nil {
+//		...
+//	}
+func DecodeArrayTo(r io.Reader, ch chan<- interface{}, opts ...DecoderOption) error {
+	defer close(ch)
+	d := NewXMLDecoder(r, opts...)
+	if err := d.decompress(); err != nil {
+		return err
+	}
+	if !d.isBinary {
+		magic, err := d.peekMagic(len(binaryMagic))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(magic, binaryMagic) {
+			return d.sendArray(ch)
+		}
+		data, err := ioutil.ReadAll(d.reader)
+		if err != nil {
+			return err
+		}
+		d.reader, d.isBinary = bytes.NewReader(data), true
+	}
+	return d.sendBinaryArray(ch)
+}
+
+// sendBinaryArray sends the elements of the root array of a binary plist to
+// ch once it is parsed.
+func (d *Decoder) sendBinaryArray(ch chan<- interface{}) error {
+	parser, err := d.newBinaryParser(d.reader.(io.ReadSeeker))
+	if err != nil {
+		return err
+	}
+	pval, err := parser.parseDocument()
+	if err != nil {
+		return err
+	}
+	if pval.kind != Array {
+		return UnmarshalTypeError{describe(pval), reflect.TypeOf([]interface{}(nil))}
+	}
+	for _, elem := range pval.value.([]*plistValue) {
+		v, err := d.valueInterface(elem)
+		if err != nil {
+			return err
+		}
+		ch <- v
+	}
+	return nil
+}
+
+// sendArray sends the elements of the root array of an XML plist to ch as
+// they are parsed.
+func (d *Decoder) sendArray(ch chan<- interface{}) error {
+	p := d.newXMLParser(d.reader)
+	var start *xml.StartElement
+	for start == nil || start.Name.Local == "plist" {
+		var err error
+		if start, err = p.nextStart(); err != nil {
+			return err
+		}
+		if start == nil {
+			return ErrEmptyDocument
+		}
+	}
+	if start.Name.Local != "array" {
+		pval, err := p.parseXMLElement(start)
+		if err != nil {
+			return err
+		}
+		return UnmarshalTypeError{describe(pval), reflect.TypeOf([]interface{}(nil))}
+	}
+	if err := p.limit.add(); err != nil {
+		return err
+	}
+	for {
+		el, err := p.nextStart()
+		if err != nil {
+			return err
+		}
+		if el == nil {
+			return nil
+		}
+		pval, err := p.parseXMLElement(el)
+		if err != nil {
+			return err
+		}
+		v, err := d.valueInterface(pval)
+		if err != nil {
+			return err
+		}
+		ch <- v
+	}
+}
@@ -0,0 +1,58 @@
+package plist
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Commented wraps a value so that it is encoded with an XML comment before
+// it. Within a dictionary the comment is written before the <key> of the
+// entry. Comment must not contain "--", which XML doesn't allow in comments.
+// Decoders skip comments, so the commented value decodes like Value itself.
+type Commented struct {
+	Comment string
+	Value   interface{}
+}
+
+var commentedType = reflect.TypeOf(Commented{})
+
+// commented is the kind of the plistValues made for Commented values. Their
+// value is a commentedValue. It is only used by encoders.
+const commented Kind = 1 << 8
+
+type commentedValue struct {
+	comment string
+	value   *plistValue
+}
+
+// FieldComments sets whether struct fields with a plistComment tag, like
+//
+//	Port int `plist:"Port" plistComment:"The port of the proxy server"`
+//
+// are encoded with the text of the tag as an XML comment before their key, as
+// if they held a Commented value, so that generated documents explain
+// themselves. The tag is ignored when FieldComments is off, the default, and
+// comments aren't written to binary plists.
+func (e *Encoder) FieldComments(comments bool) {
+	e.fieldComments = comments
+}
+
+// commentField wraps the encoded value of the struct field f with the comment
+// of its plistComment tag.
+func commentField(f field, value *plistValue) (*plistValue, error) {
+	if strings.Contains(f.comment, "--") {
+		return nil, &UnsupportedValueError{reflect.ValueOf(f.comment), f.comment}
+	}
+	return &plistValue{commented, commentedValue{f.comment, value}}, nil
+}
+
+func (e *Encoder) marshalCommented(c Commented) (*plistValue, error) {
+	if strings.Contains(c.Comment, "--") {
+		return nil, &UnsupportedValueError{reflect.ValueOf(c), c.Comment}
+	}
+	pval, err := e.marshalValue(reflect.ValueOf(c.Value))
+	if err != nil || pval == nil {
+		return nil, err
+	}
+	return &plistValue{commented, commentedValue{c.Comment, pval}}, nil
+}
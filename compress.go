@@ -0,0 +1,100 @@
+package plist
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+)
+
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+)
+
+// AutoDecompress sets whether the decoder checks its input for gzip or bzip2
+// compression, like that of .plist.gz backups, and decompresses it before
+// parsing. Whether the decompressed plist is XML or binary is then detected
+// from its contents, whichever constructor created the decoder; uncompressed
+// input is read in the decoder's own format. XML plists are decompressed as
+// they are read, while binary plists, which are read by seeking, are
+// decompressed into memory. It is off by default.
+func (d *Decoder) AutoDecompress(auto bool) {
+	d.autoDecompress = auto
+}
+
+// decompress replaces the input of the decoder with its decompressed
+// contents, the first time it is called, if it is compressed and
+// AutoDecompress is set.
+func (d *Decoder) decompress() error {
+	if !d.autoDecompress || d.decompressed {
+		return nil
+	}
+	d.decompressed = true
+	magic, err := d.peekMagic(len(bzip2Magic))
+	if err != nil {
+		return err
+	}
+	var r io.Reader
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		zr, err := gzip.NewReader(d.reader)
+		if err != nil {
+			return err
+		}
+		r = zr
+	case bytes.HasPrefix(magic, bzip2Magic):
+		r = bzip2.NewReader(d.reader)
+	default:
+		return nil
+	}
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(len(binaryMagic)); err != nil && err != io.EOF {
+		return err
+	} else if !bytes.Equal(magic, binaryMagic) {
+		d.reader, d.isBinary = br, false
+		return nil
+	}
+	data, err := ioutil.ReadAll(br)
+	if err != nil {
+		return err
+	}
+	d.reader, d.isBinary = bytes.NewReader(data), true
+	return nil
+}
+
+// peekMagic returns up to the first n bytes of the input without consuming
+// them.
+func (d *Decoder) peekMagic(n int) ([]byte, error) {
+	if d.isBinary {
+		r, ok := d.reader.(io.ReadSeeker)
+		if !ok {
+			return nil, nil
+		}
+		offset, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		magic := make([]byte, n)
+		n, err = io.ReadFull(r, magic)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return magic[:n], nil
+	}
+	br, ok := d.reader.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(d.reader)
+		d.reader = br
+	}
+	magic, err := br.Peek(n)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return magic, nil
+}
@@ -0,0 +1,82 @@
+package plist
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+)
+
+var emptyInterfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+
+// A ConstructorFunc chooses the Go value a dictionary is decoded into when it
+// is stored in an interface, such as the elements of a []Payload or a
+// []interface{}. It is called with a probe of the dictionary and returns a
+// non-nil pointer to decode the dictionary into, or nil to decode it as
+// usual: as a registered payload type, or as a map[string]interface{} for an
+// empty interface.
+//
+// The probe holds the keys of the dictionary whose values are strings,
+// numbers, booleans, dates or data, decoded as for an empty interface. Keys
+// holding dictionaries or arrays are left out, so that choosing a type never
+// decodes the nested values.
+type ConstructorFunc func(probe map[string]interface{}) interface{}
+
+// DecodeInto is like Unmarshal, but decodes the dictionaries stored in
+// interfaces into the values returned by fn. See ConstructorFunc.
+func DecodeInto(data []byte, v interface{}, fn ConstructorFunc) error {
+	var d *Decoder
+	if bytes.HasPrefix(data, binaryMagic) {
+		d = NewBinaryDecoder(bytes.NewReader(data))
+	} else {
+		d = NewXMLDecoder(bytes.NewReader(data))
+	}
+	d.SetConstructor(fn)
+	return d.Decode(v)
+}
+
+// SetConstructor sets the function that chooses the Go values dictionaries
+// stored in interfaces are decoded into. See ConstructorFunc.
+func (d *Decoder) SetConstructor(fn ConstructorFunc) {
+	d.constructor = fn
+}
+
+// construct decodes the dictionary pval into the value returned by the
+// decoder's constructor, and returns the value to store in an interface of
+// type t. It returns nil if there is no constructor or pval isn't a
+// dictionary, or if the constructor returned nil. If the returned pointer
+// doesn't implement t but the value it points to does, that value is stored.
+func (d *Decoder) construct(pval *plistValue, t reflect.Type) (interface{}, error) {
+	if d.constructor == nil || pval.kind != Dictionary {
+		return nil, nil
+	}
+	probe := make(map[string]interface{})
+	for k, sval := range pval.value.(*dictionary).m {
+		if sval.kind == Dictionary || sval.kind == Array {
+			continue
+		}
+		iface, err := d.valueInterface(sval)
+		if err != nil {
+			return nil, err
+		}
+		probe[k] = iface
+	}
+	target := d.constructor(probe)
+	if target == nil {
+		return nil, nil
+	}
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return nil, fmt.Errorf("plist: constructor returned non-pointer %T", target)
+	}
+	if err := d.unmarshal(pval, v); err != nil {
+		return nil, err
+	}
+	switch {
+	case v.Type().Implements(t):
+		return target, nil
+	case v.Elem().Type().Implements(t):
+		return v.Elem().Interface(), nil
+	default:
+		return nil, fmt.Errorf("plist: constructor returned %T, which doesn't implement %v", target, t)
+	}
+}
@@ -0,0 +1,118 @@
+package plist
+
+import (
+	"bufio"
+	"encoding/base64"
+	"strings"
+)
+
+// A DataFormat is the layout of the base64 text of XML <data> elements, set
+// with Encoder.SetDataFormat. The zero DataFormat, the default, writes the
+// text on one line between <data> and </data>.
+type DataFormat struct {
+	// LineWidth is the largest number of base64 characters on one line. The
+	// text isn't wrapped if it is 0.
+	LineWidth int
+	// LevelWidth is taken off LineWidth for every level of indentation of
+	// the <data> element, for tools that keep their lines, indentation
+	// included, within a width. Lines hold at least 4 characters.
+	LevelWidth int
+	// Indent is written at the start of every line of text that starts on a
+	// line of its own, after the indentation of the <data> element.
+	Indent string
+	// Newlines sets whether the text starts on the line after <data>, and
+	// </data> on the line after the text, indented like <data>.
+	Newlines bool
+}
+
+// AppleDataFormat is the layout of <data> written by CoreFoundation, the most
+// common source of XML plists, when used with an indentation of one tab: the
+// text is on lines of its own, indented like the <data> element, with lines
+// of 76 columns, counting each tab as 8 columns.
+var AppleDataFormat = DataFormat{LineWidth: 76, LevelWidth: 8, Newlines: true}
+
+// SetDataFormat sets the layout of the base64 text of <data> elements, so
+// that the output of other tools can be reproduced byte for byte. See
+// DataFormat and AppleDataFormat. Binary plists are unaffected.
+func (e *Encoder) SetDataFormat(f DataFormat) {
+	e.dataFormat = f
+}
+
+// writeDataValue writes a <data> element laid out with the DataFormat of e. The
+// base64 encoding of the data is streamed to the writer, rather than building
+// the encoded string, to keep memory use down for large data.
+func (e *xmlEncoder) writeDataValue(pval *plistValue) error {
+	if err := e.writeStart("data"); err != nil {
+		return err
+	}
+	f := e.dataFormat
+	// The start element has been written, so the element is one level up.
+	level := e.depth - 1
+	if level < 0 {
+		level = 0
+	}
+	elemIndent := strings.Repeat(e.indent, level)
+	lineStart := e.newline + elemIndent + f.Indent
+	if f.Newlines {
+		if _, err := e.w.WriteString(lineStart); err != nil {
+			return err
+		}
+	}
+	w := &dataLineWriter{w: e.w, lineStart: lineStart}
+	if f.LineWidth > 0 {
+		w.width = f.LineWidth - f.LevelWidth*level
+		if w.width < 4 {
+			w.width = 4
+		}
+	}
+	enc := base64.NewEncoder(base64.StdEncoding, w)
+	if _, err := enc.Write(pval.value.([]byte)); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	if f.Newlines {
+		if _, err := e.w.WriteString(e.newline + elemIndent); err != nil {
+			return err
+		}
+		// The end element follows on the line just started.
+		e.indentedIn = true
+	}
+	return e.writeEnd("data")
+}
+
+// dataLineWriter writes base64 text, starting a new line with lineStart
+// whenever a line holds width characters, unless width is 0.
+type dataLineWriter struct {
+	w         *bufio.Writer
+	width     int
+	lineStart string
+	n         int // characters on the current line
+}
+
+func (w *dataLineWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if w.width > 0 {
+			if w.n == w.width {
+				if _, err := w.w.WriteString(w.lineStart); err != nil {
+					return written, err
+				}
+				w.n = 0
+			}
+			if len(chunk) > w.width-w.n {
+				chunk = chunk[:w.width-w.n]
+			}
+		}
+		n, err := w.w.Write(chunk)
+		written += n
+		w.n += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
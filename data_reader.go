@@ -0,0 +1,144 @@
+package plist
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DataReader returns a reader for the contents of the data value at path,
+// which is given as for UnmarshalPath, so that large data can be copied
+// elsewhere without holding it in memory.
+//
+// The input is only read up to the start of the value. The returned reader
+// then reads the data directly from the input as it is consumed: binary data
+// is read as is, and the base64 text of an XML <data> element is decoded as it
+// is read, using a fixed amount of memory whatever the size of the data.
+// Skipping to the value still reads the elements before it, holding the text
+// of at most one of them in memory at a time. The reader can only be consumed
+// once, and the decoder and its input can't be used for anything else until
+// it has been read to the end. Character references like &#10; are not
+// supported inside streamed XML data. The options of the decoder that apply
+// to parsing, like SetStrict, SetMaxElements and AutoDecompress, apply to the
+// elements read.
+func (d *Decoder) DataReader(path ...string) (io.Reader, error) {
+	if err := d.decompress(); err != nil {
+		return nil, err
+	}
+	if d.isBinary {
+		r, ok := d.reader.(io.ReadSeeker)
+		if !ok {
+			return nil, errors.New("plist: binary plist decoder requires an io.ReadSeeker")
+		}
+		return d.binaryDataReader(r, path)
+	}
+
+	// xml.Decoder reads directly from an io.ByteReader, so the input is
+	// positioned just after the start element once it is returned. The last
+	// bytes read tell whether the element was self closing.
+	tr := &trackingReader{r: d.reader.(*bufio.Reader)}
+	p := d.newXMLParser(tr)
+	start, _, err := p.findPath(path)
+	if err != nil {
+		return nil, err
+	}
+	if start.Name.Local != "data" {
+		return nil, fmt.Errorf("plist: value at path %q is not data: %s", path, start.Name.Local)
+	}
+	if tr.prev == '/' && tr.last == '>' {
+		return strings.NewReader(""), nil
+	}
+	return base64.NewDecoder(base64.StdEncoding, &xmlDataReader{r: tr.r}), nil
+}
+
+func (d *Decoder) binaryDataReader(r io.ReadSeeker, path []string) (io.Reader, error) {
+	parser, err := d.newBinaryParser(r)
+	if err != nil {
+		return nil, err
+	}
+	index, err := (&BinaryReader{parser: parser}).Resolve(path...)
+	if err != nil {
+		return nil, err
+	}
+	marker, err := parser.seekObject(index)
+	if err != nil {
+		return nil, err
+	}
+	if marker>>4 != 0x4 {
+		return nil, fmt.Errorf("plist: value at path %q is not data: %v", path, markerKind(marker))
+	}
+	count, err := parser.readCount(marker)
+	if err != nil {
+		return nil, err
+	}
+	return io.LimitReader(r, int64(count)), nil
+}
+
+// trackingReader is an io.ByteReader that remembers the last two bytes read.
+type trackingReader struct {
+	r          *bufio.Reader
+	prev, last byte
+}
+
+func (t *trackingReader) ReadByte() (byte, error) {
+	b, err := t.r.ReadByte()
+	if err == nil {
+		t.prev, t.last = t.last, b
+	}
+	return b, err
+}
+
+func (t *trackingReader) Read(p []byte) (int, error) {
+	return t.r.Read(p)
+}
+
+// xmlDataReader reads the base64 text of a <data> element up to its end
+// element, leaving out whitespace.
+type xmlDataReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (x *xmlDataReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) && x.err == nil {
+		b, err := x.r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			x.err = err
+			break
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n':
+		case '<':
+			x.err = x.readEnd()
+		case '&':
+			x.err = errors.New("plist: character references are not supported in streamed data")
+		default:
+			p[n] = b
+			n++
+		}
+	}
+	if n > 0 {
+		return n, nil
+	}
+	return 0, x.err
+}
+
+// readEnd reads the rest of the element that started with '<', which must be
+// </data>, and returns io.EOF.
+func (x *xmlDataReader) readEnd() error {
+	tag, err := x.r.ReadString('>')
+	if err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	if strings.TrimSpace(strings.TrimSuffix(tag, ">")) != "/data" {
+		return fmt.Errorf("plist: unexpected <%s in data", tag)
+	}
+	return io.EOF
+}
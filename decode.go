@@ -1,11 +1,17 @@
 package plist
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -16,37 +22,380 @@ type Unmarshaler interface {
 	UnmarshalPlist(f func(interface{}) error) error
 }
 
+// binaryMagic is the prefix shared by all versions of the binary plist format.
+var binaryMagic = []byte("bplist0")
+
 // Unmarshal parses the plist-encoded data and stores the result in the value pointed to by v.
 func Unmarshal(data []byte, v interface{}) error {
 	// Check for binary plist here before setting up the decoder.
-	if bytes.HasPrefix(data, []byte("bplist0")) {
+	if bytes.HasPrefix(data, binaryMagic) {
 		return NewBinaryDecoder(bytes.NewReader(data)).Decode(v)
 	}
 	return NewXMLDecoder(bytes.NewReader(data)).Decode(v)
 }
 
+// ErrPathNotFound is returned, wrapped with the path, when a path leads to no
+// value.
+var ErrPathNotFound = errors.New("plist: no value at path")
+
+// ErrEmptyDocument is returned when decoding an XML plist that holds no value,
+// like <plist version="1.0"></plist>, which some tools write as a
+// placeholder. The value being decoded into is left unchanged.
+var ErrEmptyDocument = errors.New("plist: empty document")
+
+func errPathNotFound(path []string) error {
+	return fmt.Errorf("%w %q", ErrPathNotFound, path)
+}
+
+// UnmarshalPath parses the plist-encoded data and stores the value found at
+// path in the value pointed to by v. Each element of path is a dictionary
+// key, or a decimal index when the value it applies to is an array. Only the
+// value at path is decoded: binary plists are navigated through their offset
+// table, and the elements of XML plists that aren't on the path are skipped
+// without being decoded. If there is no value at path, the returned error
+// wraps ErrPathNotFound.
+func UnmarshalPath(data []byte, v interface{}, path ...string) error {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr {
+		return errors.New("plist: non-pointer passed to UnmarshalPath")
+	}
+	if bytes.HasPrefix(data, binaryMagic) {
+		r, err := NewBinaryReader(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		return r.DecodePath(v, path...)
+	}
+	var d Decoder
+	pval, err := d.newXMLParser(bytes.NewReader(data)).parsePath(path)
+	if err != nil {
+		return err
+	}
+	return d.unmarshal(pval, val.Elem())
+}
+
 // A Decoder reads and decodes Apple plist objects from an input stream.
 // The plists can be in XML or binary format.
 type Decoder struct {
 	reader   io.Reader // binary decoders assert this to io.ReadSeeker
 	isBinary bool      // true if this is a binary plist
+
+	alloc       Allocator       // allocates maps and slices of interface{} trees if set
+	constructor ConstructorFunc // chooses the values of dictionaries in interfaces if set
+
+	captureComments bool
+	comments        []Comment
+
+	recordLocations bool
+	locations       []Location
+
+	rawDates     bool
+	subsecond    bool           // date strings keep fractions of seconds if set
+	timeLocation *time.Location // location of decoded dates if set
+	strict       bool
+	trimStrings  bool
+	realParser   func(string) (float64, error)
+	replaceUTF8  bool
+	maxElements  int
+	nestedDepth  int // levels of plists in data to decode, see nested.go
+	nestedLevel  int
+
+	onlyKeys map[string]bool // keys of the root dict to parse if set, see onlykeys.go
+	rootPath []string        // path of the value decoded instead of the root, if set
+
+	autoDecompress bool // see compress.go
+	decompressed   bool // the input has been checked for compression
+
+	trailer *Trailer // trailer of the last binary plist decoded
+
+	tokens *tokenReader // state of the document read with Token, if any
+
+	disallowUnknownFields bool
+	disallowAmbiguousKeys bool
+	mergeIntoMap          bool
+	unwrapSingleton       bool
+	coerceToString        bool
+	rawFallback           *RawValue // stores mismatched root values if set
+	orderedDicts          bool      // decodes dictionaries in interfaces as *Dict, within a Dict
+	collectErrors         bool
+	path                  []string // key path of the value being decoded, kept when collecting errors
+	innerErr              bool     // set once an error is returned below the root, see fallBack
+	errs                  DecodeErrors
+}
+
+// A Comment is an XML comment read by a Decoder.
+type Comment struct {
+	Text   string // the text between <!-- and -->
+	Offset int64  // byte offset of the comment from the start of the document
+}
+
+// A Location records where a value was found in an XML plist read by a
+// Decoder. Path is the key path of the value from the root value, with array
+// elements given by their decimal index, and is empty for the root value.
+// Key is the location of the <key> element of a dict value, and is zero for
+// other values.
+type Location struct {
+	Path  []string
+	Key   Span
+	Value Span
+}
+
+// A Span is a range of bytes in the input of a Decoder, from the start of an
+// element up to, but not including, the byte after its end.
+type Span struct {
+	Start, End int64
 }
 
 // NewDecoder returns a new XML plist decoder.
 // DEPRECATED: Please use NewXMLDecoder instead.
-func NewDecoder(r io.Reader) *Decoder {
-	return NewXMLDecoder(r)
+func NewDecoder(r io.Reader, opts ...DecoderOption) *Decoder {
+	return NewXMLDecoder(r, opts...)
 }
 
 // NewXMLDecoder returns a new decoder that reads an XML plist from r.
-func NewXMLDecoder(r io.Reader) *Decoder {
-	return &Decoder{reader: r, isBinary: false}
+// The decoder introduces its own buffering and may read data from r beyond
+// the plist requested.
+func NewXMLDecoder(r io.Reader, opts ...DecoderOption) *Decoder {
+	d := &Decoder{reader: bufio.NewReader(r), isBinary: false}
+	return d.apply(opts)
 }
 
 // NewBinaryDecoder returns a new decoder that reads a binary plist from r.
 // No error checking is done to make sure that r is actually a binary plist.
-func NewBinaryDecoder(r io.ReadSeeker) *Decoder {
-	return &Decoder{reader: r, isBinary: true}
+func NewBinaryDecoder(r io.ReadSeeker, opts ...DecoderOption) *Decoder {
+	d := &Decoder{reader: r, isBinary: true}
+	return d.apply(opts)
+}
+
+// SetAllocator sets the Allocator the decoder uses for the maps and slices it
+// creates when decoding into an empty interface. By default they are
+// allocated with make.
+func (d *Decoder) SetAllocator(a Allocator) {
+	d.alloc = a
+}
+
+// RawDates sets whether the text of XML <date> elements is kept as is instead
+// of being parsed, so that dates in non-standard formats can be decoded into
+// string fields and re-encoded exactly (using the date tag option). Such
+// dates decode into an empty interface as a string, and are only parsed if
+// decoded into a time.Time. Without RawDates, a date decoded into a string is
+// formatted as RFC 3339 in UTC, which is how dates are encoded.
+func (d *Decoder) RawDates(raw bool) {
+	d.rawDates = raw
+}
+
+// SubsecondDateStrings sets whether dates decoded into strings are formatted
+// with the fraction of their second, the way Encoder.SubsecondDates writes
+// them, instead of to the second. Dates decoded into a time.Time always keep
+// the fraction.
+func (d *Decoder) SubsecondDateStrings(subsecond bool) {
+	d.subsecond = subsecond
+}
+
+// SetTimeLocation sets the location of the time.Time values the decoder
+// stores, such as time.UTC, so that dates written with different UTC offsets
+// are decoded consistently. By default, dates in XML plists keep the UTC
+// offset they are written with, and dates in binary plists, which have none,
+// are in UTC. A nil loc restores the default.
+func (d *Decoder) SetTimeLocation(loc *time.Location) {
+	d.timeLocation = loc
+}
+
+// inLocation returns t in the location set with SetTimeLocation, if any.
+func (d *Decoder) inLocation(t time.Time) time.Time {
+	if d.timeLocation == nil {
+		return t
+	}
+	return t.In(d.timeLocation)
+}
+
+// SetStrict sets whether the decoder rejects XML <integer>, <real> and <date>
+// elements with whitespace around their text, like <integer> 42 </integer>,
+// <data> elements that aren't standard padded base64, and binary strings that
+// aren't valid UTF-8 or UTF-16. By default the whitespace is ignored, URL-safe
+// and unpadded base64 are accepted too, and invalid strings are decoded as
+// described for ReplaceInvalidUTF8.
+func (d *Decoder) SetStrict(strict bool) {
+	d.strict = strict
+}
+
+// TrimStringSpace sets whether the decoder removes the spaces, tabs and line
+// breaks around the text of XML <string> elements, for documents written by
+// tools that pad their strings by mistake. It only affects string values:
+// dict keys, and the text of other elements like <data>, are left as they
+// are, and so are the strings of binary plists. By default strings are
+// decoded exactly as they are written.
+func (d *Decoder) TrimStringSpace(trim bool) {
+	d.trimStrings = trim
+}
+
+// SetRealParser sets a function that parses the text of XML <real> elements
+// that aren't valid numbers, like the 1,5 written by tools that format reals
+// for the locale, instead of returning an error. It is given the text without
+// surrounding whitespace, and its error is returned if it fails too. Valid
+// reals are parsed as usual, and in strict mode the function isn't used.
+// Binary plists store reals in binary, so it doesn't apply to them.
+func (d *Decoder) SetRealParser(fn func(string) (float64, error)) {
+	d.realParser = fn
+}
+
+// SetMaxElements limits the number of elements of an XML plist, or objects of
+// a binary plist, that Decode reads to n, to protect against documents that
+// are valid but too large to handle. Decode returns an error once the limit
+// is exceeded. Dict keys count as elements, and binary objects count each
+// time they are referenced. A limit of 0, the default, means no limit.
+func (d *Decoder) SetMaxElements(n int) {
+	d.maxElements = n
+}
+
+// SetRootPath sets the path of the value Decode decodes instead of the root
+// value of each document, so that a struct can be decoded from a value nested
+// in the document, like the first payload of a profile with
+// SetRootPath("PayloadContent", "0"). The path is given as for UnmarshalPath
+// and only the value it leads to is decoded: binary plists are navigated
+// through their offset table, and the elements of XML plists that aren't on
+// the path are skipped. Other options that apply to the root value, like
+// OnlyKeys and SetRawFallback, apply to this value instead. If there is no
+// value at path, Decode returns an error wrapping ErrPathNotFound. Calling
+// SetRootPath without a path, the default, decodes the root value.
+func (d *Decoder) SetRootPath(path ...string) {
+	d.rootPath = nil
+	if len(path) > 0 {
+		d.rootPath = append([]string(nil), path...)
+	}
+}
+
+// elementLimit counts the elements parsed from a document against the limit
+// set with SetMaxElements.
+type elementLimit struct {
+	max, n int
+}
+
+func (l *elementLimit) add() error {
+	if l.max <= 0 {
+		return nil
+	}
+	l.n++
+	if l.n > l.max {
+		return fmt.Errorf("plist: document has more than %d elements", l.max)
+	}
+	return nil
+}
+
+// CaptureComments sets whether the decoder records the XML comments it reads,
+// to be returned by Comments. Comments are skipped regardless. Only comments
+// between elements are recorded, not comments inside the text of an element
+// such as a <string>. Binary plists have no comments.
+func (d *Decoder) CaptureComments(capture bool) {
+	d.captureComments = capture
+}
+
+// Comments returns the comments recorded by the last call to Decode, in the
+// order they appeared, if CaptureComments is enabled.
+func (d *Decoder) Comments() []Comment {
+	return d.comments
+}
+
+// RecordLocations sets whether the decoder records the location of every
+// value it reads, and of the keys of dict values, to be returned by
+// Locations. Binary plists have no locations recorded.
+func (d *Decoder) RecordLocations(record bool) {
+	d.recordLocations = record
+}
+
+// Locations returns the locations recorded by the last call to Decode, in
+// document order, if RecordLocations is enabled. Locations are recorded up to
+// the point where decoding stopped if the document is malformed.
+func (d *Decoder) Locations() []Location {
+	return d.locations
+}
+
+// BinaryTrailer returns the trailer of the binary plist read by the last call
+// to Decode, or nil if the decoder reads XML plists, Decode hasn't been
+// called or the trailer couldn't be read. The trailer is available even if
+// decoding the objects failed. The returned value is not modified by later
+// calls to Decode.
+func (d *Decoder) BinaryTrailer() *Trailer {
+	return d.trailer
+}
+
+// DisallowUnknownFields causes Decode to return an error when a dict decoded
+// into a struct has a key that doesn't match any of the struct's fields.
+// Like every Decoder option, it applies at every level of the value, including
+// structs nested in maps, slices and pointers, registered payload types and
+// values decoded by an Unmarshaler's callback.
+func (d *Decoder) DisallowUnknownFields() {
+	d.disallowUnknownFields = true
+}
+
+// MergeIntoMap sets whether a dict decoded into a map entry that already
+// exists is merged into the entry's value, instead of replacing the value,
+// which is the default. Either way, like encoding/json, the entries of a
+// non-nil map whose keys aren't in the dict are kept, so a map can be
+// pre-populated with defaults. Merging keeps defaults at every level: fields
+// and entries of existing struct and map values that the dict doesn't set are
+// kept too. Values held in interfaces are always replaced.
+func (d *Decoder) MergeIntoMap(merge bool) {
+	d.mergeIntoMap = merge
+}
+
+// CoerceToString sets whether scalar values are decoded into strings as
+// text, so that any plist of scalars can be decoded into a map[string]string
+// or string fields, instead of being an UnmarshalTypeError, the default. The
+// text is:
+//
+//   - integers in decimal, with a minus sign if negative
+//   - reals in the shortest decimal form that parses back to the same value,
+//     like strconv.FormatFloat with 'g' and a precision of -1
+//   - true or false for booleans
+//   - dates in RFC 3339 format to the second, in the location set with
+//     SetTimeLocation, or else with the offset written in an XML date and in
+//     UTC for a binary one, or as written if RawDates is set
+//   - data in standard base64, with padding
+//
+// Arrays and dictionaries can't be coerced.
+func (d *Decoder) CoerceToString(coerce bool) {
+	d.coerceToString = coerce
+}
+
+// UnwrapSingleton sets whether a plist whose root is an array holding a
+// single dict can be decoded into a struct, as if the root were the dict, for
+// sources that sometimes wrap a value in an array. An array of any other
+// length is an error when decoding into a struct. By default the root must be
+// a dict to decode into a struct. Arrays below the root aren't unwrapped.
+func (d *Decoder) UnwrapSingleton(unwrap bool) {
+	d.unwrapSingleton = unwrap
+}
+
+// unwrap returns the dict in the single element array pval if the decoder
+// unwraps singletons and v is a struct.
+func (d *Decoder) unwrap(pval *plistValue, v reflect.Value) (*plistValue, error) {
+	t := v.Type()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if !d.unwrapSingleton || pval.kind != Array || t.Kind() != reflect.Struct {
+		return pval, nil
+	}
+	values := pval.value.([]*plistValue)
+	if len(values) != 1 {
+		return nil, fmt.Errorf("plist: array of %d elements can't be decoded into a single %v", len(values), t)
+	}
+	if values[0].kind != Dictionary {
+		return pval, nil
+	}
+	return values[0], nil
+}
+
+// CollectErrors sets whether Decode continues after a problem with a value
+// instead of returning the first one, which is the default. The problems are
+// returned together as DecodeErrors: values that don't fit the Go type they
+// are decoded into, missing required keys, unknown keys if
+// DisallowUnknownFields is set, and duplicate dict keys, which are otherwise
+// ignored with the last value winning. Values with problems are left
+// unchanged. Malformed documents still stop decoding at the first error.
+func (d *Decoder) CollectErrors(collect bool) {
+	d.collectErrors = collect
 }
 
 // Decode reads the next plist-encoded value from its input and stores it in
@@ -57,37 +406,299 @@ func (d *Decoder) Decode(v interface{}) error {
 	if val.Kind() != reflect.Ptr {
 		return errors.New("plist: non-pointer passed to Unmarshal")
 	}
-	var pval *plistValue
+	pval, err := d.parse()
+	if err != nil {
+		return err
+	}
+	return d.decodeValue(pval, val.Elem())
+}
+
+// DecodeBoth is like Decode, but also returns the value as Decode would store
+// it in an empty interface, such as a map[string]interface{} holding the keys
+// that v has no fields for. The input is parsed once for both. Data values
+// are shared between v and the returned value rather than copied.
+func (d *Decoder) DecodeBoth(v interface{}) (interface{}, error) {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr {
+		return nil, errors.New("plist: non-pointer passed to DecodeBoth")
+	}
+	pval, err := d.parse()
+	if err != nil {
+		return nil, err
+	}
+	if err := d.decodeValue(pval, val.Elem()); err != nil {
+		return nil, err
+	}
+	return d.valueInterface(pval)
+}
+
+// parse reads the next plist from the decoder's input.
+func (d *Decoder) parse() (*plistValue, error) {
+	if d.tokens != nil {
+		return nil, errors.New("plist: Decode called after Token")
+	}
+	if err := d.decompress(); err != nil {
+		return nil, err
+	}
 	if d.isBinary {
 		// For binary decoder, type assert the reader to an io.ReadSeeker
-		var err error
 		r, ok := d.reader.(io.ReadSeeker)
 		if !ok {
-			return fmt.Errorf("binary plist decoder requires an io.ReadSeeker")
+			return nil, fmt.Errorf("binary plist decoder requires an io.ReadSeeker")
 		}
-		parser, err := newBinaryParser(r)
+		d.trailer = nil
+		parser, err := d.newBinaryParser(r)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		d.trailer = parser.plistTrailer.export()
+		root := parser.RootObject
+		if d.rootPath != nil {
+			if root, err = (&BinaryReader{parser: parser}).Resolve(d.rootPath...); err != nil {
+				return nil, err
+			}
+		}
+		// The filter is set after resolving the path, which parses the
+		// keys along it.
+		parser.keyFilter = d.onlyKeys
+		return parser.parseObjectRef(root)
+	}
+	parser := d.newXMLParser(d.reader)
+	parser.keyFilter = d.onlyKeys
+	d.comments = nil
+	if d.captureComments {
+		parser.comments = &d.comments
+	}
+	d.locations = nil
+	if d.recordLocations {
+		parser.locations = &d.locations
+	}
+	if d.rootPath != nil {
+		return parser.parsePath(d.rootPath)
+	}
+	return parser.parseDocument(nil)
+}
+
+// newXMLParser returns a parser of the XML plist in r set up with the options
+// of d that apply to parsing.
+func (d *Decoder) newXMLParser(r io.Reader) *xmlParser {
+	p := newXMLParser(r)
+	p.rawDates = d.rawDates
+	p.strict = d.strict
+	p.trimStr = d.trimStrings
+	p.realParser = d.realParser
+	p.limit.max = d.maxElements
+	return p
+}
+
+// newBinaryParser returns a parser of the binary plist in r set up with the
+// options of d that apply to parsing.
+func (d *Decoder) newBinaryParser(r io.ReadSeeker) (*binaryParser, error) {
+	p, err := newBinaryParser(r)
+	if err != nil {
+		return nil, err
+	}
+	p.limit.max = d.maxElements
+	p.strict = d.strict
+	p.replaceUTF8 = d.replaceUTF8
+	return p, nil
+}
+
+// decodeValue decodes the parsed plist pval into v, collecting errors if
+// enabled.
+func (d *Decoder) decodeValue(pval *plistValue, v reflect.Value) error {
+	d.path, d.errs, d.innerErr = nil, nil, false
+	pval, err := d.unwrap(pval, v)
+	if err != nil {
+		return err
+	}
+	err = d.unmarshal(pval, v)
+	if ok, ferr := d.fallBack(pval, v, err); ok {
+		return ferr
+	}
+	if err := d.collect(err); err != nil {
+		return err
+	}
+	if len(d.errs) > 0 {
+		return d.errs
+	}
+	return nil
+}
+
+// enter adds key to the key path of the value being decoded, which is given
+// by collected errors and RequiredKeyErrors.
+func (d *Decoder) enter(key string) {
+	d.path = append(d.path, key)
+}
+
+// leave removes the last key added by enter.
+func (d *Decoder) leave() {
+	d.path = d.path[:len(d.path)-1]
+}
+
+// collect records err with the current key path and returns nil if the
+// decoder is collecting errors, so that decoding continues. Otherwise it
+// returns err.
+func (d *Decoder) collect(err error) error {
+	if err != nil && len(d.path) > 0 {
+		d.innerErr = true
+	}
+	if err == nil || !d.collectErrors {
+		return err
+	}
+	d.errs = append(d.errs, &PathError{Path: strings.Join(d.path, "."), Err: err})
+	return nil
+}
+
+// checkKeys reports the duplicate keys of dict when collecting errors.
+func (d *Decoder) checkKeys(dict *dictionary) {
+	if !d.collectErrors {
+		return
+	}
+	for _, k := range dict.duplicates {
+		d.enter(k)
+		d.collect(fmt.Errorf("plist: duplicate key %q", k))
+		d.leave()
+	}
+}
+
+// checkUnknownKeys returns an error for each key of dict that doesn't match a
+// field of the struct type typ if unknown fields are disallowed.
+func (d *Decoder) checkUnknownKeys(dict *dictionary, plan *decodePlan, typ reflect.Type) error {
+	if !d.disallowUnknownFields || plan.root != nil {
+		return nil
+	}
+	var unknown []string
+	for k := range dict.m {
+		if !plan.known[k] {
+			unknown = append(unknown, k)
 		}
-		pval, err = parser.parseDocument()
+	}
+	sort.Strings(unknown)
+	for _, k := range unknown {
+		d.enter(k)
+		err := d.collect(fmt.Errorf("plist: unknown key %q for Go value of type %v", k, typ))
+		d.leave()
 		if err != nil {
 			return err
 		}
-	} else {
-		var err error
-		parser := newXMLParser(d.reader)
-		pval, err = parser.parseDocument(nil)
+	}
+	return nil
+}
+
+// PeekType returns the kind of the root value of the next plist in the input,
+// without consuming any of it, so that the caller can choose what to decode
+// it into.
+// For XML plists the value element must start within the decoder's buffer
+// (4096 bytes unless the reader passed to NewXMLDecoder is a larger
+// *bufio.Reader). For binary plists the reader's offset is restored after
+// reading the trailer and the root object's marker.
+func (d *Decoder) PeekType() (Kind, error) {
+	if err := d.decompress(); err != nil {
+		return Invalid, err
+	}
+	if d.isBinary {
+		r, ok := d.reader.(io.ReadSeeker)
+		if !ok {
+			return Invalid, fmt.Errorf("binary plist decoder requires an io.ReadSeeker")
+		}
+		offset, err := r.Seek(0, io.SeekCurrent)
 		if err != nil {
-			return err
+			return Invalid, err
+		}
+		defer r.Seek(offset, io.SeekStart)
+		parser, err := newBinaryParser(r)
+		if err != nil {
+			return Invalid, err
+		}
+		marker, err := parser.seekObject(parser.RootObject)
+		if err != nil {
+			return Invalid, err
+		}
+		return markerKind(marker), nil
+	}
+	br, ok := d.reader.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(d.reader)
+		d.reader = br
+	}
+	// Peek at more of the input until the value element is found, so that
+	// this doesn't wait for a full buffer when the plist is short.
+	for n := 256; ; n *= 2 {
+		if n > br.Size() {
+			n = br.Size()
+		}
+		buf, err := br.Peek(n)
+		if err != nil && err != io.EOF {
+			return Invalid, err
+		}
+		kind, perr := peekKind(buf)
+		if perr == nil || err == io.EOF || n == br.Size() {
+			return kind, perr
 		}
 	}
-	return d.unmarshal(pval, val.Elem())
+}
+
+// Buffered returns a reader of the data remaining in the Decoder's buffer
+// after the last call to Decode. For XML plists this is whatever followed the
+// closing </plist> tag (or the root element, if the document had no <plist>
+// element) that the decoder had already read from the underlying reader, so
+// io.MultiReader(d.Buffered(), r) continues the stream where the plist ended.
+// Binary decoders read the plist by seeking in the underlying reader and
+// don't buffer, so Buffered returns an empty reader for them.
+// The reader is valid until the next call to Decode.
+func (d *Decoder) Buffered() io.Reader {
+	br, ok := d.reader.(*bufio.Reader)
+	if d.isBinary || !ok {
+		return bytes.NewReader(nil)
+	}
+	b, _ := br.Peek(br.Buffered())
+	return bytes.NewReader(b)
 }
 
 func (d *Decoder) unmarshal(pval *plistValue, v reflect.Value) error {
+	// dictionaries stored in interfaces can be decoded into the values
+	// chosen by the constructor
+	if v.Kind() == reflect.Interface && v.NumMethod() > 0 {
+		val, err := d.construct(pval, v.Type())
+		if err != nil {
+			return err
+		}
+		if val != nil {
+			v.Set(reflect.ValueOf(val))
+			return nil
+		}
+	}
+
+	// dictionaries with a registered PayloadType can be stored in any
+	// interface the registered type implements
+	if v.Kind() == reflect.Interface && v.NumMethod() > 0 {
+		if t := payloadType(pval); t != nil && t.Implements(v.Type()) {
+			val, err := d.payloadValue(t, pval)
+			if err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(val))
+			return nil
+		}
+		// otherwise they hold the default registered for the interface
+		if t := defaultType(v.Type()); t != nil {
+			val, err := d.payloadValue(t, pval)
+			if err != nil {
+				return err
+			}
+			v.Set(reflect.ValueOf(val))
+			return nil
+		}
+	}
+
 	// check for empty interface v type
 	if v.Kind() == reflect.Interface && v.NumMethod() == 0 {
-		val := reflect.ValueOf(d.valueInterface(pval))
+		iface, err := d.valueInterface(pval)
+		if err != nil {
+			return err
+		}
+		val := reflect.ValueOf(iface)
 		if !val.IsValid() {
 			return fmt.Errorf("plist: invalid reflect.Value %v", v)
 		}
@@ -95,6 +706,10 @@ func (d *Decoder) unmarshal(pval *plistValue, v reflect.Value) error {
 		return nil
 	}
 
+	if ok, err := d.unmarshalRegistered(pval, v); ok {
+		return err
+	}
+
 	if v.Kind() == reflect.Ptr {
 		if v.IsNil() {
 			v.Set(reflect.New(v.Type().Elem()))
@@ -102,18 +717,17 @@ func (d *Decoder) unmarshal(pval *plistValue, v reflect.Value) error {
 		v = v.Elem()
 	}
 
-	unmarshalerType := reflect.TypeOf((*Unmarshaler)(nil)).Elem()
-
-	if v.CanInterface() && v.Type().Implements(unmarshalerType) {
+	plan := cachedDecodePlan(v.Type())
+	if plan.unmarshaler && v.CanInterface() {
 		u := v.Interface().(Unmarshaler)
 		return u.UnmarshalPlist(func(i interface{}) error {
 			return d.unmarshal(pval, reflect.ValueOf(i))
 		})
 	}
 
-	if v.CanAddr() {
+	if plan.ptrUnmarshaler && v.CanAddr() {
 		pv := v.Addr()
-		if pv.CanInterface() && pv.Type().Implements(unmarshalerType) {
+		if pv.CanInterface() {
 			u := pv.Interface().(Unmarshaler)
 			return u.UnmarshalPlist(func(i interface{}) error {
 				return d.unmarshal(pval, reflect.ValueOf(i))
@@ -134,6 +748,44 @@ func (d *Decoder) unmarshal(pval *plistValue, v reflect.Value) error {
 
 	}
 
+	if ok, err := d.unmarshalText(pval, v); ok {
+		return err
+	}
+
+	if d.coerceToString && v.Kind() == reflect.String && pval.kind != String {
+		if s, ok := d.scalarText(pval); ok {
+			v.SetString(s)
+			return nil
+		}
+	}
+
+	if v.Type() == numberType {
+		return d.unmarshalNumber(pval, v)
+	}
+
+	if v.Type() == bigFloatType {
+		return d.unmarshalBigFloat(pval, v)
+	}
+
+	if v.Type() == dictType {
+		return d.unmarshalDict(pval, v)
+	}
+
+	if v.Type() == rawValueType {
+		raw, err := d.encodeRaw(pval)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(raw))
+		return nil
+	}
+
+	if v.Kind() == reflect.Struct {
+		if root := cachedDecodePlan(v.Type()).root; root != nil {
+			return d.unmarshalField(*root, pval, root.value(v))
+		}
+	}
+
 	switch pval.kind {
 	case String:
 		return d.unmarshalString(pval, v)
@@ -151,22 +803,47 @@ func (d *Decoder) unmarshal(pval *plistValue, v reflect.Value) error {
 		return d.unmarshalData(pval, v)
 	case Date:
 		return d.unmarshalDate(pval, v)
+	case UIDKind:
+		return d.unmarshalUID(pval, v)
 	default:
 		return fmt.Errorf("plist: %v is an unsuported plist element kind", pval.kind)
 	}
 }
 
+// unmarshalDate decodes a date into a time.Time, or into a string as the
+// date's text. Dates decoded with RawDates enabled hold the XML text as a
+// string, which is parsed when decoding into a time.Time.
 func (d *Decoder) unmarshalDate(pval *plistValue, v reflect.Value) error {
+	if v.Kind() == reflect.String {
+		s, ok := pval.value.(string)
+		if !ok {
+			s = formatDate(pval.value.(time.Time).In(time.UTC), d.subsecond)
+		}
+		v.SetString(s)
+		return nil
+	}
 	if v.Type() != reflect.TypeOf((*time.Time)(nil)).Elem() {
-		return UnmarshalTypeError{fmt.Sprintf("%v", pval.value), v.Type()}
+		return UnmarshalTypeError{describe(pval), v.Type()}
+	}
+	date, ok := pval.value.(time.Time)
+	if !ok {
+		var err error
+		if date, err = time.Parse(time.RFC3339, pval.value.(string)); err != nil {
+			return err
+		}
 	}
-	v.Set(reflect.ValueOf(pval.value.(time.Time)))
+	v.Set(reflect.ValueOf(d.inLocation(date)))
 	return nil
 }
 
 func (d *Decoder) unmarshalData(pval *plistValue, v reflect.Value) error {
 	if v.Kind() != reflect.Slice || v.Type().Elem().Kind() != reflect.Uint8 {
-		return UnmarshalTypeError{fmt.Sprintf("%s", pval.value.([]byte)), v.Type()}
+		if ok, err := d.decodeNested(pval, func(nested *plistValue) error {
+			return d.unmarshal(nested, v)
+		}); ok {
+			return err
+		}
+		return UnmarshalTypeError{describe(pval), v.Type()}
 	}
 	v.SetBytes(pval.value.([]byte))
 	return nil
@@ -174,57 +851,169 @@ func (d *Decoder) unmarshalData(pval *plistValue, v reflect.Value) error {
 
 func (d *Decoder) unmarshalReal(pval *plistValue, v reflect.Value) error {
 	if v.Kind() != reflect.Float32 && v.Kind() != reflect.Float64 {
-		return UnmarshalTypeError{fmt.Sprintf("%v", pval.value.(sizedFloat).value), v.Type()}
+		return UnmarshalTypeError{describe(pval), v.Type()}
 	}
 	v.SetFloat(pval.value.(sizedFloat).value)
 	return nil
 }
 
+func (d *Decoder) unmarshalNumber(pval *plistValue, v reflect.Value) error {
+	if pval.kind != Integer && pval.kind != Real {
+		return UnmarshalTypeError{describe(pval), v.Type()}
+	}
+	v.SetString(numberLiteral(pval))
+	return nil
+}
+
 func (d *Decoder) unmarshalBoolean(pval *plistValue, v reflect.Value) error {
 	if v.Kind() != reflect.Bool {
-		return UnmarshalTypeError{fmt.Sprintf("%v", pval.value), v.Type()}
+		return UnmarshalTypeError{describe(pval), v.Type()}
 	}
 	v.SetBool(pval.value.(bool))
 	return nil
 }
 
+// unmarshalField decodes the value of a struct field, handling the tag
+// options that change how the value is decoded.
+func (d *Decoder) unmarshalField(f field, pval *plistValue, v reflect.Value) error {
+	if f.unixDate != 0 && pval.kind == Integer {
+		if ok, err := d.unmarshalUnixDate(pval, v, f.unixDate); ok {
+			return err
+		}
+	}
+	if f.hex && pval.kind == String {
+		if ok, err := d.unmarshalHex(pval, v); ok {
+			return err
+		}
+	}
+	if f.keyedBy != "" && pval.kind == Array {
+		return d.unmarshalKeyed(f, pval, v)
+	}
+	return d.unmarshal(pval, v)
+}
+
 func (d *Decoder) unmarshalDictionary(pval *plistValue, v reflect.Value) error {
-	subvalues := pval.value.(*dictionary).m
+	dict := pval.value.(*dictionary)
+	subvalues := dict.m
+	d.checkKeys(dict)
+	if v.Type() == timeType {
+		// time.Time is a struct, but has no fields a dict could fill.
+		return UnmarshalTypeError{describe(pval), v.Type()}
+	}
 	switch v.Kind() {
 	case reflect.Struct:
-		fields := cachedTypeFields(v.Type())
-		for _, field := range fields {
-			if _, ok := subvalues[field.name]; !ok {
+		plan := cachedDecodePlan(v.Type())
+		var missing []string
+		for i := range plan.fields {
+			field := &plan.fields[i]
+			sval, ok := subvalues[field.name]
+			if field.path != nil {
+				sval, ok = lookupPath(pval, field.path)
+			} else if field.aliases != nil {
+				var err error
+				if sval, ok, err = d.alternateValue(field.field, subvalues, v.Type()); err != nil {
+					if err := d.collect(err); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			if !ok {
+				if field.required {
+					missing = append(missing, field.name)
+				}
 				continue
 			}
-			if err := d.unmarshal(subvalues[field.name], field.value(v)); err != nil {
+			d.enter(field.name)
+			err := d.collect(d.decodeField(field, sval, field.value(v)))
+			d.leave()
+			if err != nil {
 				return err
 			}
 		}
+		for _, field := range plan.formats {
+			field.value(v).SetUint(uint64(d.Format()))
+		}
+		if err := d.checkUnknownKeys(dict, plan, v.Type()); err != nil {
+			return err
+		}
+		if len(missing) > 0 {
+			err := &RequiredKeyError{Keys: missing, Type: v.Type()}
+			if !d.collectErrors {
+				err.Path = strings.Join(d.path, ".")
+			}
+			return err
+		}
 	case reflect.Map:
+		// Keys are converted to the map key type by their underlying kind,
+		// so named string types work, but nothing else can hold a key.
+		if v.Type().Key().Kind() != reflect.String {
+			return UnmarshalTypeError{describe(pval), v.Type()}
+		}
 		if v.IsNil() {
 			v.Set(reflect.MakeMap(v.Type()))
 		}
-		for k, sval := range subvalues {
+		// Keys are decoded in document order, so that collected errors
+		// are too.
+		for _, k := range dict.keyOrder() {
+			sval := subvalues[k]
 			keyv := reflect.ValueOf(k).Convert(v.Type().Key())
-			mapElem := v.MapIndex(keyv)
-			if !mapElem.IsValid() {
-				mapElem = reflect.New(v.Type().Elem()).Elem()
+			// Map elements aren't addressable, so the value is decoded
+			// into a copy that is stored afterwards.
+			mapElem := reflect.New(v.Type().Elem()).Elem()
+			if old := v.MapIndex(keyv); old.IsValid() && d.mergeIntoMap {
+				mapElem.Set(old)
 			}
-			if err := d.unmarshal(sval, mapElem); err != nil {
-				return err
+			d.enter(k)
+			err := d.unmarshal(sval, mapElem)
+			if err != nil {
+				// Values that failed to decode aren't stored.
+				err = d.collect(err)
+				d.leave()
+				if err != nil {
+					return err
+				}
+				continue
 			}
+			d.leave()
 			v.SetMapIndex(keyv, mapElem)
 		}
 	default:
-		return UnmarshalTypeError{"dict", v.Type()}
+		return UnmarshalTypeError{describe(pval), v.Type()}
 	}
 	return nil
 }
 
+// scalarText returns the text of a scalar value for CoerceToString, or false
+// if pval isn't a scalar.
+func (d *Decoder) scalarText(pval *plistValue) (string, bool) {
+	switch pval.kind {
+	case Integer:
+		i := pval.value.(signedInt)
+		if i.signed {
+			return strconv.FormatInt(int64(i.value), 10), true
+		}
+		return strconv.FormatUint(i.value, 10), true
+	case Real:
+		f := pval.value.(sizedFloat)
+		return strconv.FormatFloat(f.value, 'g', -1, f.bits), true
+	case Boolean:
+		return strconv.FormatBool(pval.value.(bool)), true
+	case Date:
+		if date, ok := pval.value.(time.Time); ok {
+			return formatDate(d.inLocation(date), d.subsecond), true
+		}
+		return pval.value.(string), true
+	case Data:
+		return base64.StdEncoding.EncodeToString(pval.value.([]byte)), true
+	default:
+		return "", false
+	}
+}
+
 func (d *Decoder) unmarshalString(pval *plistValue, v reflect.Value) error {
 	if v.Kind() != reflect.String {
-		return UnmarshalTypeError{fmt.Sprintf("%s", pval.value.(string)), v.Type()}
+		return UnmarshalTypeError{describe(pval), v.Type()}
 	}
 	v.SetString(pval.value.(string))
 	return nil
@@ -235,29 +1024,23 @@ func (d *Decoder) unmarshalArray(pval *plistValue, v reflect.Value) error {
 	switch v.Kind() {
 	case reflect.Slice:
 		// Slice of element values.
-		// Grow slice.
-		// Borrowed from https://golang.org/src/encoding/xml/read.go
+		// The backing array of the existing slice is reused if it is large
+		// enough, otherwise a new one is allocated.
 		cnt := len(subvalues)
-		if cnt >= v.Cap() {
-			ncap := 2 * cnt
-			if ncap < 4 {
-				ncap = 4
-			}
-			new := reflect.MakeSlice(v.Type(), v.Len(), ncap)
-			reflect.Copy(new, v)
-			v.Set(new)
+		if cnt > v.Cap() || v.IsNil() {
+			v.Set(reflect.MakeSlice(v.Type(), cnt, cnt))
 		}
-		n := v.Len()
 		v.SetLen(cnt)
-		for _, sval := range subvalues {
-			if err := d.unmarshal(sval, v.Index(n)); err != nil {
-				v.SetLen(cnt)
+		for n, sval := range subvalues {
+			d.enter(strconv.Itoa(n))
+			err := d.collect(d.unmarshal(sval, v.Index(n)))
+			d.leave()
+			if err != nil {
 				return err
 			}
-			n++
 		}
 	default:
-		return UnmarshalTypeError{"array", v.Type()}
+		return UnmarshalTypeError{describe(pval), v.Type()}
 	}
 	return nil
 }
@@ -265,81 +1048,252 @@ func (d *Decoder) unmarshalArray(pval *plistValue, v reflect.Value) error {
 func (d *Decoder) unmarshalInteger(pval *plistValue, v reflect.Value) error {
 	switch v.Kind() {
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		v.SetInt(int64(pval.value.(signedInt).value))
+		// Unsigned values above math.MaxInt64 don't fit any signed type.
+		i := pval.value.(signedInt)
+		if !i.signed && i.value > math.MaxInt64 || v.OverflowInt(int64(i.value)) {
+			return UnmarshalTypeError{describe(pval), v.Type()}
+		}
+		v.SetInt(int64(i.value))
 	case reflect.Uint, reflect.Uint8, reflect.Uint16,
 		reflect.Uint32, reflect.Uint64, reflect.Uintptr:
 		// Make sure plistValue isn't negative when decoding into uint.
-		if pval.value.(signedInt).signed {
-			return UnmarshalTypeError{
-				fmt.Sprintf("%v", int64(pval.value.(signedInt).value)), v.Type()}
+		// 8-byte binary integers keep their full 64 bits, see
+		// binaryParser.parseInteger.
+		i := pval.value.(signedInt)
+		if i.signed && !i.anySign || v.OverflowUint(i.value) {
+			return UnmarshalTypeError{describe(pval), v.Type()}
+		}
+		v.SetUint(i.value)
+	case reflect.Bool:
+		// 0 and 1 are accepted as booleans, as written with BoolAsInteger.
+		switch pval.value.(signedInt).value {
+		case 0:
+			v.SetBool(false)
+		case 1:
+			v.SetBool(true)
+		default:
+			return UnmarshalTypeError{describe(pval), v.Type()}
 		}
-		v.SetUint(pval.value.(signedInt).value)
 	default:
-		return UnmarshalTypeError{
-			fmt.Sprintf("%v", pval.value.(signedInt).value), v.Type()}
+		return UnmarshalTypeError{describe(pval), v.Type()}
 	}
 	return nil
 }
 
 // empty interface values
 // borrowed from go-plist
-func (d *Decoder) valueInterface(pval *plistValue) interface{} {
+func (d *Decoder) valueInterface(pval *plistValue) (interface{}, error) {
 	switch pval.kind {
 	case String:
-		return pval.value.(string)
+		return pval.value.(string), nil
 	case Integer:
 		if pval.value.(signedInt).signed {
-			return int64(pval.value.(signedInt).value)
+			return int64(pval.value.(signedInt).value), nil
 		}
-		return pval.value.(signedInt).value
+		return pval.value.(signedInt).value, nil
 	case Real:
 		bits := pval.value.(sizedFloat).bits
 		switch bits {
 		case 32:
-			return float32(pval.value.(sizedFloat).value)
+			return float32(pval.value.(sizedFloat).value), nil
 		case 64:
-			return pval.value.(sizedFloat).value
+			return pval.value.(sizedFloat).value, nil
 		default:
-			return nil
+			return nil, nil
 		}
 	case Boolean:
-		return pval.value.(bool)
+		return pval.value.(bool), nil
 	case Array:
 		return d.arrayInterface(pval.value.([]*plistValue))
 	case Dictionary:
+		if val, err := d.construct(pval, emptyInterfaceType); val != nil || err != nil {
+			return val, err
+		}
+		if t := payloadType(pval); t != nil {
+			return d.payloadValue(t, pval)
+		}
+		if d.orderedDicts {
+			return d.orderedDict(pval.value.(*dictionary))
+		}
 		return d.dictionaryInterface(pval.value.(*dictionary))
 	case Data:
-		return pval.value.([]byte)
+		var val interface{}
+		if ok, err := d.decodeNested(pval, func(nested *plistValue) error {
+			var err error
+			val, err = d.valueInterface(nested)
+			return err
+		}); ok {
+			return val, err
+		}
+		return pval.value.([]byte), nil
 	case Date:
-		return pval.value.(time.Time)
+		// A time.Time, or the date's text if decoded with RawDates.
+		if date, ok := pval.value.(time.Time); ok {
+			return d.inLocation(date), nil
+		}
+		return pval.value, nil
+	case UIDKind:
+		return pval.value.(UID), nil
 	default:
-		return nil
+		return nil, nil
 	}
 }
 
-func (d *Decoder) arrayInterface(subvalues []*plistValue) []interface{} {
-	out := make([]interface{}, len(subvalues))
+func (d *Decoder) arrayInterface(subvalues []*plistValue) ([]interface{}, error) {
+	var out []interface{}
+	if d.alloc != nil {
+		out = d.alloc.NewSlice(len(subvalues))
+	} else {
+		out = make([]interface{}, len(subvalues))
+	}
 	for i, subv := range subvalues {
-		out[i] = d.valueInterface(subv)
+		d.enter(strconv.Itoa(i))
+		val, err := d.valueInterface(subv)
+		err = d.collect(err)
+		d.leave()
+		if err != nil {
+			return nil, err
+		}
+		out[i] = val
 	}
-	return out
+	return out, nil
 }
 
-func (d *Decoder) dictionaryInterface(dict *dictionary) map[string]interface{} {
-	out := make(map[string]interface{})
-	for k, subv := range dict.m {
-		out[k] = d.valueInterface(subv)
+func (d *Decoder) dictionaryInterface(dict *dictionary) (map[string]interface{}, error) {
+	var out map[string]interface{}
+	if d.alloc != nil {
+		out = d.alloc.NewMap(len(dict.m))
+	} else {
+		out = make(map[string]interface{})
 	}
-	return out
+	d.checkKeys(dict)
+	for _, k := range dict.keyOrder() {
+		d.enter(k)
+		val, err := d.valueInterface(dict.m[k])
+		err = d.collect(err)
+		d.leave()
+		if err != nil {
+			return nil, err
+		}
+		out[k] = val
+	}
+	return out, nil
 }
 
 // An UnmarshalTypeError describes a plist value that was
 // not appropriate for a value of a specific Go type.
+//
+// An UnmarshalTypeError is returned whenever the kind of a plist value
+// doesn't fit the Go value it is decoded into, including at the root of the
+// document, such as a <string> decoded into a struct, so that callers can
+// detect the mismatch and try another type. Decode stores:
+//
+//   - a <dict> in a struct, a map with string keys or an empty interface
+//   - an <array> in a slice or an empty interface
+//   - a <string> in a string or an empty interface
+//   - an <integer> in an integer type, within its range, a bool if 0 or 1,
+//     a Number or an empty interface
+//   - a <real> in a float type, a Number or an empty interface
+//   - a <true/> or <false/> in a bool or an empty interface
+//   - <data> in a []byte or an empty interface
+//   - a <date> in a time.Time, a string or an empty interface
+//   - a binary UID in an unsigned integer type or an empty interface
+//
+// before any registered decoders and Unmarshaler methods, which decide for
+// themselves. Pointers are allocated as needed and follow the rules for the
+// type they point to. Any other combination is an UnmarshalTypeError.
 type UnmarshalTypeError struct {
-	Value string // description of plist value - "true", "string", "date"
+	Value string // description of plist value - "true", "string \"foo\"", "date"
 	Type  reflect.Type
 }
 
 func (e UnmarshalTypeError) Error() string {
 	return "plist: cannot unmarshal " + e.Value + " into Go value of type " + e.Type.String()
 }
+
+// describe returns the description of pval used by UnmarshalTypeError: its
+// kind, followed by the value itself for short scalars.
+func describe(pval *plistValue) string {
+	switch pval.kind {
+	case String:
+		if s := pval.value.(string); len(s) <= 64 {
+			return "string " + strconv.Quote(s)
+		}
+	case Integer, Real:
+		return pval.kind.String() + " " + numberLiteral(pval)
+	case Boolean:
+		return strconv.FormatBool(pval.value.(bool))
+	case Date:
+		if date, ok := pval.value.(time.Time); ok {
+			return "date " + date.Format(time.RFC3339)
+		}
+		return "date " + pval.value.(string)
+	case Dictionary:
+		return "dict"
+	}
+	return pval.kind.String()
+}
+
+// A RequiredKeyError is returned when a dict decoded into a struct lacks the
+// keys of one or more fields tagged as required, like `plist:"uuid,required"`.
+//
+// Path is the key path of the dict, with array elements given by their index,
+// like PayloadContent.1 for the second payload of a profile. It is empty for
+// the root value, and for errors collected by a Decoder, whose PathError
+// holds the path instead.
+type RequiredKeyError struct {
+	Keys []string     // all of the missing keys, in field order
+	Type reflect.Type // the struct type
+	Path string       // the dict keys and array indexes leading to the dict, joined by dots
+}
+
+func (e *RequiredKeyError) Error() string {
+	msg := "plist: missing required keys " + strings.Join(e.Keys, ", ") + " for Go value of type " + e.Type.String()
+	if e.Path != "" {
+		msg += " at " + e.Path
+	}
+	return msg
+}
+
+// A PathError is a problem with the value at a key path, found by a Decoder
+// collecting errors.
+type PathError struct {
+	Path string // the dict keys and array indexes leading to the value, joined by dots
+	Err  error
+}
+
+func (e *PathError) Error() string {
+	msg := strings.TrimPrefix(e.Err.Error(), "plist: ")
+	if e.Path == "" {
+		return "plist: " + msg
+	}
+	return "plist: " + e.Path + ": " + msg
+}
+
+// Unwrap returns the underlying error.
+func (e *PathError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeErrors is returned by a Decoder collecting errors, with every problem
+// found in the order they were found.
+type DecodeErrors []*PathError
+
+// Error returns the messages of the errors, one per line.
+func (e DecodeErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Unwrap returns the errors, so that errors.Is and errors.As of Go 1.20 and
+// later check each of them.
+func (e DecodeErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
@@ -2,14 +2,25 @@ package plist
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"math"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -494,3 +505,3412 @@ func TestSmallInput(t *testing.T) {
 	}
 
 }
+
+func TestUnmarshalFile(t *testing.T) {
+	var sample struct {
+		Strings []string `plist:"strings"`
+	}
+	if err := UnmarshalFile(filepath.Join("testdata", "sample2.binary.plist"), &sample); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(sample.Strings), 4; have != want {
+		t.Errorf("binary: have %d strings, want %d", have, want)
+	}
+
+	f, err := ioutil.TempFile("", "plist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(indentRef); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	var header struct {
+		DiskImageBundleType string `plist:"diskimage-bundle-type"`
+	}
+	if err := UnmarshalFile(f.Name(), &header); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := header.DiskImageBundleType, "com.apple.diskimage.sparsebundle"; have != want {
+		t.Errorf("xml: have %q, want %q", have, want)
+	}
+}
+
+func TestBinaryReader(t *testing.T) {
+	content, err := ioutil.ReadFile(filepath.Join("testdata", "sample2.binary.plist"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewBinaryReader(bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var s string
+	if err := r.DecodePath(&s, "strings", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := s, "こんにちは世界"; have != want {
+		t.Errorf("have %q, want %q", have, want)
+	}
+
+	index, err := r.Resolve("ints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ints []int64
+	if err := r.DecodeObject(index, &ints); err != nil {
+		t.Fatal(err)
+	}
+	if have, want := len(ints), 8; have != want {
+		t.Errorf("have %d ints, want %d", have, want)
+	}
+
+	for _, path := range [][]string{{"missing"}, {"strings", "4"}, {"strings", "0", "x"}} {
+		if _, err := r.Resolve(path...); err == nil {
+			t.Errorf("expected error resolving %q", path)
+		}
+	}
+	if _, err := r.Offset(uint64(r.NumObjects())); err == nil {
+		t.Error("expected error for out of range offset")
+	}
+}
+
+func TestDecoderBuffered(t *testing.T) {
+	const trailer = "appended signature"
+	d := NewXMLDecoder(bytes.NewReader([]byte(fooRef + trailer)))
+	var s string
+	if err := d.Decode(&s); err != nil {
+		t.Fatal(err)
+	}
+	if s != "foo" {
+		t.Errorf("decoded %q; want \"foo\"", s)
+	}
+	rest, err := ioutil.ReadAll(d.Buffered())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, want := string(rest), "\n"+trailer; have != want {
+		t.Errorf("have %q, want %q", have, want)
+	}
+}
+
+func TestDecodeNumber(t *testing.T) {
+	const input = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0"><dict><key>big</key><integer>18446744073709551615</integer><key>neg</key><integer>-42</integer><key>real</key><real>1.50</real></dict></plist>`
+	var data struct {
+		Big  Number `plist:"big"`
+		Neg  Number `plist:"neg"`
+		Real Number `plist:"real"`
+	}
+	if err := Unmarshal([]byte(input), &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.Big != "18446744073709551615" || data.Neg != "-42" || data.Real != "1.50" {
+		t.Errorf("unexpected numbers %#v", data)
+	}
+	if u, err := data.Big.Uint64(); err != nil || u != 18446744073709551615 {
+		t.Errorf("Uint64() = %d, %v", u, err)
+	}
+	if i, err := data.Neg.Int64(); err != nil || i != -42 {
+		t.Errorf("Int64() = %d, %v", i, err)
+	}
+	if f, err := data.Real.Float64(); err != nil || f != 1.5 {
+		t.Errorf("Float64() = %v, %v", f, err)
+	}
+
+	var n Number
+	if err := Unmarshal([]byte(fooRef), &n); err == nil {
+		t.Error("expected error decoding a string into a Number")
+	}
+}
+
+type (
+	namedString string
+	namedInt    int
+	namedUint   uint16
+	namedBool   bool
+	namedFloat  float32
+	namedData   []byte
+)
+
+func TestNamedScalarTypes(t *testing.T) {
+	type named struct {
+		String namedString
+		Int    namedInt
+		Uint   namedUint
+		Bool   namedBool
+		Float  namedFloat
+		Data   namedData
+		Map    map[namedString]namedInt
+		Slice  []namedString
+		Ptr    *namedInt
+	}
+	i := namedInt(-3)
+	in := named{"com.example", -2, 7, true, 0.5, namedData("hi"), map[namedString]namedInt{"a": 1}, []namedString{"b"}, &i}
+	b, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out named
+	if err := Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("have %#v, want %#v", out, in)
+	}
+
+	var badKey map[int]string
+	if err := Unmarshal([]byte(dictRef), &badKey); err == nil {
+		t.Error("expected error decoding into a map with non-string keys")
+	}
+}
+
+func TestDecodeIntoExistingSlice(t *testing.T) {
+	const input = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0"><array><string>a</string><string>b</string><string>c</string></array></plist>`
+	for _, data := range [][]string{nil, {"x"}, {"x", "y", "z", "w"}, make([]string, 0, 8)} {
+		if err := Unmarshal([]byte(input), &data); err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"a", "b", "c"}; !reflect.DeepEqual(data, want) {
+			t.Error("Expected", want, "got", data)
+		}
+	}
+}
+
+func TestPoolAllocator(t *testing.T) {
+	var pool PoolAllocator
+	for i := 0; i < 3; i++ {
+		d := NewXMLDecoder(bytes.NewReader([]byte(indentRef)))
+		d.SetAllocator(&pool)
+		var tree interface{}
+		if err := d.Decode(&tree); err != nil {
+			t.Fatal(err)
+		}
+		m := tree.(map[string]interface{})
+		if have, want := len(m), 6; have != want {
+			t.Fatalf("have %d keys, want %d", have, want)
+		}
+		if have, want := m["useless"], map[string]interface{}{"unused-string": "unused"}; !reflect.DeepEqual(have, want) {
+			t.Errorf("have %v, want %v", have, want)
+		}
+		pool.Release(tree)
+		if len(m) != 0 {
+			t.Error("released map wasn't cleared")
+		}
+	}
+}
+
+func BenchmarkDecodeInterface(b *testing.B) {
+	var pool PoolAllocator
+	for _, tt := range []struct {
+		name  string
+		alloc *PoolAllocator
+	}{{"make", nil}, {"pool", &pool}} {
+		b.Run(tt.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				d := NewXMLDecoder(bytes.NewReader([]byte(indentRef)))
+				if tt.alloc != nil {
+					d.SetAllocator(tt.alloc)
+				}
+				var tree interface{}
+				if err := d.Decode(&tree); err != nil {
+					b.Fatal(err)
+				}
+				if tt.alloc != nil {
+					tt.alloc.Release(tree)
+				}
+			}
+		})
+	}
+}
+
+func TestPeekType(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Kind
+	}{
+		{fooRef, String},
+		{oneRef, Integer},
+		{realRef, Real},
+		{trueRef, Boolean},
+		{arrRef, Array},
+		{dictRef, Dictionary},
+		{dataRef, Data},
+		{time1900Ref, Date},
+		{"<dict></dict>", Dictionary},
+	}
+	for _, tt := range tests {
+		d := NewXMLDecoder(bytes.NewReader([]byte(tt.in)))
+		kind, err := d.PeekType()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if kind != tt.want {
+			t.Errorf("PeekType() = %v, want %v", kind, tt.want)
+		}
+		// Peeking doesn't consume the plist.
+		var v interface{}
+		if err := d.Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join("testdata", "sample2.binary.plist"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewBinaryDecoder(bytes.NewReader(content))
+	kind, err := d.PeekType()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kind != Dictionary {
+		t.Errorf("PeekType() = %v, want %v", kind, Dictionary)
+	}
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewXMLDecoder(bytes.NewReader([]byte("<plist><foo/></plist>"))).PeekType(); err == nil {
+		t.Error("expected error for unknown element")
+	}
+	if _, err := NewXMLDecoder(bytes.NewReader(nil)).PeekType(); err != io.EOF {
+		t.Errorf("have error %v, want io.EOF", err)
+	}
+}
+
+func TestDecodeComments(t *testing.T) {
+	const input = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<!-- generated -->
+<plist version="1.0">
+<dict>
+	<!-- the name -->
+	<key>name</key>
+	<!-- between key and value -->
+	<string>Wi-Fi</string>
+	<key>servers</key>
+	<array>
+		<!-- primary --><string>a.example.com</string>
+		<string>b.example.com</string><!-- secondary -->
+	</array>
+	<key>enabled</key>
+	<true/>
+	<!-- trailing -->
+</dict>
+<!-- after root -->
+</plist>
+`
+	var v struct {
+		Name    string   `plist:"name"`
+		Servers []string `plist:"servers"`
+		Enabled bool     `plist:"enabled"`
+	}
+	d := NewXMLDecoder(bytes.NewReader([]byte(input)))
+	d.CaptureComments(true)
+	if err := d.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Name != "Wi-Fi" || !reflect.DeepEqual(v.Servers, []string{"a.example.com", "b.example.com"}) || !v.Enabled {
+		t.Errorf("unexpected value %+v", v)
+	}
+
+	want := []string{" generated ", " the name ", " between key and value ", " primary ", " secondary ", " trailing ", " after root "}
+	comments := d.Comments()
+	if len(comments) != len(want) {
+		t.Fatalf("have %d comments, want %d", len(comments), len(want))
+	}
+	for i, c := range comments {
+		if c.Text != want[i] {
+			t.Errorf("comment %d: have %q, want %q", i, c.Text, want[i])
+		}
+		if have := input[c.Offset : c.Offset+int64(len(c.Text))+4]; have != "<!--"+c.Text {
+			t.Errorf("comment %d: offset %d points at %q", i, c.Offset, have)
+		}
+	}
+}
+
+func TestDecodeRequired(t *testing.T) {
+	type header struct {
+		InfoDictionaryVersion string `plist:"CFBundleInfoDictionaryVersion,required"`
+		UUID                  string `plist:"uuid,required"`
+		Name                  string `plist:"name,required"`
+		Size                  uint64 `plist:"size"`
+	}
+	var h header
+	err := Unmarshal([]byte(indentRef), &h)
+	reqErr, ok := err.(*RequiredKeyError)
+	if !ok {
+		t.Fatalf("have error %v, want *RequiredKeyError", err)
+	}
+	if want := []string{"uuid", "name"}; !reflect.DeepEqual(reqErr.Keys, want) {
+		t.Errorf("have missing keys %q, want %q", reqErr.Keys, want)
+	}
+	if reqErr.Type != reflect.TypeOf(h) {
+		t.Errorf("have type %v, want %v", reqErr.Type, reflect.TypeOf(h))
+	}
+
+	var ok2 struct {
+		Size uint64 `plist:"size,required"`
+	}
+	if err := Unmarshal([]byte(indentRef), &ok2); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecodeRawDates(t *testing.T) {
+	const input = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0"><dict><key>created</key><date>2011-05-12T01:00:00Z</date><key>vendor</key><date>2011-05-12 01:00:00 +0000</date></dict></plist>
+`
+	type dates struct {
+		Created time.Time `plist:"created"`
+		Vendor  string    `plist:"vendor,date"`
+	}
+	var v dates
+	d := NewXMLDecoder(bytes.NewReader([]byte(input)))
+	d.RawDates(true)
+	if err := d.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2011, 5, 12, 1, 0, 0, 0, time.UTC); !v.Created.Equal(want) {
+		t.Errorf("have %v, want %v", v.Created, want)
+	}
+	if want := "2011-05-12 01:00:00 +0000"; v.Vendor != want {
+		t.Errorf("have %q, want %q", v.Vendor, want)
+	}
+
+	// The date text is encoded back exactly.
+	out, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != input {
+		t.Errorf("expected \n%s got \n%s\n", input, out)
+	}
+
+	// Without RawDates the vendor date can't be parsed, and dates decode
+	// into strings in RFC 3339 format.
+	if err := Unmarshal([]byte(input), &v); err == nil {
+		t.Error("expected error parsing the vendor date")
+	}
+	var s string
+	if err := Unmarshal([]byte(time1900Ref), &s); err != nil {
+		t.Fatal(err)
+	}
+	if want := "1900-01-01T12:00:00Z"; s != want {
+		t.Errorf("have %q, want %q", s, want)
+	}
+}
+
+func TestDecodeSubsecondDateStrings(t *testing.T) {
+	const input = `<plist version="1.0"><array><date>2011-05-12T01:00:00.25Z</date><date>2011-05-12T01:00:00Z</date></array></plist>`
+	var dates []time.Time
+	if err := Unmarshal([]byte(input), &dates); err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2011, 5, 12, 1, 0, 0, 250e6, time.UTC); !dates[0].Equal(want) {
+		t.Errorf("have %v, want %v", dates[0], want)
+	}
+
+	var s []string
+	if err := Unmarshal([]byte(input), &s); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"2011-05-12T01:00:00Z", "2011-05-12T01:00:00Z"}; !reflect.DeepEqual(s, want) {
+		t.Errorf("have %q, want %q", s, want)
+	}
+	d := NewXMLDecoder(strings.NewReader(input), WithSubsecondDateStrings())
+	if err := d.Decode(&s); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"2011-05-12T01:00:00.25Z", "2011-05-12T01:00:00Z"}; !reflect.DeepEqual(s, want) {
+		t.Errorf("have %q, want %q", s, want)
+	}
+}
+
+type payload interface {
+	Identifier() string
+}
+
+type wifiPayload struct {
+	PayloadIdentifier string
+	SSID              string `plist:"SSID_STR"`
+}
+
+func (p *wifiPayload) Identifier() string { return p.PayloadIdentifier }
+
+func init() {
+	RegisterPayloadType("com.example.wifi", &wifiPayload{})
+}
+
+func TestDecodePayloadType(t *testing.T) {
+	const profile = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>PayloadContent</key>
+	<array>
+		<dict>
+			<key>PayloadType</key>
+			<string>com.example.wifi</string>
+			<key>PayloadIdentifier</key>
+			<string>wifi1</string>
+			<key>SSID_STR</key>
+			<string>office</string>
+		</dict>
+		<dict>
+			<key>PayloadType</key>
+			<string>com.example.unknown</string>
+		</dict>
+	</array>
+</dict>
+</plist>`
+
+	var generic map[string]interface{}
+	if err := Unmarshal([]byte(profile), &generic); err != nil {
+		t.Fatal(err)
+	}
+	content := generic["PayloadContent"].([]interface{})
+	wifi, ok := content[0].(*wifiPayload)
+	if !ok {
+		t.Fatalf("expected *wifiPayload, got %T", content[0])
+	}
+	if wifi.PayloadIdentifier != "wifi1" || wifi.SSID != "office" {
+		t.Errorf("unexpected payload %+v", wifi)
+	}
+	if _, ok := content[1].(map[string]interface{}); !ok {
+		t.Errorf("expected map for unknown payload type, got %T", content[1])
+	}
+
+	// Registered types can be decoded into an interface they implement.
+	var one struct {
+		PayloadContent []payload
+	}
+	if err := Unmarshal([]byte(profile), &one); err == nil {
+		t.Fatal("expected error decoding unknown payload type into interface")
+	}
+	one.PayloadContent = nil
+	single := bytes.Replace([]byte(profile), []byte("com.example.unknown"), []byte("com.example.wifi"), 1)
+	if err := Unmarshal(single, &one); err != nil {
+		t.Fatal(err)
+	}
+	if len(one.PayloadContent) != 2 || one.PayloadContent[0].Identifier() != "wifi1" {
+		t.Errorf("unexpected payloads %+v", one.PayloadContent)
+	}
+}
+
+// identified is implemented by wifiPayload, which is registered for its
+// PayloadType, and by genericPayload, which is its default.
+type identified interface {
+	Identifier() string
+}
+
+type genericPayload struct {
+	PayloadType       string
+	PayloadIdentifier string
+}
+
+func (p *genericPayload) Identifier() string { return p.PayloadIdentifier }
+
+func init() {
+	RegisterDefault(reflect.TypeOf((*identified)(nil)).Elem(), &genericPayload{})
+}
+
+func TestRegisterDefault(t *testing.T) {
+	const doc = `<plist><array>
+		<dict>
+			<key>PayloadType</key><string>com.example.wifi</string>
+			<key>PayloadIdentifier</key><string>wifi1</string>
+		</dict>
+		<dict>
+			<key>PayloadType</key><string>com.example.vpn</string>
+			<key>PayloadIdentifier</key><string>vpn1</string>
+		</dict>
+	</array></plist>`
+
+	// The registered PayloadType takes precedence over the default.
+	var got []identified
+	if err := Unmarshal([]byte(doc), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d values, want 2", len(got))
+	}
+	if p, ok := got[0].(*wifiPayload); !ok || p.PayloadIdentifier != "wifi1" {
+		t.Errorf("got %#v, want a *wifiPayload", got[0])
+	}
+	want := &genericPayload{PayloadType: "com.example.vpn", PayloadIdentifier: "vpn1"}
+	if !reflect.DeepEqual(got[1], want) {
+		t.Errorf("got %#v, want %#v", got[1], want)
+	}
+
+	// A constructor takes precedence over both.
+	dec := NewXMLDecoder(strings.NewReader(doc))
+	dec.SetConstructor(func(map[string]interface{}) interface{} { return &genericPayload{} })
+	got = nil
+	if err := dec.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got[0].(*genericPayload); !ok {
+		t.Errorf("got %T, want the constructed *genericPayload", got[0])
+	}
+
+	for _, fn := range []func(){
+		func() { RegisterDefault(reflect.TypeOf(""), "") },
+		func() { RegisterDefault(reflect.TypeOf((*identified)(nil)).Elem(), genericPayload{}) },
+		func() { RegisterDefault(reflect.TypeOf((*identified)(nil)).Elem(), &genericPayload{}) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected RegisterDefault to panic")
+				}
+			}()
+			fn()
+		}()
+	}
+}
+
+// testMessage is shaped like a message generated by protoc-gen-go.
+type testMessage struct {
+	state         int
+	PayloadType   string   `protobuf:"bytes,1,opt,name=payload_type,json=payloadType,proto3"`
+	DisplayName   string   `protobuf:"bytes,2,opt,name=display_name,json=displayName,proto3" plist:",omitempty"`
+	Tags          []string `protobuf:"bytes,3,rep,name=tags,proto3"`
+	XXX_sizecache int32
+}
+
+func (*testMessage) ProtoMessage() {}
+
+type protoMessage interface {
+	ProtoMessage()
+}
+
+type upperNamed struct {
+	Name  string
+	Count int `plist:"count"`
+}
+
+func init() {
+	RegisterFieldNames(reflect.TypeOf((*protoMessage)(nil)).Elem(), func(f reflect.StructField) string {
+		for _, opt := range strings.Split(f.Tag.Get("protobuf"), ",") {
+			if strings.HasPrefix(opt, "name=") {
+				return strings.TrimPrefix(opt, "name=")
+			}
+		}
+		return "-"
+	})
+	RegisterFieldNames(reflect.TypeOf(upperNamed{}), func(f reflect.StructField) string {
+		return strings.ToUpper(f.Name)
+	})
+}
+
+func TestRegisterFieldNames(t *testing.T) {
+	const doc = `<plist><dict>
+		<key>payload_type</key><string>com.example.wifi</string>
+		<key>tags</key><array><string>a</string></array>
+	</dict></plist>`
+	var msg testMessage
+	dec := NewXMLDecoder(strings.NewReader(doc))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.PayloadType != "com.example.wifi" || !reflect.DeepEqual(msg.Tags, []string{"a"}) {
+		t.Errorf("got %+v", msg)
+	}
+
+	// Other tag options still apply, and fields named "-" are left out.
+	data, err := Marshal(&msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back map[string]interface{}
+	if err := Unmarshal(data, &back); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"payload_type": "com.example.wifi", "tags": []interface{}{"a"}}
+	if !reflect.DeepEqual(back, want) {
+		t.Errorf("encoded %v, want %v", back, want)
+	}
+
+	// Plist tags take precedence.
+	data, err = Marshal(upperNamed{"x", 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	back = nil
+	if err := Unmarshal(data, &back); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]interface{}{"NAME": "x", "count": uint64(2)}; !reflect.DeepEqual(back, want) {
+		t.Errorf("encoded %v, want %v", back, want)
+	}
+}
+
+func TestDecodeSelfClosingEmpty(t *testing.T) {
+	doc := func(el string) []byte {
+		return []byte(`<?xml version="1.0" encoding="UTF-8"?><plist version="1.0">` + el + `</plist>`)
+	}
+
+	var s string
+	if err := Unmarshal(doc("<string/>"), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s != "" {
+		t.Errorf("<string/>: got %q", s)
+	}
+
+	data := []byte("not empty")
+	if err := Unmarshal(doc("<data/>"), &data); err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 0 {
+		t.Errorf("<data/>: got %#v", data)
+	}
+
+	var dict map[string]interface{}
+	if err := Unmarshal(doc("<dict><key>s</key><string/><key>d</key><data/></dict>"), &dict); err != nil {
+		t.Fatal(err)
+	}
+	if dict["s"] != "" || len(dict["d"].([]byte)) != 0 {
+		t.Errorf("unexpected dict %#v", dict)
+	}
+
+	// Integers, reals and dates need a value.
+	for _, el := range []string{"<integer/>", "<real/>", "<date/>", "<integer> </integer>"} {
+		var v interface{}
+		if err := Unmarshal(doc(el), &v); err == nil {
+			t.Errorf("%s: expected error", el)
+		}
+	}
+}
+
+func TestTypeOf(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		in   interface{}
+		want Kind
+	}{
+		{"foo", String},
+		{uint64(1), Integer},
+		{int64(-1), Integer},
+		{3.5, Real},
+		{float32(3.5), Real},
+		{true, Boolean},
+		{now, Date},
+		{&now, Date},
+		{[]byte("foo"), Data},
+		{[]interface{}{"foo"}, Array},
+		{map[string]interface{}{}, Dictionary},
+		{struct{ A string }{}, Dictionary},
+		{UID(7), UIDKind},
+		{Number("42"), Integer},
+		{Number("4.2"), Real},
+		{Number("foo"), Invalid},
+		{Commented{"comment", "foo"}, String},
+		{nil, Invalid},
+		{(*string)(nil), Invalid},
+		{map[int]string{}, Invalid},
+		{make(chan int), Invalid},
+	}
+	for _, tt := range tests {
+		if got := TypeOf(tt.in); got != tt.want {
+			t.Errorf("TypeOf(%#v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestBinaryUID(t *testing.T) {
+	in := map[string]interface{}{
+		"small": UID(1),
+		"large": UID(1 << 40),
+	}
+	out, err := MarshalBinary(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, in) {
+		t.Errorf("got %#v, want %#v", got, in)
+	}
+
+	var typed struct {
+		Small UID    `plist:"small"`
+		Large uint64 `plist:"large"`
+	}
+	if err := Unmarshal(out, &typed); err != nil {
+		t.Fatal(err)
+	}
+	if typed.Small != 1 || typed.Large != 1<<40 {
+		t.Errorf("unexpected %+v", typed)
+	}
+
+	// XML plists hold UIDs as CF$UID dicts.
+	xml, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	if err := Unmarshal(xml, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, in) {
+		t.Errorf("XML: got %#v, want %#v", got, in)
+	}
+}
+
+func TestDecodeXMLUID(t *testing.T) {
+	const doc = `<plist version="1.0"><array>
+	<dict><key>CF$UID</key><integer>7</integer></dict>
+	<dict><key>CF$UID</key><integer>-1</integer></dict>
+	<dict><key>CF$UID</key><integer>1</integer><key>other</key><true/></dict>
+</array></plist>`
+	var got []interface{}
+	if err := Unmarshal([]byte(doc), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []interface{}{
+		UID(7),
+		map[string]interface{}{"CF$UID": int64(-1)},
+		map[string]interface{}{"CF$UID": uint64(1), "other": true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+
+	// UIDs still decode into maps and structs as dicts.
+	var typed struct {
+		UID uint64 `plist:"CF$UID"`
+	}
+	const uid = `<plist version="1.0"><dict><key>CF$UID</key><integer>7</integer></dict></plist>`
+	if err := Unmarshal([]byte(uid), &typed); err != nil {
+		t.Fatal(err)
+	}
+	if typed.UID != 7 {
+		t.Errorf("unexpected %+v", typed)
+	}
+
+	out, err := Marshal(UID(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "<dict><key>CF$UID</key><integer>7</integer></dict>") {
+		t.Errorf("unexpected XML UID\n%s", out)
+	}
+}
+
+func TestDecodeKeyPath(t *testing.T) {
+	const doc = `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>PayloadContent</key>
+	<array>
+		<dict>
+			<key>PayloadUUID</key>
+			<string>uuid-1</string>
+		</dict>
+	</array>
+	<key>com.example.key</key>
+	<string>dotted</string>
+</dict>
+</plist>`
+
+	var v struct {
+		UUID    string `plist:"PayloadContent.0.PayloadUUID,path"`
+		Missing string `plist:"PayloadContent.1.PayloadUUID,path"`
+		Through string `plist:"com.example.key.foo,path"`
+		Dotted  string `plist:"com.example.key"`
+	}
+	v.Missing = "unchanged"
+	if err := Unmarshal([]byte(doc), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.UUID != "uuid-1" || v.Missing != "unchanged" || v.Through != "" || v.Dotted != "dotted" {
+		t.Errorf("unexpected %+v", v)
+	}
+
+	var req struct {
+		Missing string `plist:"PayloadContent.0.PayloadType,path,required"`
+	}
+	err := Unmarshal([]byte(doc), &req)
+	if rerr, ok := err.(*RequiredKeyError); !ok || rerr.Keys[0] != "PayloadContent.0.PayloadType" {
+		t.Errorf("expected RequiredKeyError, got %v", err)
+	}
+}
+
+func TestDecodeCollectErrors(t *testing.T) {
+	const doc = `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>Name</key>
+	<integer>1</integer>
+	<key>Items</key>
+	<array>
+		<dict>
+			<key>Count</key>
+			<string>many</string>
+			<key>Extra</key>
+			<true/>
+		</dict>
+	</array>
+	<key>Valid</key>
+	<string>ok</string>
+	<key>Valid</key>
+	<string>again</string>
+</dict>
+</plist>`
+
+	type item struct {
+		Count int
+	}
+	var v struct {
+		Name    string
+		Items   []item
+		Valid   string
+		Missing string `plist:",required"`
+	}
+
+	// Decoding stops at the first problem by default.
+	dec := NewXMLDecoder(strings.NewReader(doc))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&v); err == nil {
+		t.Fatal("expected error")
+	} else if _, ok := err.(DecodeErrors); ok {
+		t.Fatal("expected a single error by default")
+	}
+
+	dec = NewXMLDecoder(strings.NewReader(doc))
+	dec.DisallowUnknownFields()
+	dec.CollectErrors(true)
+	err := dec.Decode(&v)
+	errs, ok := err.(DecodeErrors)
+	if !ok {
+		t.Fatalf("expected DecodeErrors, got %v", err)
+	}
+	var paths []string
+	for _, e := range errs {
+		paths = append(paths, e.Path)
+	}
+	sort.Strings(paths)
+	want := []string{"", "Items.0.Count", "Items.0.Extra", "Name", "Valid"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("got paths %q, want %q\n%v", paths, want, err)
+	}
+	var required *RequiredKeyError
+	for _, e := range errs {
+		if r, ok := e.Err.(*RequiredKeyError); ok {
+			required = r
+		}
+	}
+	if required == nil {
+		t.Error("expected a RequiredKeyError")
+	}
+	// Valid values are still decoded.
+	if v.Valid != "again" {
+		t.Errorf("got Valid %q", v.Valid)
+	}
+}
+
+func TestDecodeCollectErrorsOrder(t *testing.T) {
+	const doc = `<plist version="1.0"><dict>
+	<key>d</key><string>x</string>
+	<key>b</key><string>x</string>
+	<key>c</key><string>x</string>
+	<key>a</key><string>x</string>
+</dict></plist>`
+	want := []string{"d", "b", "c", "a"}
+	for i := 0; i < 50; i++ {
+		var v map[string]int
+		dec := NewXMLDecoder(strings.NewReader(doc))
+		dec.CollectErrors(true)
+		err := dec.Decode(&v)
+		errs, ok := err.(DecodeErrors)
+		if !ok {
+			t.Fatalf("expected DecodeErrors, got %v", err)
+		}
+		var paths []string
+		for _, e := range errs {
+			paths = append(paths, e.Path)
+		}
+		if !reflect.DeepEqual(paths, want) {
+			t.Fatalf("got paths %q, want %q in document order", paths, want)
+		}
+	}
+}
+
+func TestRequiredKeysInArray(t *testing.T) {
+	const doc = `<plist><dict><key>PayloadContent</key><array>
+	<dict><key>PayloadType</key><string>a</string></dict>
+	<dict><key>PayloadType</key><string>b</string><key>PayloadUUID</key><string>1</string></dict>
+	<dict></dict>
+</array></dict></plist>`
+	type payload struct {
+		PayloadType string `plist:",required"`
+		PayloadUUID string `plist:",required"`
+	}
+	var profile struct {
+		PayloadContent []payload
+	}
+
+	err := Unmarshal([]byte(doc), &profile)
+	var required *RequiredKeyError
+	if !errors.As(err, &required) {
+		t.Fatalf("got error %v, want a RequiredKeyError", err)
+	}
+	if required.Path != "PayloadContent.0" || !reflect.DeepEqual(required.Keys, []string{"PayloadUUID"}) {
+		t.Errorf("got path %q and keys %v", required.Path, required.Keys)
+	}
+	if !strings.HasSuffix(err.Error(), " at PayloadContent.0") {
+		t.Errorf("error doesn't give the path: %v", err)
+	}
+
+	dec := NewXMLDecoder(strings.NewReader(doc))
+	dec.CollectErrors(true)
+	errs, ok := dec.Decode(&profile).(DecodeErrors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("got %v, want two errors", errs)
+	}
+	wantKeys := map[string][]string{
+		"PayloadContent.0": {"PayloadUUID"},
+		"PayloadContent.2": {"PayloadType", "PayloadUUID"},
+	}
+	for _, e := range errs {
+		r, ok := e.Err.(*RequiredKeyError)
+		if !ok || !reflect.DeepEqual(r.Keys, wantKeys[e.Path]) {
+			t.Errorf("%s: got %v", e.Path, e.Err)
+		}
+		if strings.Count(e.Error(), "PayloadContent") != 1 {
+			t.Errorf("path repeated in %v", e)
+		}
+	}
+}
+
+func TestDecodeBinaryTrailer(t *testing.T) {
+	out, err := MarshalBinary([]interface{}{"foo", "bar", uint64(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := NewBinaryDecoder(bytes.NewReader(out))
+	if dec.BinaryTrailer() != nil {
+		t.Error("expected no trailer before Decode")
+	}
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	want := &Trailer{
+		OffsetIntSize:     1,
+		ObjectRefSize:     1,
+		NumObjects:        4,
+		RootObject:        0,
+		OffsetTableOffset: uint64(len(out) - 32 - 4),
+	}
+	if got := dec.BinaryTrailer(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	xmlDec := NewXMLDecoder(strings.NewReader(indentRef))
+	if err := xmlDec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if xmlDec.BinaryTrailer() != nil {
+		t.Error("expected no trailer for XML")
+	}
+}
+
+func TestUnmarshalPath(t *testing.T) {
+	const doc = `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>Skipped</key>
+	<dict>
+		<key>Deep</key>
+		<array><string>a</string></array>
+	</dict>
+	<key>PayloadContent</key>
+	<array>
+		<dict><key>PayloadUUID</key><string>uuid-0</string></dict>
+		<dict><key>PayloadUUID</key><string>uuid-1</string></dict>
+	</array>
+</dict>
+</plist>`
+	bin, err := MarshalBinary(map[string]interface{}{
+		"Skipped": map[string]interface{}{"Deep": []interface{}{"a"}},
+		"PayloadContent": []interface{}{
+			map[string]interface{}{"PayloadUUID": "uuid-0"},
+			map[string]interface{}{"PayloadUUID": "uuid-1"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string][]byte{"xml": []byte(doc), "binary": bin} {
+		var uuid string
+		if err := UnmarshalPath(data, &uuid, "PayloadContent", "1", "PayloadUUID"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if uuid != "uuid-1" {
+			t.Errorf("%s: got %q", name, uuid)
+		}
+
+		var payload map[string]string
+		if err := UnmarshalPath(data, &payload, "PayloadContent", "0"); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if payload["PayloadUUID"] != "uuid-0" {
+			t.Errorf("%s: got %v", name, payload)
+		}
+
+		for _, path := range [][]string{
+			{"Missing"},
+			{"PayloadContent", "2"},
+			{"PayloadContent", "x"},
+			{"PayloadContent", "0", "PayloadUUID", "more"},
+		} {
+			var v interface{}
+			if err := UnmarshalPath(data, &v, path...); !errors.Is(err, ErrPathNotFound) {
+				t.Errorf("%s: %q: expected ErrPathNotFound, got %v", name, path, err)
+			}
+		}
+	}
+}
+
+func TestSetRootPath(t *testing.T) {
+	type payload struct {
+		PayloadType string
+		Port        int
+	}
+	profile := map[string]interface{}{
+		"PayloadType": "Configuration",
+		"PayloadContent": []interface{}{
+			map[string]interface{}{"PayloadType": "com.apple.wifi.managed", "Port": 8080},
+		},
+	}
+	xmlData, err := Marshal(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binData, err := MarshalBinary(profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoders := map[string]func() *Decoder{
+		"xml":    func() *Decoder { return NewXMLDecoder(bytes.NewReader(xmlData)) },
+		"binary": func() *Decoder { return NewBinaryDecoder(bytes.NewReader(binData)) },
+	}
+	for name, newDecoder := range decoders {
+		var got payload
+		dec := newDecoder()
+		dec.SetRootPath("PayloadContent", "0")
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&got); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if want := (payload{"com.apple.wifi.managed", 8080}); got != want {
+			t.Errorf("%s: got %+v, want %+v", name, got, want)
+		}
+
+		dec = newDecoder()
+		dec.SetRootPath("PayloadContent", "0")
+		dec.OnlyKeys("Port")
+		var onlyPort map[string]interface{}
+		if err := dec.Decode(&onlyPort); err != nil {
+			t.Fatal(err)
+		}
+		if want := map[string]interface{}{"Port": uint64(8080)}; !reflect.DeepEqual(onlyPort, want) {
+			t.Errorf("%s: OnlyKeys: got %v, want %v", name, onlyPort, want)
+		}
+
+		dec = newDecoder()
+		dec.SetRootPath("PayloadContent", "1")
+		if err := dec.Decode(&got); !errors.Is(err, ErrPathNotFound) {
+			t.Errorf("%s: got error %v, want ErrPathNotFound", name, err)
+		}
+	}
+
+	// The path is resolved in each document of a stream.
+	second := map[string]interface{}{
+		"PayloadContent": []interface{}{
+			map[string]interface{}{"PayloadType": "com.apple.vpn.managed", "Port": 443},
+			map[string]interface{}{"PayloadType": "unused"},
+		},
+	}
+	secondData, err := Marshal(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := NewXMLDecoder(bytes.NewReader(append(append([]byte(nil), xmlData...), secondData...)))
+	dec.SetRootPath("PayloadContent", "0")
+	for _, want := range []payload{{"com.apple.wifi.managed", 8080}, {"com.apple.vpn.managed", 443}} {
+		var got payload
+		if err := dec.Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("stream: got %+v, want %+v", got, want)
+		}
+	}
+	var rest payload
+	if err := dec.Decode(&rest); err != io.EOF {
+		t.Errorf("stream: got error %v after the last document, want io.EOF", err)
+	}
+}
+
+func TestDecodeEmptyDocument(t *testing.T) {
+	docs := []string{
+		`<plist version="1.0"></plist>`,
+		`<plist version="1.0"/>`,
+		`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+</plist>
+`,
+	}
+	for _, doc := range docs {
+		v := interface{}("unchanged")
+		if err := Unmarshal([]byte(doc), &v); err != ErrEmptyDocument {
+			t.Errorf("%q: got error %v, want ErrEmptyDocument", doc, err)
+		}
+		if v != "unchanged" {
+			t.Errorf("%q: value was changed to %#v", doc, v)
+		}
+		if err := UnmarshalPath([]byte(doc), &v, "a"); err != ErrEmptyDocument {
+			t.Errorf("%q: UnmarshalPath: got error %v, want ErrEmptyDocument", doc, err)
+		}
+	}
+}
+
+func TestDecodeAlternateKeys(t *testing.T) {
+	type payload struct {
+		Organization string `plist:"PayloadOrganization|Organization|Org"`
+		Name         string
+	}
+	tests := []struct {
+		doc  string
+		want string
+	}{
+		{`<plist><dict><key>PayloadOrganization</key><string>a</string></dict></plist>`, "a"},
+		{`<plist><dict><key>Organization</key><string>b</string></dict></plist>`, "b"},
+		{`<plist><dict><key>Org</key><string>c</string><key>Organization</key><string>b</string></dict></plist>`, "b"},
+		{`<plist><dict><key>Org</key><string>c</string><key>PayloadOrganization</key><string>a</string></dict></plist>`, "a"},
+	}
+	for _, tt := range tests {
+		var got payload
+		dec := NewXMLDecoder(strings.NewReader(tt.doc))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&got); err != nil {
+			t.Fatalf("%s: %v", tt.doc, err)
+		}
+		if got.Organization != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.doc, got.Organization, tt.want)
+		}
+
+		dec = NewXMLDecoder(strings.NewReader(tt.doc))
+		dec.DisallowAmbiguousKeys()
+		err := dec.Decode(&got)
+		if ambiguous := strings.Count(tt.doc, "<key>") > 1; ambiguous != (err != nil) {
+			t.Errorf("%s: DisallowAmbiguousKeys: got error %v", tt.doc, err)
+		}
+	}
+
+	// Encoding uses the first key.
+	data, err := Marshal(payload{Organization: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "<key>PayloadOrganization</key>") || strings.Contains(string(data), "|") {
+		t.Errorf("encoded with the wrong key:\n%s", data)
+	}
+	if keys := StructKeys(payload{}); !reflect.DeepEqual(keys, []string{"Name", "Org", "Organization", "PayloadOrganization"}) {
+		t.Errorf("StructKeys: got %v", keys)
+	}
+}
+
+func TestTypedJSON(t *testing.T) {
+	date := time.Date(2020, 5, 6, 7, 8, 9, 0, time.UTC)
+	in := map[string]interface{}{
+		"name":    "profile",
+		"count":   3,
+		"neg":     -4,
+		"ratio":   2.0,
+		"enabled": true,
+		"date":    date,
+		"data":    []byte{0, 1, 2},
+		"uid":     UID(7),
+		"items":   []interface{}{"a", 1.5},
+		"nested":  map[string]interface{}{"$plist": "not a tag"},
+	}
+	data, err := MarshalBinary(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	typed, err := ToTypedJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`"date":{"$plist":"date","value":"2020-05-06T07:08:09Z"}`,
+		`"data":{"$plist":"data","value":"AAEC"}`,
+		`"uid":{"$plist":"uid","value":7}`,
+		`"ratio":{"$plist":"real","value":2}`,
+		`"nested":{"$plist":"dict","value":{"$plist":"not a tag"}}`,
+		`"neg":-4`,
+	} {
+		if !strings.Contains(string(typed), want) {
+			t.Errorf("typed JSON %s doesn't contain %s", typed, want)
+		}
+	}
+
+	back, err := FromJSON(typed, FormatBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want, got interface{}
+	if err := Unmarshal(data, &want); err != nil {
+		t.Fatal(err)
+	}
+	if err := Unmarshal(back, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip:\ngot  %#v\nwant %#v", got, want)
+	}
+
+	xmlBack, err := FromJSON(typed, FormatXML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	if err := Unmarshal(xmlBack, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("XML round trip:\ngot  %#v\nwant %#v", got, want)
+	}
+	plain, err := ToJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(plain), `"date":"2020-05-06T07:08:09Z"`) || !strings.Contains(string(plain), `"uid":7`) {
+		t.Errorf("plain JSON: %s", plain)
+	}
+	for _, bad := range []string{`null`, `{"$plist":"date","value":"yesterday"}`, `{"$plist":"color","value":1}`, `{"$plist":"uid"}`} {
+		if _, err := FromJSON([]byte(bad), FormatXML); err == nil {
+			t.Errorf("%s: expected error", bad)
+		}
+	}
+}
+
+func TestDecodeTimePointer(t *testing.T) {
+	type expiring struct {
+		Expires *time.Time
+	}
+	var present, absent expiring
+	if err := Unmarshal([]byte(`<plist version="1.0"><dict><key>Expires</key><date>2020-01-02T03:04:05Z</date></dict></plist>`), &present); err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC); present.Expires == nil || !present.Expires.Equal(want) {
+		t.Errorf("got %v, want %v", present.Expires, want)
+	}
+	if err := Unmarshal([]byte(`<plist version="1.0"><dict></dict></plist>`), &absent); err != nil {
+		t.Fatal(err)
+	}
+	if absent.Expires != nil {
+		t.Errorf("expected nil for absent date, got %v", absent.Expires)
+	}
+}
+
+func TestDecoderDataReader(t *testing.T) {
+	payload := bytes.Repeat([]byte("large payload "), 100000)
+	v := map[string]interface{}{
+		"before":  []byte("skipped"),
+		"payload": payload,
+		"empty":   []byte{},
+		"name":    "foo",
+	}
+	xmlDoc, err := MarshalIndent(v, "\t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	binDoc, err := MarshalBinary(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzipped := func(data []byte) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(data)
+		zw.Close()
+		return buf.Bytes()
+	}
+	xmlGzip, binGzip := gzipped(xmlDoc), gzipped(binDoc)
+	decoders := map[string]func() *Decoder{
+		"xml":         func() *Decoder { return NewXMLDecoder(bytes.NewReader(xmlDoc)) },
+		"binary":      func() *Decoder { return NewBinaryDecoder(bytes.NewReader(binDoc)) },
+		"gzip xml":    func() *Decoder { return NewXMLDecoder(bytes.NewReader(xmlGzip), WithAutoDecompress()) },
+		"gzip binary": func() *Decoder { return NewXMLDecoder(bytes.NewReader(binGzip), WithAutoDecompress()) },
+	}
+	for name, newDecoder := range decoders {
+		r, err := newDecoder().DataReader("payload")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Errorf("%s: got %d bytes, want %d", name, len(got), len(payload))
+		}
+
+		r, err = newDecoder().DataReader("empty")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got, err := ioutil.ReadAll(r); err != nil || len(got) != 0 {
+			t.Errorf("%s: empty data: got %q, %v", name, got, err)
+		}
+
+		if _, err := newDecoder().DataReader("name"); err == nil {
+			t.Errorf("%s: expected error for string value", name)
+		}
+		if _, err := newDecoder().DataReader("missing"); !errors.Is(err, ErrPathNotFound) {
+			t.Errorf("%s: expected ErrPathNotFound, got %v", name, err)
+		}
+	}
+
+	// Self closing elements are empty.
+	r, err := NewXMLDecoder(strings.NewReader(`<plist version="1.0"><dict><key>d</key><data/><key>s</key><string>x</string></dict></plist>`)).DataReader("d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadAll(r); err != nil || len(got) != 0 {
+		t.Errorf("<data/>: got %q, %v", got, err)
+	}
+}
+
+type strictUnmarshaler struct {
+	Inner strictInner
+}
+
+func (s *strictUnmarshaler) UnmarshalPlist(f func(interface{}) error) error {
+	return f(&s.Inner)
+}
+
+type strictInner struct {
+	Known string
+}
+
+func TestDisallowUnknownFieldsNested(t *testing.T) {
+	doc := func(inner string) string {
+		return `<plist version="1.0">` + inner + `</plist>`
+	}
+	const bad = `<dict><key>Known</key><string>x</string><key>Unknown</key><string>y</string></dict>`
+	const good = `<dict><key>Known</key><string>x</string></dict>`
+
+	tests := []struct {
+		name string
+		doc  string
+		v    interface{}
+	}{
+		{"map of structs", doc(`<dict><key>a</key>` + bad + `</dict>`), &map[string]strictInner{}},
+		{"slice of structs", doc(`<array>` + good + bad + `</array>`), &[]strictInner{}},
+		{"pointer field", doc(`<dict><key>P</key>` + bad + `</dict>`), &struct{ P *strictInner }{}},
+		{"map in struct", doc(`<dict><key>M</key><dict><key>a</key>` + bad + `</dict></dict>`), &struct{ M map[string]strictInner }{}},
+		{"unmarshaler", doc(bad), &strictUnmarshaler{}},
+	}
+	for _, tt := range tests {
+		dec := NewXMLDecoder(strings.NewReader(tt.doc))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(tt.v); err == nil || !strings.Contains(err.Error(), `unknown key "Unknown"`) {
+			t.Errorf("%s: expected unknown key error, got %v", tt.name, err)
+		}
+
+		// Without the option the unknown key is ignored.
+		if err := NewXMLDecoder(strings.NewReader(tt.doc)).Decode(tt.v); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+	}
+}
+
+func TestDecodePaddedValues(t *testing.T) {
+	const padded = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0"><dict>
+	<key>int</key><integer> 42 </integer>
+	<key>real</key><real>
+		1.5
+	</real>
+	<key>date</key><date>	2020-01-02T03:04:05Z </date>
+</dict></plist>`
+	var v struct {
+		Int  int       `plist:"int"`
+		Real float64   `plist:"real"`
+		Date time.Time `plist:"date"`
+	}
+	if err := Unmarshal([]byte(padded), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Int != 42 || v.Real != 1.5 || !v.Date.Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("unexpected value %+v", v)
+	}
+
+	for _, elem := range []string{
+		"<integer> 42 </integer>",
+		"<real> 1.5</real>",
+		"<date>2020-01-02T03:04:05Z </date>",
+	} {
+		doc := `<plist version="1.0">` + elem + `</plist>`
+		var i interface{}
+		dec := NewXMLDecoder(strings.NewReader(doc))
+		dec.SetStrict(true)
+		if err := dec.Decode(&i); err == nil {
+			t.Errorf("%s: expected error in strict mode, got %v", elem, i)
+		}
+	}
+}
+
+func TestUnmarshalSigned(t *testing.T) {
+	// signed.mobileconfig was signed with a self-signed certificate using
+	// openssl smime -sign -nodetach -binary -outform der.
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "signed.mobileconfig"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var profile struct {
+		PayloadIdentifier string
+		PayloadType       string
+		PayloadVersion    int
+	}
+	certs, err := UnmarshalSigned(data, &profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.PayloadIdentifier != "com.example.signed" || profile.PayloadType != "Configuration" || profile.PayloadVersion != 1 {
+		t.Errorf("unexpected profile %+v", profile)
+	}
+	if len(certs) != 1 || certs[0].Subject.CommonName != "plist test signer" {
+		t.Errorf("unexpected certificates %v", certs)
+	}
+
+	if _, _, err := SignedContent([]byte(`<plist version="1.0"><true/></plist>`)); err == nil {
+		t.Error("expected error for unsigned data")
+	}
+}
+
+func TestDecodeLocations(t *testing.T) {
+	const doc = `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>name</key>
+	<string>foo</string>
+	<key>list</key>
+	<array>
+		<integer>1</integer>
+		<dict><key>ok</key><true/></dict>
+	</array>
+</dict>
+</plist>
+`
+	dec := NewXMLDecoder(strings.NewReader(doc))
+	dec.RecordLocations(true)
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	slice := func(s Span) string { return doc[s.Start:s.End] }
+
+	type want struct {
+		path, key, value string
+	}
+	expected := []want{
+		{"", "", doc[strings.Index(doc, "<dict>") : strings.LastIndex(doc, "</dict>")+len("</dict>")]},
+		{"name", "<key>name</key>", "<string>foo</string>"},
+		{"list", "<key>list</key>", doc[strings.Index(doc, "<array>") : strings.Index(doc, "</array>")+len("</array>")]},
+		{"list.0", "", "<integer>1</integer>"},
+		{"list.1", "", "<dict><key>ok</key><true/></dict>"},
+		{"list.1.ok", "<key>ok</key>", "<true/>"},
+	}
+	locs := dec.Locations()
+	if len(locs) != len(expected) {
+		t.Fatalf("expected %d locations, got %d: %v", len(expected), len(locs), locs)
+	}
+	for i, loc := range locs {
+		got := want{strings.Join(loc.Path, "."), slice(loc.Key), slice(loc.Value)}
+		if got != expected[i] {
+			t.Errorf("location %d: got %q, want %q", i, got, expected[i])
+		}
+	}
+
+	// Locations aren't recorded unless enabled.
+	dec = NewXMLDecoder(strings.NewReader(doc))
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	if dec.Locations() != nil {
+		t.Errorf("expected no locations, got %v", dec.Locations())
+	}
+}
+
+func TestDecodeMaxElements(t *testing.T) {
+	const n = 100000
+	xmlDoc := `<plist version="1.0"><array>` + strings.Repeat("<true/>", n) + `</array></plist>`
+	flat := make([]interface{}, n)
+	for i := range flat {
+		flat[i] = true
+	}
+	binaryDoc, err := MarshalBinary(flat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newDecoders := []func() *Decoder{
+		func() *Decoder { return NewXMLDecoder(strings.NewReader(xmlDoc)) },
+		func() *Decoder { return NewBinaryDecoder(bytes.NewReader(binaryDoc)) },
+	}
+	for _, newDecoder := range newDecoders {
+		var v []bool
+		dec := newDecoder()
+		dec.SetMaxElements(1000)
+		err := dec.Decode(&v)
+		if err == nil || !strings.Contains(err.Error(), "more than 1000 elements") {
+			t.Errorf("expected element limit error, got %v", err)
+		}
+
+		dec = newDecoder()
+		dec.SetMaxElements(n + 2)
+		if err := dec.Decode(&v); err != nil {
+			t.Errorf("unexpected error under the limit: %v", err)
+		} else if len(v) != n {
+			t.Errorf("expected %d elements, got %d", n, len(v))
+		}
+	}
+}
+
+type shape interface {
+	Area() float64
+}
+
+type circle struct {
+	Radius float64
+}
+
+func (c *circle) Area() float64 { return 3 * c.Radius * c.Radius }
+
+type rect struct {
+	Width, Height float64
+}
+
+func (r rect) Area() float64 { return r.Width * r.Height }
+
+func TestDecodeInto(t *testing.T) {
+	const doc = `<plist version="1.0"><dict><key>Shapes</key><array>
+	<dict><key>Kind</key><string>round</string><key>Version</key><integer>1</integer><key>Radius</key><real>2</real></dict>
+	<dict><key>Kind</key><string>box</string><key>Width</key><real>2</real><key>Height</key><real>3</real></dict>
+	<dict><key>Kind</key><string>round</string><key>Version</key><integer>2</integer><key>Nested</key><dict/></dict>
+</array></dict></plist>`
+	constructor := func(probe map[string]interface{}) interface{} {
+		if _, ok := probe["Nested"]; ok {
+			t.Error("probe includes nested dict")
+		}
+		switch {
+		case probe["Kind"] == "round" && probe["Version"] == uint64(1):
+			return &circle{}
+		case probe["Kind"] == "box":
+			return &rect{}
+		}
+		return nil
+	}
+
+	var shapes struct {
+		Shapes []shape
+	}
+	err := DecodeInto([]byte(doc), &shapes, constructor)
+	if err == nil {
+		t.Fatal("expected error for dict the constructor doesn't handle")
+	}
+
+	var generic struct {
+		Shapes []interface{}
+	}
+	if err := DecodeInto([]byte(doc), &generic, constructor); err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := generic.Shapes[0].(*circle); !ok || c.Radius != 2 {
+		t.Errorf("expected *circle, got %#v", generic.Shapes[0])
+	}
+	if r, ok := generic.Shapes[1].(*rect); !ok || r.Area() != 6 {
+		t.Errorf("expected *rect, got %#v", generic.Shapes[1])
+	}
+	if _, ok := generic.Shapes[2].(map[string]interface{}); !ok {
+		t.Errorf("expected map when the constructor returns nil, got %T", generic.Shapes[2])
+	}
+
+	shapes.Shapes = nil
+	err = DecodeInto([]byte(strings.Replace(doc, "<integer>2</integer>", "<integer>1</integer>", 1)), &shapes, constructor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shapes.Shapes) != 3 || shapes.Shapes[0].Area() != 12 || shapes.Shapes[1].Area() != 6 {
+		t.Errorf("unexpected shapes %#v", shapes.Shapes)
+	}
+
+	bad := func(map[string]interface{}) interface{} { return circle{} }
+	if err := DecodeInto([]byte(doc), &shapes, bad); err == nil {
+		t.Error("expected error for non-pointer constructor result")
+	}
+}
+
+func TestDecodeDataVariants(t *testing.T) {
+	// base64 of "\xfb\xff\xfe" uses both + and /, and "ab" needs padding.
+	tests := []struct {
+		name, text string
+		expected   []byte
+	}{
+		{"standard", "+//+", []byte{0xfb, 0xff, 0xfe}},
+		{"url", "-__-", []byte{0xfb, 0xff, 0xfe}},
+		{"unpadded", "YWI", []byte("ab")},
+		{"url unpadded", "-__-YWI", []byte{0xfb, 0xff, 0xfe, 'a', 'b'}},
+		{"invalid", "!!!!", nil},
+	}
+	for _, tt := range tests {
+		doc := `<plist version="1.0"><data>` + tt.text + `</data></plist>`
+		var data []byte
+		err := Unmarshal([]byte(doc), &data)
+		if tt.expected == nil {
+			if err == nil {
+				t.Errorf("%s: expected error, got %x", tt.name, data)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if !bytes.Equal(data, tt.expected) {
+			t.Errorf("%s: got %x, want %x", tt.name, data, tt.expected)
+		}
+
+		dec := NewXMLDecoder(strings.NewReader(doc))
+		dec.SetStrict(true)
+		err = dec.Decode(&data)
+		if tt.name == "standard" && err != nil {
+			t.Errorf("%s: unexpected error in strict mode: %v", tt.name, err)
+		} else if tt.name != "standard" && err == nil {
+			t.Errorf("%s: expected error in strict mode", tt.name)
+		}
+	}
+}
+
+func TestDecodeNestedPointerStructs(t *testing.T) {
+	type leaf struct {
+		Value int
+	}
+	type section struct {
+		Leaf    *leaf
+		Missing *leaf
+	}
+	type config struct {
+		Section *section
+		Missing *section
+	}
+	const doc = `<plist version="1.0"><dict>
+	<key>Section</key><dict>
+		<key>Leaf</key><dict><key>Value</key><integer>3</integer></dict>
+	</dict>
+</dict></plist>`
+
+	var c *config
+	if err := Unmarshal([]byte(doc), &c); err != nil {
+		t.Fatal(err)
+	}
+	if c == nil || c.Section == nil || c.Section.Leaf == nil {
+		t.Fatalf("expected allocated pointers, got %+v", c)
+	}
+	if c.Section.Leaf.Value != 3 {
+		t.Errorf("unexpected leaf %+v", c.Section.Leaf)
+	}
+	if c.Missing != nil || c.Section.Missing != nil {
+		t.Errorf("expected absent sections to stay nil, got %+v and %+v", c.Missing, c.Section.Missing)
+	}
+
+	// Existing values are decoded into rather than replaced.
+	existing := &leaf{Value: 1}
+	c = &config{Section: &section{Missing: existing}}
+	if err := Unmarshal([]byte(doc), c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Section.Missing != existing || existing.Value != 1 {
+		t.Errorf("existing pointer changed: %+v", c.Section.Missing)
+	}
+}
+
+func TestDecodeMixedArrayField(t *testing.T) {
+	const doc = `<plist version="1.0"><dict>
+	<key>Items</key><array>
+		<string>a</string>
+		<integer>1</integer>
+		<true/>
+		<dict><key>k</key><array><integer>-2</integer><string>b</string></array></dict>
+	</array>
+	<key>Nested</key><dict>
+		<key>More</key><array><real>1.5</real><array><false/></array></array>
+	</dict>
+</dict></plist>`
+	var v struct {
+		Items  []interface{}
+		Nested struct {
+			More []interface{}
+		}
+	}
+	if err := Unmarshal([]byte(doc), &v); err != nil {
+		t.Fatal(err)
+	}
+	items := []interface{}{
+		"a",
+		uint64(1),
+		true,
+		map[string]interface{}{"k": []interface{}{int64(-2), "b"}},
+	}
+	if !reflect.DeepEqual(v.Items, items) {
+		t.Errorf("got %#v, want %#v", v.Items, items)
+	}
+	more := []interface{}{1.5, []interface{}{false}}
+	if !reflect.DeepEqual(v.Nested.More, more) {
+		t.Errorf("got %#v, want %#v", v.Nested.More, more)
+	}
+}
+
+// testUUID stands in for a type of another package, stored as a string.
+type testUUID [4]byte
+
+func init() {
+	uuidType := reflect.TypeOf(testUUID{})
+	RegisterEncoder(uuidType, func(v interface{}) (interface{}, error) {
+		u := v.(testUUID)
+		return base64.StdEncoding.EncodeToString(u[:]), nil
+	})
+	RegisterDecoder(uuidType, func(v interface{}) (interface{}, error) {
+		s, ok := v.(string)
+		if !ok {
+			return nil, errors.New("uuid must be a string")
+		}
+		var u testUUID
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil || len(b) != len(u) {
+			return nil, errors.New("invalid uuid")
+		}
+		copy(u[:], b)
+		return u, nil
+	})
+}
+
+func TestRegisteredConverters(t *testing.T) {
+	type device struct {
+		ID     testUUID
+		Parent *testUUID
+		Others []testUUID
+	}
+	parent := testUUID{5, 6, 7, 8}
+	in := device{ID: testUUID{1, 2, 3, 4}, Parent: &parent, Others: []testUUID{{9, 9, 9, 9}}}
+	out, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(out, []byte("<key>ID</key><string>AQIDBA==</string>")) {
+		t.Errorf("expected registered encoding in\n%s", out)
+	}
+	var got device
+	if err := Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, in) {
+		t.Errorf("got %+v, want %+v", got, in)
+	}
+
+	doc := `<plist version="1.0"><dict><key>ID</key><integer>1</integer></dict></plist>`
+	if err := Unmarshal([]byte(doc), &got); err == nil || !strings.Contains(err.Error(), "uuid must be a string") {
+		t.Errorf("expected error from registered decoder, got %v", err)
+	}
+
+	// Nil pointers in interfaces are left out like other nil values.
+	for _, v := range []interface{}{
+		map[string]interface{}{"a": (*testUUID)(nil)},
+		struct{ A interface{} }{(*testUUID)(nil)},
+	} {
+		out, err := Marshal(v)
+		if err != nil {
+			t.Fatalf("%#v: %v", v, err)
+		}
+		if !bytes.Contains(out, []byte("<dict></dict>")) {
+			t.Errorf("%#v: expected an empty dict in\n%s", v, out)
+		}
+	}
+}
+
+func TestDecodeMalformedDict(t *testing.T) {
+	const prefix = `<plist version="1.0">`
+	tests := []struct {
+		name, dict, msg string
+	}{
+		{"key at end", `<dict><key>a</key></dict>`, `dict key "a" has no value`},
+		{"key after key", `<dict><key>a</key><key>b</key><string>x</string></dict>`, `dict key "a" has no value`},
+		{"value first", `<dict><string>x</string></dict>`, `<string> in dict without a key`},
+		{"value after value", `<dict><key>a</key><string>x</string><string>y</string></dict>`, `<string> in dict without a key`},
+	}
+	for _, tt := range tests {
+		doc := prefix + tt.dict + `</plist>`
+		// Keys without a value are reported at the key, and values without
+		// a key at the value.
+		var want int64
+		if strings.HasPrefix(tt.msg, "dict key") {
+			want = int64(len(prefix) + len("<dict>"))
+		} else {
+			want = int64(strings.LastIndex(doc, "<string>"))
+		}
+		var v interface{}
+		err := Unmarshal([]byte(doc), &v)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("%s: expected *SyntaxError, got %v", tt.name, err)
+			continue
+		}
+		if syntaxErr.Msg != tt.msg || syntaxErr.Offset != want {
+			t.Errorf("%s: got %q at %d, want %q at %d", tt.name, syntaxErr.Msg, syntaxErr.Offset, tt.msg, want)
+		}
+	}
+}
+
+func TestDecodeBoth(t *testing.T) {
+	const doc = `<plist version="1.0"><dict>
+	<key>Name</key><string>printer</string>
+	<key>Port</key><integer>631</integer>
+	<key>Vendor</key><dict><key>Extra</key><true/></dict>
+</dict></plist>`
+	var known struct {
+		Name string
+		Port int
+	}
+	tree, err := NewXMLDecoder(strings.NewReader(doc)).DecodeBoth(&known)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if known.Name != "printer" || known.Port != 631 {
+		t.Errorf("unexpected struct %+v", known)
+	}
+	expected := map[string]interface{}{
+		"Name":   "printer",
+		"Port":   uint64(631),
+		"Vendor": map[string]interface{}{"Extra": true},
+	}
+	if !reflect.DeepEqual(tree, expected) {
+		t.Errorf("got %#v, want %#v", tree, expected)
+	}
+
+	var wrong struct {
+		Name int
+	}
+	if _, err := NewXMLDecoder(strings.NewReader(doc)).DecodeBoth(&wrong); err == nil {
+		t.Error("expected error decoding into the wrong type")
+	}
+}
+
+func TestDecodeTimeLocation(t *testing.T) {
+	const doc = `<plist version="1.0"><dict><key>When</key><date>2011-05-12T01:00:00+02:00</date></dict></plist>`
+	instant := time.Date(2011, 5, 11, 23, 0, 0, 0, time.UTC)
+
+	var v struct{ When time.Time }
+	if err := Unmarshal([]byte(doc), &v); err != nil {
+		t.Fatal(err)
+	}
+	if _, offset := v.When.Zone(); offset != 2*60*60 || !v.When.Equal(instant) {
+		t.Errorf("expected parsed zone to be kept, got %v", v.When)
+	}
+
+	tokyo := time.FixedZone("JST", 9*60*60)
+	for _, loc := range []*time.Location{time.UTC, tokyo} {
+		dec := NewXMLDecoder(strings.NewReader(doc))
+		dec.SetTimeLocation(loc)
+		if err := dec.Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if v.When.Location() != loc || !v.When.Equal(instant) {
+			t.Errorf("expected %v in %v, got %v", instant, loc, v.When)
+		}
+
+		var generic map[string]interface{}
+		dec = NewXMLDecoder(strings.NewReader(doc))
+		dec.SetTimeLocation(loc)
+		if err := dec.Decode(&generic); err != nil {
+			t.Fatal(err)
+		}
+		if when := generic["When"].(time.Time); when.Location() != loc || !when.Equal(instant) {
+			t.Errorf("expected %v in %v, got %v", instant, loc, when)
+		}
+	}
+}
+
+func TestDecodeRootTypeMismatch(t *testing.T) {
+	type response struct {
+		Status string
+	}
+	tests := []struct {
+		root, value string
+	}{
+		{"<string>ok</string>", `string "ok"`},
+		{"<integer>-3</integer>", "integer -3"},
+		{"<real>1.5</real>", "real 1.5"},
+		{"<true/>", "true"},
+		{"<data>AQ==</data>", "data"},
+		{"<date>2020-01-02T03:04:05Z</date>", "date 2020-01-02T03:04:05Z"},
+		{"<array><string>ok</string></array>", "array"},
+	}
+	for _, tt := range tests {
+		var r response
+		err := Unmarshal([]byte(`<plist version="1.0">`+tt.root+`</plist>`), &r)
+		var typeErr UnmarshalTypeError
+		if !errors.As(err, &typeErr) {
+			t.Errorf("%s: expected UnmarshalTypeError, got %v", tt.root, err)
+			continue
+		}
+		if typeErr.Value != tt.value || typeErr.Type != reflect.TypeOf(r) {
+			t.Errorf("%s: got %q into %v, want %q", tt.root, typeErr.Value, typeErr.Type, tt.value)
+		}
+	}
+
+	// A dict has no fields to fill a time.Time with.
+	var date time.Time
+	err := Unmarshal([]byte(`<plist version="1.0"><dict><key>a</key><true/></dict></plist>`), &date)
+	if _, ok := err.(UnmarshalTypeError); !ok {
+		t.Errorf("expected UnmarshalTypeError for dict into time.Time, got %v", err)
+	}
+}
+
+func TestDecodeIntegerRange(t *testing.T) {
+	tests := []struct {
+		value interface{} // encoded as XML and binary
+		into  interface{}
+		ok    bool
+	}{
+		{127, new(int8), true},
+		{-128, new(int8), true},
+		{300, new(int8), false},
+		{-129, new(int8), false},
+		{1000, new(uint8), false},
+		{255, new(uint8), true},
+		{-1, new(uint8), false},
+		{int64(math.MaxInt64), new(int64), true},
+		{uint64(math.MaxUint64), new(int64), false},
+		{uint64(math.MaxUint64), new(uint64), true},
+		{uint64(1 << 32), new(uint32), false},
+		{uint64(1 << 32), new(int32), false},
+	}
+	for _, tt := range tests {
+		for _, marshal := range []func(interface{}) ([]byte, error){Marshal, MarshalBinary} {
+			data, err := marshal(tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v := reflect.New(reflect.TypeOf(tt.into).Elem())
+			err = Unmarshal(data, v.Interface())
+			if tt.ok {
+				if err != nil {
+					t.Errorf("%v into %v: %v", tt.value, v.Elem().Type(), err)
+				} else if got := fmt.Sprint(v.Elem()); got != fmt.Sprint(tt.value) {
+					t.Errorf("%v into %v: got %s", tt.value, v.Elem().Type(), got)
+				}
+				continue
+			}
+			if _, ok := err.(UnmarshalTypeError); !ok {
+				t.Errorf("%v into %v: got %v (%v), want UnmarshalTypeError", tt.value, v.Elem().Type(), err, v.Elem())
+			}
+		}
+	}
+
+	// Binary 8-byte integers still fill a uint64 with their 64 bits.
+	data, err := MarshalBinary(int64(-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var u uint64
+	if err := Unmarshal(data, &u); err != nil || u != math.MaxUint64 {
+		t.Errorf("got %d, %v, want %d", u, err, uint64(math.MaxUint64))
+	}
+	var small uint8
+	if err := Unmarshal(data, &small); err == nil {
+		t.Errorf("got %d, want UnmarshalTypeError", small)
+	}
+}
+
+func TestMergeIntoMap(t *testing.T) {
+	type server struct {
+		Host string
+		Port int
+	}
+	const doc = `<plist version="1.0"><dict>
+	<key>name</key><dict><key>Host</key><string>example.com</string></dict>
+	<key>other</key><dict><key>Port</key><integer>8080</integer></dict>
+</dict></plist>`
+	defaults := func() map[string]server {
+		return map[string]server{
+			"name":     {Host: "localhost", Port: 80},
+			"fallback": {Host: "backup", Port: 81},
+		}
+	}
+
+	// By default entries the dict has are replaced, and the others kept.
+	m := defaults()
+	if err := Unmarshal([]byte(doc), &m); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]server{
+		"name":     {Host: "example.com"},
+		"other":    {Port: 8080},
+		"fallback": {Host: "backup", Port: 81},
+	}
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("got %+v, want %+v", m, expected)
+	}
+
+	m = defaults()
+	dec := NewXMLDecoder(strings.NewReader(doc))
+	dec.MergeIntoMap(true)
+	if err := dec.Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	expected["name"] = server{Host: "example.com", Port: 80}
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("got %+v, want %+v", m, expected)
+	}
+}
+
+func TestDecodeScientificReals(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+	}{
+		{"1.5e10", 1.5e10},
+		{"3E-4", 3e-4},
+		{"-2.5e+300", -2.5e300},
+		{"6.02214076E23", 6.02214076e23},
+		{"1e-320", 1e-320},
+	}
+	for _, tt := range tests {
+		doc := `<plist version="1.0"><real>` + tt.in + `</real></plist>`
+		var f float64
+		if err := Unmarshal([]byte(doc), &f); err != nil {
+			t.Errorf("%s: %v", tt.in, err)
+			continue
+		}
+		if f != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.in, f, tt.want)
+		}
+
+		// A Number keeps the literal when it is encoded again.
+		var n Number
+		if err := Unmarshal([]byte(doc), &n); err != nil {
+			t.Fatal(err)
+		}
+		out, err := Marshal(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(out), "<real>"+tt.in+"</real>") {
+			t.Errorf("%s: re-encoded as %s", tt.in, out)
+		}
+	}
+
+	// Floats are encoded in the shortest form, with an exponent when it is
+	// shorter, and decode to the same value.
+	for _, f := range []float64{1e21, 1.5e-7, math.MaxFloat64, math.SmallestNonzeroFloat64} {
+		out, err := Marshal(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "<real>" + strconv.FormatFloat(f, 'g', -1, 64) + "</real>"
+		if !strings.Contains(string(out), want) {
+			t.Errorf("%v: got %s, want %s", f, out, want)
+		}
+		var got float64
+		if err := Unmarshal(out, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got != f {
+			t.Errorf("got %v, want %v", got, f)
+		}
+	}
+}
+
+func TestRawValue(t *testing.T) {
+	const doc = `<plist version="1.0"><dict>
+	<key>Name</key><string>profile</string>
+	<key>Payload</key><dict><key>Count</key><integer>3</integer></dict>
+</dict></plist>`
+	type envelope struct {
+		Name    string
+		Payload RawValue
+		Missing RawValue
+	}
+	var env envelope
+	if err := Unmarshal([]byte(doc), &env); err != nil {
+		t.Fatal(err)
+	}
+	var payload map[string]int
+	if err := Unmarshal(env.Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload["Count"] != 3 {
+		t.Errorf("got %v, want Count 3", payload)
+	}
+
+	// The raw value is written back in place, and empty ones are left out.
+	out, err := Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var back map[string]interface{}
+	if err := Unmarshal(out, &back); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"Name":    "profile",
+		"Payload": map[string]interface{}{"Count": uint64(3)},
+	}
+	if !reflect.DeepEqual(back, want) {
+		t.Errorf("got %v, want %v", back, want)
+	}
+
+	// Binary input gives binary raw values.
+	bin, err := MarshalBinary(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env = envelope{}
+	if err := Unmarshal(bin, &env); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(env.Payload, []byte("bplist00")) {
+		t.Errorf("expected a binary raw value, got %q", env.Payload)
+	}
+}
+
+// fallbackNode rejects dicts without a Kind of node with the same error as a
+// mismatched dict.
+type fallbackNode struct {
+	Children []fallbackNode
+}
+
+func (n *fallbackNode) UnmarshalPlist(f func(interface{}) error) error {
+	var aux struct {
+		Kind     string
+		Children []fallbackNode
+	}
+	if err := f(&aux); err != nil {
+		return err
+	}
+	if aux.Kind != "node" {
+		return UnmarshalTypeError{"dict", reflect.TypeOf(*n)}
+	}
+	n.Children = aux.Children
+	return nil
+}
+
+func TestSetRawFallback(t *testing.T) {
+	const array = `<plist version="1.0"><array><string>a</string></array></plist>`
+	const dict = `<plist version="1.0"><dict><key>Name</key><string>a</string></dict></plist>`
+	type named struct {
+		Name string
+	}
+	var raw RawValue
+	decode := func(doc string, v interface{}) error {
+		dec := NewXMLDecoder(strings.NewReader(doc))
+		dec.SetRawFallback(&raw)
+		return dec.Decode(v)
+	}
+
+	var v named
+	if err := decode(array, &v); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	if err := Unmarshal(raw, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("got %v from raw value %s", got, raw)
+	}
+
+	if err := decode(dict, &v); err != nil {
+		t.Fatal(err)
+	}
+	if raw != nil || v.Name != "a" {
+		t.Errorf("got raw %q and %+v, want no raw value and Name a", raw, v)
+	}
+
+	// Mismatches below the root are still errors.
+	var nested struct {
+		Name int
+	}
+	err := decode(dict, &nested)
+	if _, ok := err.(UnmarshalTypeError); !ok {
+		t.Errorf("got %v, want UnmarshalTypeError", err)
+	}
+
+	// Even when they read like the root value and its type.
+	const tree = `<plist version="1.0"><dict>
+	<key>Kind</key><string>node</string>
+	<key>Children</key><array><dict></dict></array>
+</dict></plist>`
+	var n fallbackNode
+	err = decode(tree, &n)
+	if te, ok := err.(UnmarshalTypeError); !ok || te.Value != "dict" || te.Type != reflect.TypeOf(n) {
+		t.Errorf("got %v, want UnmarshalTypeError for the child", err)
+	}
+
+	dec := NewXMLDecoder(strings.NewReader(`<plist version="1.0"><integer> 1 </integer></plist>`))
+	dec.SetRawFallback(&raw)
+	dec.SetStrict(true)
+	if err := dec.Decode(&v); err == nil {
+		t.Error("expected error in strict mode")
+	}
+}
+
+func TestUnwrapSingleton(t *testing.T) {
+	type item struct {
+		Name string
+	}
+	decode := func(doc string, unwrap bool) (item, error) {
+		var v item
+		dec := NewXMLDecoder(strings.NewReader(`<plist version="1.0">` + doc + `</plist>`))
+		dec.UnwrapSingleton(unwrap)
+		err := dec.Decode(&v)
+		return v, err
+	}
+
+	const single = `<array><dict><key>Name</key><string>a</string></dict></array>`
+	if _, err := decode(single, false); err == nil {
+		t.Error("expected error without UnwrapSingleton")
+	}
+	v, err := decode(single, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Name != "a" {
+		t.Errorf("got %+v, want Name a", v)
+	}
+
+	for _, doc := range []string{
+		`<array></array>`,
+		`<array><dict></dict><dict></dict></array>`,
+		`<array><string>a</string></array>`,
+	} {
+		if _, err := decode(doc, true); err == nil {
+			t.Errorf("%s: expected error", doc)
+		}
+	}
+
+	// Dicts still decode as usual, and other targets aren't unwrapped.
+	if v, err := decode(`<dict><key>Name</key><string>b</string></dict>`, true); err != nil || v.Name != "b" {
+		t.Errorf("got %+v, %v", v, err)
+	}
+	var items []item
+	dec := NewXMLDecoder(strings.NewReader(`<plist version="1.0">` + single + `</plist>`))
+	dec.UnwrapSingleton(true)
+	if err := dec.Decode(&items); err != nil || len(items) != 1 {
+		t.Errorf("got %+v, %v", items, err)
+	}
+}
+
+type benchProfile struct {
+	PayloadIdentifier   string
+	PayloadUUID         string
+	PayloadType         string
+	PayloadVersion      int
+	PayloadDisplayName  string `plist:",omitempty"`
+	PayloadRemovalLock  bool   `plist:"PayloadRemovalDisallowed"`
+	PayloadContent      []benchPayload
+	ConsentText         map[string]string
+	DurationUntilRemove float64 `plist:",omitempty"`
+}
+
+type benchPayload struct {
+	benchPayloadHeader
+	SSID      string `plist:"SSID_STR"`
+	Hidden    bool   `plist:"HIDDEN_NETWORK"`
+	Priority  *int
+	Created   time.Time
+	Domains   []string
+	AutoJoin  bool
+	ProxyPort uint16 `plist:",omitempty"`
+}
+
+type benchPayloadHeader struct {
+	PayloadIdentifier string
+	PayloadUUID       string
+	PayloadType       string
+	PayloadVersion    int
+}
+
+// benchProfileDoc returns a binary plist of a profile with n payloads.
+func benchProfileDoc(b *testing.B, n int) []byte {
+	priority := 10
+	p := benchProfile{
+		PayloadIdentifier: "com.example.profile",
+		PayloadUUID:       "9A4F1C2E-0000-4000-8000-000000000001",
+		PayloadType:       "Configuration",
+		PayloadVersion:    1,
+		ConsentText:       map[string]string{"default": "Install?", "de": "Installieren?"},
+	}
+	for i := 0; i < n; i++ {
+		p.PayloadContent = append(p.PayloadContent, benchPayload{
+			benchPayloadHeader: benchPayloadHeader{
+				PayloadIdentifier: "com.example.wifi." + strconv.Itoa(i),
+				PayloadUUID:       "9A4F1C2E-0000-4000-8000-" + strconv.Itoa(100000000000+i),
+				PayloadType:       "com.apple.wifi.managed",
+				PayloadVersion:    1,
+			},
+			SSID:     "network-" + strconv.Itoa(i),
+			Priority: &priority,
+			Created:  time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+			Domains:  []string{"example.com", "example.org"},
+			AutoJoin: true,
+		})
+	}
+	data, err := MarshalBinary(p)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return data
+}
+
+func BenchmarkDecodeStruct(b *testing.B) {
+	data := benchProfileDoc(b, 100)
+	b.Run("Unmarshal", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var p benchProfile
+			if err := Unmarshal(data, &p); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	// Parse once to measure only decoding the values into the struct.
+	b.Run("parsed", func(b *testing.B) {
+		pval, err := parseDocument(data, false)
+		if err != nil {
+			b.Fatal(err)
+		}
+		d := Decoder{isBinary: true}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			var p benchProfile
+			if err := d.decodeValue(pval, reflect.ValueOf(&p).Elem()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestKeyedBy(t *testing.T) {
+	type item struct {
+		ID    string `plist:"id"`
+		Value int    `plist:"value"`
+	}
+	type inventory struct {
+		Items map[string]item  `plist:"items,keyedBy=id"`
+		Last  map[string]*item `plist:"last,keyedBy=id,lastwins"`
+	}
+	const doc = `<plist version="1.0"><dict>
+	<key>items</key><array>
+		<dict><key>id</key><string>b</string><key>value</key><integer>2</integer></dict>
+		<dict><key>id</key><string>a</string><key>value</key><integer>1</integer></dict>
+	</array>
+	<key>last</key><array>
+		<dict><key>id</key><string>x</string><key>value</key><integer>1</integer></dict>
+		<dict><key>id</key><string>x</string><key>value</key><integer>2</integer></dict>
+	</array>
+</dict></plist>`
+	var v inventory
+	if err := Unmarshal([]byte(doc), &v); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]item{"a": {"a", 1}, "b": {"b", 2}}
+	if !reflect.DeepEqual(v.Items, want) {
+		t.Errorf("got %v, want %v", v.Items, want)
+	}
+	if len(v.Last) != 1 || v.Last["x"].Value != 2 {
+		t.Errorf("got %v, want the last element for x", v.Last)
+	}
+
+	// Encoding writes the elements in key order.
+	out, err := Marshal(inventory{Items: want})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<key>items</key><array>` +
+		`<dict><key>id</key><string>a</string><key>value</key><integer>1</integer></dict>` +
+		`<dict><key>id</key><string>b</string><key>value</key><integer>2</integer></dict>` +
+		`</array>`
+	if !strings.Contains(string(out), expected) {
+		t.Errorf("got\n%s\nwant\n%s", out, expected)
+	}
+
+	for _, elems := range []string{
+		`<dict><key>id</key><string>a</string></dict><dict><key>id</key><string>a</string></dict>`,
+		`<dict><key>value</key><integer>1</integer></dict>`,
+		`<dict><key>id</key><integer>1</integer></dict>`,
+		`<string>a</string>`,
+	} {
+		doc := `<plist version="1.0"><dict><key>items</key><array>` + elems + `</array></dict></plist>`
+		var v inventory
+		if err := Unmarshal([]byte(doc), &v); err == nil {
+			t.Errorf("%s: expected error, got %v", elems, v.Items)
+		}
+	}
+}
+
+func TestDecoderToken(t *testing.T) {
+	const doc = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>name</key><string>a &amp; b</string>
+	<key>count</key><integer>-3</integer>
+	<key>ratio</key><real>1.5e3</real>
+	<key>on</key><true/>
+	<key>when</key><date>2020-01-02T03:04:05Z</date>
+	<key>blob</key><data>aGVsbG8=</data>
+	<key>list</key><array><integer>1</integer><dict></dict></array>
+</dict>
+</plist>
+`
+	want := []Token{
+		{Kind: Dictionary},
+		{Kind: String, Key: "name", Raw: "a & b", value: "a & b"},
+		{Kind: Integer, Key: "count", Raw: "-3", value: int64(-3)},
+		{Kind: Real, Key: "ratio", Raw: "1.5e3", value: 1500.0},
+		{Kind: Boolean, Key: "on", Raw: "true", value: true},
+		{Kind: Date, Key: "when", Raw: "2020-01-02T03:04:05Z", value: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{Kind: Data, Key: "blob", Raw: "aGVsbG8=", value: []byte("hello")},
+		{Kind: Array, Key: "list"},
+		{Kind: Integer, Raw: "1", value: uint64(1)},
+		{Kind: Dictionary},
+		{Kind: Dictionary, End: true},
+		{Kind: Array, End: true},
+		{Kind: Dictionary, End: true},
+	}
+	dec := NewXMLDecoder(strings.NewReader(doc))
+	var got []Token
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, tok)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%+v\nwant\n%+v", got, want)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		t.Errorf("got %v after the root value, want io.EOF", err)
+	}
+	var v interface{}
+	if err := dec.Decode(&v); err == nil {
+		t.Error("expected error from Decode after Token")
+	}
+
+	for _, bad := range []string{
+		`<dict><key>a</key></dict>`,
+		`<dict><string>a</string></dict>`,
+		`<array><key>a</key></array>`,
+		`<array><integer>x</integer></array>`,
+		`<array><string>a</string>`,
+	} {
+		dec := NewXMLDecoder(strings.NewReader(`<plist version="1.0">` + bad))
+		var err error
+		for err == nil {
+			_, err = dec.Token()
+		}
+		if err == io.EOF {
+			t.Errorf("%s: expected error", bad)
+		}
+	}
+
+	if _, err := NewBinaryDecoder(bytes.NewReader(nil)).Token(); err == nil {
+		t.Error("expected error from Token on a binary decoder")
+	}
+}
+
+func TestDecodeNestedPlistData(t *testing.T) {
+	inner, err := Marshal("inner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	middle, err := MarshalBinary(map[string]interface{}{"inner": inner, "n": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := Marshal(map[string]interface{}{
+		"middle": middle,
+		"raw":    middle,
+		"other":  []byte("bplist00 but not really"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decode := func(depth int, v interface{}) error {
+		dec := NewXMLDecoder(bytes.NewReader(doc))
+		dec.DecodeNestedPlistData(depth)
+		return dec.Decode(v)
+	}
+
+	var v map[string]interface{}
+	if err := decode(0, &v); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := v["middle"].([]byte); !ok {
+		t.Errorf("got %T, want []byte by default", v["middle"])
+	}
+
+	v = nil
+	if err := decode(1, &v); err != nil {
+		t.Fatal(err)
+	}
+	m, ok := v["middle"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("got %T, want the nested dict", v["middle"])
+	}
+	if _, ok := m["inner"].([]byte); !ok {
+		t.Errorf("got %T below the depth limit, want []byte", m["inner"])
+	}
+	if _, ok := v["other"].([]byte); !ok {
+		t.Errorf("got %T for data that isn't a plist, want []byte", v["other"])
+	}
+
+	v = nil
+	if err := decode(2, &v); err != nil {
+		t.Fatal(err)
+	}
+	if got := v["middle"].(map[string]interface{})["inner"]; got != "inner" {
+		t.Errorf("got %v, want inner", got)
+	}
+
+	// Typed values are decoded from the nested plist, and []byte values keep
+	// the data.
+	var s struct {
+		Middle struct {
+			Inner string `plist:"inner"`
+			N     int    `plist:"n"`
+		} `plist:"middle"`
+		Raw []byte `plist:"raw"`
+	}
+	if err := decode(2, &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Middle.Inner != "inner" || s.Middle.N != 1 || !bytes.Equal(s.Raw, middle) {
+		t.Errorf("unexpected value %+v", s)
+	}
+
+	// 8-byte binary integers decode into unsigned values as their 64 bits,
+	// even when the binary plist is nested in an XML one.
+	wide, err := MarshalBinary(map[string]interface{}{"n": int64(-1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	outer, err := Marshal(map[string]interface{}{"wide": wide})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var u struct {
+		Wide struct {
+			N uint64 `plist:"n"`
+		} `plist:"wide"`
+	}
+	dec := NewXMLDecoder(bytes.NewReader(outer))
+	dec.DecodeNestedPlistData(1)
+	if err := dec.Decode(&u); err != nil {
+		t.Fatal(err)
+	}
+	if u.Wide.N != math.MaxUint64 {
+		t.Errorf("got %d, want %d", u.Wide.N, uint64(math.MaxUint64))
+	}
+}
+
+func TestTextValues(t *testing.T) {
+	type server struct {
+		URL     url.URL  `plist:"url"`
+		Backup  *url.URL `plist:"backup"`
+		Address net.IP   `plist:"address"`
+	}
+	const doc = `<plist version="1.0"><dict>
+	<key>url</key><string>https://mdm.example.com:8443/checkin?x=1</string>
+	<key>backup</key><string>https://backup.example.com/</string>
+	<key>address</key><string>192.0.2.1</string>
+</dict></plist>`
+	var s server
+	if err := Unmarshal([]byte(doc), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.URL.Host != "mdm.example.com:8443" || s.URL.Path != "/checkin" || s.URL.RawQuery != "x=1" {
+		t.Errorf("unexpected URL %#v", s.URL)
+	}
+	if s.Backup == nil || s.Backup.Host != "backup.example.com" {
+		t.Errorf("unexpected backup URL %v", s.Backup)
+	}
+	if !s.Address.Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Errorf("got address %v", s.Address)
+	}
+
+	out, err := Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<string>https://mdm.example.com:8443/checkin?x=1</string>",
+		"<string>https://backup.example.com/</string>",
+		"<data>AAAAAAAAAAAAAP//wAACAQ==</data>",
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("expected %s in\n%s", want, out)
+		}
+	}
+	if TypeOf(s.URL) != String || TypeOf(s.Address) != Data {
+		t.Error("unexpected TypeOf text values")
+	}
+
+	// net.IP is only encoded as text with UseTextMarshaler.
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf, WithTextMarshaler()).Encode(s); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "<string>192.0.2.1</string>") {
+		t.Errorf("expected the address as a string in\n%s", buf.String())
+	}
+
+	var bad server
+	doc2 := `<plist version="1.0"><dict><key>address</key><string>not an ip</string></dict></plist>`
+	if err := Unmarshal([]byte(doc2), &bad); err == nil {
+		t.Error("expected error for an invalid address")
+	}
+}
+
+func TestCoerceToString(t *testing.T) {
+	const doc = `<plist version="1.0"><dict>
+	<key>string</key><string>s</string>
+	<key>int</key><integer>-42</integer>
+	<key>uint</key><integer>18446744073709551615</integer>
+	<key>real</key><real>1.50</real>
+	<key>bool</key><true/>
+	<key>date</key><date>2020-01-02T03:04:05Z</date>
+	<key>data</key><data>aGVsbG8=</data>
+</dict></plist>`
+	want := map[string]string{
+		"string": "s",
+		"int":    "-42",
+		"uint":   "18446744073709551615",
+		"real":   "1.5",
+		"bool":   "true",
+		"date":   "2020-01-02T03:04:05Z",
+		"data":   "aGVsbG8=",
+	}
+	var got map[string]string
+	dec := NewXMLDecoder(strings.NewReader(doc))
+	dec.CoerceToString(true)
+	if err := dec.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if err := Unmarshal([]byte(doc), &got); err == nil {
+		t.Error("expected error without CoerceToString")
+	}
+
+	var s struct {
+		Date string `plist:"date"`
+	}
+	dec = NewXMLDecoder(strings.NewReader(doc))
+	dec.CoerceToString(true)
+	dec.SetTimeLocation(time.FixedZone("UTC+1", 3600))
+	if err := dec.Decode(&s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Date != "2020-01-02T04:04:05+01:00" {
+		t.Errorf("got %q", s.Date)
+	}
+
+	// Without SetTimeLocation, XML dates keep the offset they were written
+	// with.
+	dec = NewXMLDecoder(strings.NewReader(`<plist version="1.0"><dict><key>date</key><date>2020-01-02T03:04:05+02:00</date></dict></plist>`))
+	dec.CoerceToString(true)
+	if err := dec.Decode(&s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Date != "2020-01-02T03:04:05+02:00" {
+		t.Errorf("got %q", s.Date)
+	}
+
+	dec = NewXMLDecoder(strings.NewReader(`<plist version="1.0"><dict><key>a</key><array></array></dict></plist>`))
+	dec.CoerceToString(true)
+	if err := dec.Decode(&got); err == nil {
+		t.Error("expected error coercing an array")
+	}
+}
+
+func TestDecodeFormatField(t *testing.T) {
+	type settings struct {
+		Name   string
+		Format Format `plist:",format"`
+	}
+	type document struct {
+		Settings settings
+		Source   Format `plist:"source,format"`
+	}
+	in := document{Settings: settings{Name: "a"}}
+
+	for _, format := range []Format{FormatXML, FormatBinary} {
+		var data []byte
+		var err error
+		if format == FormatBinary {
+			data, err = MarshalBinary(in)
+		} else {
+			data, err = Marshal(in)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte("source")) || bytes.Contains(data, []byte("Format")) {
+			t.Errorf("%v: format fields were encoded: %q", format, data)
+		}
+
+		var got document
+		dec := NewDecoder(bytes.NewReader(data))
+		if format == FormatBinary {
+			dec = NewBinaryDecoder(bytes.NewReader(data))
+		}
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&got); err != nil {
+			t.Fatalf("%v: %v", format, err)
+		}
+		want := document{Settings: settings{Name: "a", Format: format}, Source: format}
+		if got != want {
+			t.Errorf("%v: got %+v, want %+v", format, got, want)
+		}
+		if dec.Format() != format {
+			t.Errorf("Decoder.Format: got %v, want %v", dec.Format(), format)
+		}
+	}
+
+	// A dict key matching the name of the field isn't decoded into it.
+	var got settings
+	doc := `<plist><dict><key>Format</key><integer>1</integer></dict></plist>`
+	if err := Unmarshal([]byte(doc), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Format != FormatXML {
+		t.Errorf("got format %v, want %v", got.Format, FormatXML)
+	}
+}
+
+func TestDecodeRootField(t *testing.T) {
+	type envelope struct {
+		Source  string      `plist:"source"`
+		Payload interface{} `plist:",root"`
+	}
+	tests := []struct {
+		doc  string
+		want interface{}
+	}{
+		{`<plist><dict><key>source</key><string>x</string></dict></plist>`, map[string]interface{}{"source": "x"}},
+		{`<plist><array><string>a</string><integer>1</integer></array></plist>`, []interface{}{"a", uint64(1)}},
+		{`<plist><string>hello</string></plist>`, "hello"},
+	}
+	for _, tt := range tests {
+		got := envelope{Source: "kept"}
+		if err := Unmarshal([]byte(tt.doc), &got); err != nil {
+			t.Fatalf("%s: %v", tt.doc, err)
+		}
+		if got.Source != "kept" {
+			t.Errorf("%s: Source was decoded: %q", tt.doc, got.Source)
+		}
+		if !reflect.DeepEqual(got.Payload, tt.want) {
+			t.Errorf("%s: got %#v, want %#v", tt.doc, got.Payload, tt.want)
+		}
+
+		// The root field is encoded in place of the struct.
+		data, err := MarshalBinary(got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var back interface{}
+		if err := Unmarshal(data, &back); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(back, tt.want) {
+			t.Errorf("%s: encoded %#v, want %#v", tt.doc, back, tt.want)
+		}
+	}
+
+	// Root fields of a concrete type are decoded like any other value, and
+	// work when the struct is nested.
+	type typed struct {
+		Names []string `plist:",root"`
+	}
+	var nested map[string]typed
+	doc := `<plist><dict><key>a</key><array><string>x</string><string>y</string></array></dict></plist>`
+	if err := Unmarshal([]byte(doc), &nested); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"x", "y"}; !reflect.DeepEqual(nested["a"].Names, want) {
+		t.Errorf("got %v, want %v", nested["a"].Names, want)
+	}
+	var wrong typed
+	if err := Unmarshal([]byte(`<plist><string>x</string></plist>`), &wrong); err == nil {
+		t.Error("expected error decoding a string into a []string root field")
+	}
+	if _, err := Marshal(envelope{}); err == nil {
+		t.Error("expected error encoding a nil root field")
+	}
+
+	// A root field needs every key, so StructKeys doesn't limit them and
+	// none of them are unknown.
+	if keys := StructKeys(&envelope{}); keys != nil {
+		t.Errorf("StructKeys: got %q, want nil", keys)
+	}
+	var env envelope
+	dec := NewXMLDecoder(strings.NewReader(tests[0].doc), WithDisallowUnknownFields())
+	dec.OnlyKeys(StructKeys(&env)...)
+	if err := dec.Decode(&env); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(env.Payload, tests[0].want) {
+		t.Errorf("OnlyKeys: got %#v, want %#v", env.Payload, tests[0].want)
+	}
+}
+
+func TestDecoderOptions(t *testing.T) {
+	doc := `<plist><dict><key>Name</key><string>  a  </string><key>Other</key><true/></dict></plist>`
+	var got struct{ Name string }
+	dec := NewXMLDecoder(strings.NewReader(doc), WithTrimStringSpace(), WithDisallowUnknownFields())
+	if err := dec.Decode(&got); err == nil {
+		t.Error("expected error for the unknown key Other")
+	}
+
+	got.Name = ""
+	dec = NewXMLDecoder(strings.NewReader(doc), WithTrimStringSpace(), WithOnlyKeys("Name"))
+	if err := dec.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "a" {
+		t.Errorf("got %q, want %q", got.Name, "a")
+	}
+}
+
+func TestAutoDecompress(t *testing.T) {
+	want := map[string]interface{}{"a": "b"}
+	xmlDoc, err := Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binaryDoc, err := MarshalBinary(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzipped := func(data []byte) []byte {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		zw.Write(data)
+		zw.Close()
+		return buf.Bytes()
+	}
+	// <plist><dict><key>a</key><string>b</string></dict></plist>, compressed
+	// with bzip2.
+	bzipped := []byte{
+		0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x63, 0x4b, 0x63, 0x99, 0x00, 0x00,
+		0x05, 0x19, 0x80, 0x00, 0x00, 0x80, 0x05, 0x3e, 0xad, 0x5c, 0x20, 0x20, 0x00, 0x50, 0xa6, 0x99,
+		0x18, 0x98, 0x98, 0x82, 0x55, 0x3f, 0x45, 0x32, 0x7a, 0x87, 0xa4, 0xd9, 0x44, 0x0c, 0xeb, 0x94,
+		0x11, 0x41, 0x63, 0x39, 0xe9, 0x11, 0x27, 0xc4, 0x94, 0x6c, 0xce, 0x68, 0x81, 0x9e, 0x47, 0x19,
+		0xf7, 0x69, 0x71, 0x67, 0xe2, 0xee, 0x48, 0xa7, 0x0a, 0x12, 0x0c, 0x69, 0x6c, 0x73, 0x20,
+	}
+
+	tests := []struct {
+		name   string
+		in     []byte
+		binary bool // read with NewBinaryDecoder
+		format Format
+	}{
+		{"gzip xml", gzipped(xmlDoc), false, FormatXML},
+		{"gzip binary", gzipped(binaryDoc), false, FormatBinary},
+		{"gzip xml with binary decoder", gzipped(xmlDoc), true, FormatXML},
+		{"gzip binary with binary decoder", gzipped(binaryDoc), true, FormatBinary},
+		{"bzip2 xml", bzipped, false, FormatXML},
+		{"uncompressed xml", xmlDoc, false, FormatXML},
+		{"uncompressed binary", binaryDoc, true, FormatBinary},
+	}
+	for _, tt := range tests {
+		dec := NewXMLDecoder(bytes.NewReader(tt.in))
+		if tt.binary {
+			dec = NewBinaryDecoder(bytes.NewReader(tt.in))
+		}
+		dec.AutoDecompress(true)
+		kind, err := dec.PeekType()
+		if err != nil || kind != Dictionary {
+			t.Errorf("%s: PeekType: got %v, %v, want %v", tt.name, kind, err, Dictionary)
+		}
+		var got map[string]interface{}
+		if err := dec.Decode(&got); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, want)
+		}
+		if dec.Format() != tt.format {
+			t.Errorf("%s: got format %v, want %v", tt.name, dec.Format(), tt.format)
+		}
+	}
+
+	// Compressed input is only decompressed if asked to.
+	var got map[string]interface{}
+	if err := NewXMLDecoder(bytes.NewReader(gzipped(xmlDoc))).Decode(&got); err == nil {
+		t.Error("decoded gzip data without AutoDecompress")
+	}
+}
+
+func TestDecodeArrayTo(t *testing.T) {
+	want := []interface{}{
+		map[string]interface{}{"id": "a", "n": uint64(1)},
+		"b",
+		[]interface{}{true},
+	}
+	xmlDoc, err := Marshal(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binaryDoc, err := MarshalBinary(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	receive := func(in []byte, opts ...DecoderOption) ([]interface{}, error) {
+		ch := make(chan interface{})
+		errc := make(chan error, 1)
+		go func() { errc <- DecodeArrayTo(bytes.NewReader(in), ch, opts...) }()
+		var got []interface{}
+		for v := range ch {
+			got = append(got, v)
+		}
+		return got, <-errc
+	}
+
+	for _, in := range [][]byte{xmlDoc, binaryDoc} {
+		got, err := receive(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+
+	// The elements before an error are sent.
+	got, err := receive([]byte(`<plist><array><string>a</string><integer>x</integer></array></plist>`))
+	if err == nil {
+		t.Error("expected an error for an invalid integer")
+	}
+	if !reflect.DeepEqual(got, []interface{}{"a"}) {
+		t.Errorf("got %v before the error, want [a]", got)
+	}
+
+	got, err = receive([]byte(`<plist><array><string>a</string>`))
+	if err == nil || len(got) != 1 {
+		t.Errorf("truncated array: got %v, %v, want one element and an error", got, err)
+	}
+
+	var typeErr UnmarshalTypeError
+	if _, err := receive([]byte(`<plist><dict/></plist>`)); !errors.As(err, &typeErr) {
+		t.Errorf("dict root: got error %v, want an UnmarshalTypeError", err)
+	}
+	binaryDict, err := MarshalBinary(map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := receive(binaryDict); !errors.As(err, &typeErr) {
+		t.Errorf("binary dict root: got error %v, want an UnmarshalTypeError", err)
+	}
+
+	// Both formats are decoded with the options.
+	loc := time.FixedZone("UTC+1", 3600)
+	dates := []interface{}{time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	xmlDates, err := Marshal(dates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binaryDates, err := MarshalBinary(dates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, in := range [][]byte{xmlDates, binaryDates} {
+		got, err := receive(in, WithTimeLocation(loc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if date, ok := got[0].(time.Time); !ok || date.Location() != loc {
+			t.Errorf("got %v, want a date in %v", got[0], loc)
+		}
+	}
+	for _, in := range [][]byte{xmlDoc, binaryDoc} {
+		if _, err := receive(in, WithMaxElements(2)); err == nil {
+			t.Error("expected an error with WithMaxElements(2)")
+		}
+	}
+}
+
+func TestOnlyKeys(t *testing.T) {
+	type profile struct {
+		Name    string `plist:"PayloadName"`
+		Version int    `plist:"PayloadVersion"`
+		Org     string `plist:"Meta.Organization,path"`
+	}
+	if got, want := StructKeys(&profile{}), []string{"Meta", "PayloadName", "PayloadVersion"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("StructKeys: got %q, want %q", got, want)
+	}
+	if got := StructKeys(map[string]interface{}{}); got != nil {
+		t.Errorf("StructKeys of a map: got %q, want nil", got)
+	}
+
+	big := make([]interface{}, 1000)
+	for i := range big {
+		big[i] = i
+	}
+	in := map[string]interface{}{
+		"PayloadName":    "Wi-Fi",
+		"PayloadVersion": 1,
+		"Meta":           map[string]interface{}{"Organization": "Example", "Other": big},
+		"PayloadContent": big,
+	}
+	xmlDoc, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binaryDoc, err := MarshalBinary(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := profile{Name: "Wi-Fi", Version: 1, Org: "Example"}
+
+	for _, doc := range [][]byte{xmlDoc, binaryDoc} {
+		var got profile
+		dec := NewXMLDecoder(bytes.NewReader(doc))
+		if bytes.HasPrefix(doc, binaryMagic) {
+			dec = NewBinaryDecoder(bytes.NewReader(doc))
+		}
+		// The skipped array is larger than the limit, so decoding fails
+		// unless it is skipped. Values within kept keys are all parsed.
+		dec.SetMaxElements(1500)
+		dec.DisallowUnknownFields()
+		dec.OnlyKeys(StructKeys(&got)...)
+		if err := dec.Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+
+	// Values of skipped keys aren't checked, and the filter is only applied
+	// to the root dict.
+	doc := `<plist><dict>
+		<key>a</key><dict><key>b</key><string>c</string><key>d</key><string>e</string></dict>
+		<key>x</key><integer>not a number</integer>
+	</dict></plist>`
+	var got interface{}
+	dec := NewXMLDecoder(strings.NewReader(doc))
+	dec.OnlyKeys("a", "b")
+	if err := dec.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	wantTree := map[string]interface{}{"a": map[string]interface{}{"b": "c", "d": "e"}}
+	if !reflect.DeepEqual(got, wantTree) {
+		t.Errorf("got %v, want %v", got, wantTree)
+	}
+}
+
+func TestTrimStringSpace(t *testing.T) {
+	const doc = `<plist><dict>
+		<key> padded key </key><string>
+			value
+		</string>
+		<key>list</key><array><string>  a  </string><string>b</string></array>
+		<key>data</key><data> AQI= </data>
+	</dict></plist>`
+
+	var got map[string]interface{}
+	dec := NewXMLDecoder(strings.NewReader(doc))
+	dec.TrimStringSpace(true)
+	if err := dec.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		" padded key ": "value",
+		"list":         []interface{}{"a", "b"},
+		"data":         []byte{1, 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Strings are kept as they are by default.
+	var s struct {
+		List []string `plist:"list"`
+	}
+	if err := Unmarshal([]byte(doc), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s.List[0] != "  a  " {
+		t.Errorf("got %q, want the padded string", s.List[0])
+	}
+}
+
+func TestSetRealParser(t *testing.T) {
+	commaReal := func(s string) (float64, error) {
+		return strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+	}
+	doc := `<plist><array><real> 1,5 </real><real>2.25</real></array></plist>`
+
+	var got []float64
+	if err := Unmarshal([]byte(doc), &got); err == nil {
+		t.Error("expected error without a real parser")
+	}
+	dec := NewXMLDecoder(strings.NewReader(doc))
+	dec.SetRealParser(commaReal)
+	if err := dec.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if want := []float64{1.5, 2.25}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	dec = NewXMLDecoder(strings.NewReader(`<plist><real>1,5</real></plist>`))
+	dec.SetRealParser(commaReal)
+	dec.SetStrict(true)
+	var f float64
+	if err := dec.Decode(&f); err == nil {
+		t.Error("expected error in strict mode")
+	}
+	dec = NewXMLDecoder(strings.NewReader(`<plist><real>one</real></plist>`))
+	dec.SetRealParser(commaReal)
+	if err := dec.Decode(&f); err == nil {
+		t.Error("expected error from the real parser")
+	}
+}
+
+func TestDecodeInvalidUTF8(t *testing.T) {
+	// A binary ASCII string holding an invalid UTF-8 byte.
+	ascii, err := MarshalBinary("abXcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ascii = bytes.Replace(ascii, []byte("abXcd"), []byte("ab\xffcd"), 1)
+	// A binary UTF-16 string with an unpaired high surrogate in place of A.
+	utf16Doc, err := MarshalBinary("éA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	utf16Doc = bytes.Replace(utf16Doc, []byte{0x00, 0xe9, 0x00, 0x41}, []byte{0x00, 0xe9, 0xd8, 0x00}, 1)
+
+	tests := []struct {
+		in               []byte
+		keep, replaced   string
+		offset, position int64 // of the string's contents, and of the invalid sequence within it
+	}{
+		{ascii, "ab\xffcd", "ab\uFFFDcd", 9, 2},
+		{utf16Doc, "é\uFFFD", "é\uFFFD", 9, 2},
+	}
+	for _, tt := range tests {
+		decode := func(strict, replace bool) (string, error) {
+			var s string
+			dec := NewBinaryDecoder(bytes.NewReader(tt.in))
+			dec.SetStrict(strict)
+			dec.ReplaceInvalidUTF8(replace)
+			err := dec.Decode(&s)
+			return s, err
+		}
+		if s, err := decode(false, false); err != nil || s != tt.keep {
+			t.Errorf("default: got %q, %v, want %q", s, err, tt.keep)
+		}
+		if s, err := decode(false, true); err != nil || s != tt.replaced {
+			t.Errorf("ReplaceInvalidUTF8: got %q, %v, want %q", s, err, tt.replaced)
+		}
+		_, err := decode(true, true)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("SetStrict: got error %v, want a *SyntaxError", err)
+		} else if syntaxErr.Offset != tt.offset+tt.position {
+			t.Errorf("SetStrict: got offset %d, want %d", syntaxErr.Offset, tt.offset+tt.position)
+		}
+	}
+
+	// XML plists with invalid UTF-8 are always rejected.
+	var s string
+	if err := Unmarshal([]byte("<plist><string>ab\xffcd</string></plist>"), &s); err == nil {
+		t.Error("expected an error for invalid UTF-8 in an XML string")
+	}
+}
+
+func TestBigFloat(t *testing.T) {
+	const pi = "3.1415926535897932384626433832795028841971693993751"
+	doc := `<plist><dict>
+		<key>pi</key><real>` + pi + `</real>
+		<key>tiny</key><real>1.000000000000000000000000000001e-300</real>
+		<key>count</key><integer>12345678901234567890</integer>
+	</dict></plist>`
+
+	var v struct {
+		Pi    *big.Float `plist:"pi"`
+		Tiny  big.Float  `plist:"tiny"`
+		Count big.Float  `plist:"count"`
+	}
+	if err := Unmarshal([]byte(doc), &v); err != nil {
+		t.Fatal(err)
+	}
+	if got := v.Pi.Text('g', -1); got != pi {
+		t.Errorf("got pi %s, want %s", got, pi)
+	}
+	if got, want := v.Tiny.Text('g', -1), "1.000000000000000000000000000001e-300"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if got, want := v.Count.Text('f', 0), "12345678901234567890"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// Encoding keeps every digit, and decoding it again gives the same
+	// value.
+	data, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte("<real>"+pi+"</real>")) {
+		t.Errorf("expected the full literal of pi in %s", data)
+	}
+	var again struct {
+		Pi *big.Float `plist:"pi"`
+	}
+	if err := Unmarshal(data, &again); err != nil {
+		t.Fatal(err)
+	}
+	if got := again.Pi.Text('g', -1); got != pi {
+		t.Errorf("got %s after a round trip, want %s", got, pi)
+	}
+
+	// A precision set on the float is kept.
+	low := new(big.Float).SetPrec(24)
+	if err := Unmarshal([]byte("<plist><real>"+pi+"</real></plist>"), low); err != nil {
+		t.Fatal(err)
+	}
+	if low.Prec() != 24 || low.Text('g', -1) != "3.1415927" {
+		t.Errorf("got %s with precision %d, want 3.1415927 with 24", low.Text('g', -1), low.Prec())
+	}
+
+	// An empty interface still gets a float64.
+	var iface interface{}
+	if err := Unmarshal([]byte("<plist><real>"+pi+"</real></plist>"), &iface); err != nil {
+		t.Fatal(err)
+	}
+	if iface != math.Pi {
+		t.Errorf("got %v (%T), want the float64 %v", iface, iface, math.Pi)
+	}
+	if kind := TypeOf(v.Pi); kind != Real {
+		t.Errorf("TypeOf: got %v, want %v", kind, Real)
+	}
+}
+
+func TestUnmarshalArchive(t *testing.T) {
+	class := map[string]interface{}{"$classname": "Node", "$classes": []interface{}{"Node", "NSObject"}}
+	archive := func(objects ...interface{}) []byte {
+		data, err := MarshalBinary(map[string]interface{}{
+			"$archiver": "NSKeyedArchiver",
+			"$version":  100000,
+			"$top":      map[string]interface{}{"root": UID(1)},
+			"$objects":  append([]interface{}{"$null"}, objects...),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	same := func(a, b interface{}) bool {
+		return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
+	}
+
+	// A node that refers to itself, and to a list holding it.
+	got, err := UnmarshalArchive(archive(
+		map[string]interface{}{"self": UID(1), "name": UID(2), "list": UID(3), "parent": UID(0), "$class": UID(4)},
+		"node",
+		[]interface{}{UID(1), UID(2)},
+		class,
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Cyclic {
+		t.Error("expected a cyclic archive")
+	}
+	root, ok := got.Top["root"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("got root %T, want a map", got.Top["root"])
+	}
+	if !same(root["self"], root) {
+		t.Error("self doesn't refer to the root object")
+	}
+	list := root["list"].([]interface{})
+	if !same(list[0], root) || list[1] != "node" {
+		t.Errorf("got list %v", list)
+	}
+	if root["name"] != "node" || root["parent"] != nil {
+		t.Errorf("got name %v and parent %v, want node and nil", root["name"], root["parent"])
+	}
+	if !reflect.DeepEqual(root["$class"], class) {
+		t.Errorf("got class %v, want %v", root["$class"], class)
+	}
+
+	// An object shared by two others isn't a cycle.
+	got, err = UnmarshalArchive(archive(
+		map[string]interface{}{"a": UID(2), "b": UID(2)},
+		map[string]interface{}{"value": 1},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root = got.Top["root"].(map[string]interface{})
+	if got.Cyclic || !same(root["a"], root["b"]) {
+		t.Errorf("got cyclic %v and a %p, b %p, want an acyclic shared object", got.Cyclic, root["a"], root["b"])
+	}
+
+	if _, err := UnmarshalArchive(archive(map[string]interface{}{"a": UID(5)})); err == nil {
+		t.Error("expected an error for a reference past the objects")
+	}
+	data, _ := MarshalBinary(map[string]interface{}{"a": "b"})
+	if _, err := UnmarshalArchive(data); err == nil {
+		t.Error("expected an error for a plist that isn't an archive")
+	}
+}
@@ -0,0 +1,54 @@
+package plist
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var defaultTypes = struct {
+	sync.RWMutex
+	m map[reflect.Type]reflect.Type
+}{m: make(map[reflect.Type]reflect.Type)}
+
+// RegisterDefault records the type of proto as the Go type of values decoded
+// into the interface type iface, for interfaces with a single implementation
+// that need no discriminator. A new value of that type is decoded and stored
+// in the interface, instead of the decoding failing. If proto is a pointer,
+// the stored value is a pointer too.
+//
+// A ConstructorFunc set with Decoder.SetConstructor is consulted first, and
+// then a type registered for the PayloadType of a dictionary, if it
+// implements iface. The default is used when neither chooses a type.
+//
+// RegisterDefault panics if iface isn't an interface type, if proto is nil or
+// doesn't implement iface, or if iface is registered twice. It is meant to be
+// called from init functions, like:
+//
+//	RegisterDefault(reflect.TypeOf((*Payload)(nil)).Elem(), &GenericPayload{})
+func RegisterDefault(iface reflect.Type, proto interface{}) {
+	if iface == nil || iface.Kind() != reflect.Interface {
+		panic(fmt.Sprintf("plist: RegisterDefault of non-interface type %v", iface))
+	}
+	t := reflect.TypeOf(proto)
+	if t == nil {
+		panic("plist: RegisterDefault of nil value for " + iface.String())
+	}
+	if !t.Implements(iface) {
+		panic(fmt.Sprintf("plist: RegisterDefault of %v, which doesn't implement %v", t, iface))
+	}
+	defaultTypes.Lock()
+	defer defaultTypes.Unlock()
+	if prev, ok := defaultTypes.m[iface]; ok {
+		panic(fmt.Sprintf("plist: RegisterDefault called twice for %v (%v and %v)", iface, prev, t))
+	}
+	defaultTypes.m[iface] = t
+}
+
+// defaultType returns the type registered as the default of the interface
+// type iface, or nil.
+func defaultType(iface reflect.Type) reflect.Type {
+	defaultTypes.RLock()
+	defer defaultTypes.RUnlock()
+	return defaultTypes.m[iface]
+}
@@ -0,0 +1,113 @@
+package plist
+
+import "reflect"
+
+// A Dict is a plist dictionary that keeps the order of its keys, for editing
+// a document without reordering it. The zero value is an empty Dict ready to
+// use.
+//
+// Decoding into a Dict stores the keys in the order they appear in the
+// document and replaces any previous contents. Values are decoded as for an
+// empty interface, except that dictionaries nested in them are decoded as
+// *Dict as well. Encoding a Dict or *Dict writes its keys in order.
+type Dict struct {
+	keys []string
+	m    map[string]interface{}
+}
+
+var dictType = reflect.TypeOf(Dict{})
+
+// Len returns the number of keys in d.
+func (d *Dict) Len() int {
+	return len(d.keys)
+}
+
+// Keys returns the keys of d in order.
+func (d *Dict) Keys() []string {
+	return append([]string(nil), d.keys...)
+}
+
+// Get returns the value stored under key, and whether there is one.
+func (d *Dict) Get(key string) (interface{}, bool) {
+	v, ok := d.m[key]
+	return v, ok
+}
+
+// Set stores value under key. A new key is added after the existing ones; an
+// existing key keeps its position.
+func (d *Dict) Set(key string, value interface{}) {
+	if d.m == nil {
+		d.m = make(map[string]interface{})
+	}
+	if _, ok := d.m[key]; !ok {
+		d.keys = append(d.keys, key)
+	}
+	d.m[key] = value
+}
+
+// Delete removes key and its value from d. The other keys keep their order.
+func (d *Dict) Delete(key string) {
+	if _, ok := d.m[key]; !ok {
+		return
+	}
+	delete(d.m, key)
+	for i, k := range d.keys {
+		if k == key {
+			d.keys = append(d.keys[:i], d.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// marshalDict encodes d as a dictionary written in the order of its keys.
+// Nil values are left out, like nil pointers in maps.
+func (e *Encoder) marshalDict(d *Dict) (*plistValue, error) {
+	dict := &dictionary{m: make(map[string]*plistValue, len(d.keys)), ordered: !e.sortKeys}
+	for _, k := range d.keys {
+		e.enter(k)
+		value, err := e.marshal(reflect.ValueOf(d.m[k]))
+		e.leave()
+		if err != nil {
+			return nil, inPath(err, k)
+		}
+		if value != nil {
+			dict.set(k, value)
+		}
+	}
+	return &plistValue{Dictionary, dict}, nil
+}
+
+// unmarshalDict decodes the dictionary pval into the Dict v.
+func (d *Decoder) unmarshalDict(pval *plistValue, v reflect.Value) error {
+	if pval.kind != Dictionary {
+		return UnmarshalTypeError{describe(pval), v.Type()}
+	}
+	val, err := d.orderedDict(pval.value.(*dictionary))
+	if err != nil {
+		return err
+	}
+	v.Set(reflect.ValueOf(*val))
+	return nil
+}
+
+// orderedDict returns dict as a *Dict, with nested dictionaries decoded as
+// *Dict too.
+func (d *Decoder) orderedDict(dict *dictionary) (*Dict, error) {
+	ordered := d.orderedDicts
+	d.orderedDicts = true
+	defer func() { d.orderedDicts = ordered }()
+
+	d.checkKeys(dict)
+	out := &Dict{keys: make([]string, 0, len(dict.m)), m: make(map[string]interface{}, len(dict.m))}
+	for _, k := range dict.keyOrder() {
+		d.enter(k)
+		val, err := d.valueInterface(dict.m[k])
+		err = d.collect(err)
+		d.leave()
+		if err != nil {
+			return nil, err
+		}
+		out.Set(k, val)
+	}
+	return out, nil
+}
@@ -0,0 +1,100 @@
+package plist
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDictOrder(t *testing.T) {
+	const doc = `<plist version="1.0"><dict>
+	<key>zebra</key><integer>1</integer>
+	<key>apple</key><dict>
+		<key>y</key><string>1</string>
+		<key>x</key><string>2</string>
+	</dict>
+	<key>mango</key><array><dict><key>b</key><true/><key>a</key><false/></dict></array>
+</dict></plist>`
+
+	var d Dict
+	if err := Unmarshal([]byte(doc), &d); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := d.Keys(), []string{"zebra", "apple", "mango"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got keys %v, want %v", got, want)
+	}
+	nested, _ := d.Get("apple")
+	if got, want := nested.(*Dict).Keys(), []string{"y", "x"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got nested keys %v, want %v", got, want)
+	}
+
+	d.Set("zebra", 2)    // keeps its position
+	d.Set("banana", "b") // appended
+	d.Delete("mango")
+	d.Delete("missing")
+	if got, want := d.Keys(), []string{"zebra", "apple", "banana"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got keys %v, want %v", got, want)
+	}
+	if v, ok := d.Get("mango"); ok {
+		t.Errorf("got deleted value %v", v)
+	}
+	if d.Len() != 3 {
+		t.Errorf("got length %d, want 3", d.Len())
+	}
+
+	out, err := Marshal(&d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<plist version="1.0"><dict>` +
+		`<key>zebra</key><integer>2</integer>` +
+		`<key>apple</key><dict><key>y</key><string>1</string><key>x</key><string>2</string></dict>` +
+		`<key>banana</key><string>b</string>` +
+		`</dict></plist>`
+	if !bytes.Contains(out, []byte(want)) {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+
+	// Order is kept through binary plists, and in dicts nested in arrays.
+	var a Dict
+	if err := Unmarshal([]byte(doc), &a); err != nil {
+		t.Fatal(err)
+	}
+	bin, err := MarshalBinary(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b Dict
+	if err := Unmarshal(bin, &b); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := b.Keys(), []string{"zebra", "apple", "mango"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got keys %v after binary round trip, want %v", got, want)
+	}
+	arr, _ := b.Get("mango")
+	if got, want := arr.([]interface{})[0].(*Dict).Keys(), []string{"b", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got keys %v in array, want %v", got, want)
+	}
+
+	if TypeOf(&d) != Dictionary || TypeOf(d) != Dictionary {
+		t.Errorf("TypeOf Dict is not Dictionary")
+	}
+}
+
+func TestDictZeroValue(t *testing.T) {
+	var d Dict
+	d.Set("b", 1)
+	d.Set("a", nil)
+	out, err := Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "<dict><key>b</key><integer>1</integer></dict>") {
+		t.Errorf("unexpected encoding %s", out)
+	}
+
+	if err := Unmarshal([]byte(`<plist version="1.0"><array></array></plist>`), &d); err == nil {
+		t.Error("expected error decoding an array into a Dict")
+	}
+}
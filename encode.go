@@ -2,8 +2,11 @@ package plist
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"reflect"
+	"strconv"
 	"time"
 )
 
@@ -13,9 +16,37 @@ type Marshaler interface {
 
 // Encoder ...
 type Encoder struct {
-	w io.Writer
+	w        io.Writer
+	isBinary bool // true if this encoder writes binary plists
+
+	indent           string
+	selfClosingEmpty bool
+	inlineKeys       bool
+	fieldOrder       bool
+	sortKeys         bool
+	allowEmpty       bool
+	boolAsInteger    bool
+	useStringer      bool
+	useTextMarshaler bool
+	noFinalNewline   bool
+	lineEnding       string
+	bom              bool
+	fieldComments    bool
+	minIntWidth      uint8
+	maxBytes         int64
+	validate         bool
+	dataFormat       DataFormat
+	subsecondDates   bool
+
+	transform func(path []string, v interface{}) interface{} // see transform.go
+	path      []string                                       // key path of the value being encoded, kept for transform
 
-	indent string
+	ptrLevel uint
+	ptrSeen  map[cycleKey]struct{}
+
+	// stream holds the state of a document written with BeginDict,
+	// WriteKey and the other incremental methods.
+	stream *encodeStream
 }
 
 // Marshal ...
@@ -38,21 +69,164 @@ func MarshalIndent(v interface{}, indent string) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// NewEncoder returns a new encoder that writes to w.
-func NewEncoder(w io.Writer) *Encoder {
-	return &Encoder{w: w}
+// MarshalBinary returns the binary plist encoding of v.
+//
+// The encoding is a pure function of v, so that equal values encode to the
+// same bytes and binary plists can be compared or hashed: the keys of maps
+// are sorted, objects are numbered in the order they are first reached by a
+// depth-first walk from the root, visiting the keys of a dict before its
+// values, and scalars that encode the same way share a single object. The
+// keys of a Dict, and of structs with PreserveFieldOrder, are in the order
+// they hold instead, unless keys are sorted with SortKeys.
+func MarshalBinary(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := NewBinaryEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// EncodedLen returns the length of the encoding of v in the given format, as
+// written by Marshal or MarshalBinary, without keeping the encoded bytes in
+// memory. The length is exact for both formats.
+func EncodedLen(v interface{}, format Format) (int, error) {
+	var w countingWriter
+	var enc *Encoder
+	switch format {
+	case FormatXML:
+		enc = NewEncoder(&w)
+	case FormatBinary:
+		enc = NewBinaryEncoder(&w)
+	default:
+		return 0, fmt.Errorf("plist: unknown format %v", format)
+	}
+	if err := enc.Encode(v); err != nil {
+		return 0, err
+	}
+	return int(w), nil
 }
 
-// Encode ...
+// countingWriter discards what is written to it, counting the bytes.
+type countingWriter int64
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	*w += countingWriter(len(p))
+	return len(p), nil
+}
+
+// ErrMaxBytes is returned by Encode when the document is larger than the
+// limit set with SetMaxBytes.
+var ErrMaxBytes = errors.New("plist: encoded document exceeds the maximum size")
+
+// limitWriter writes to w until n bytes have been written, and then fails.
+type limitWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) <= w.n {
+		n, err := w.w.Write(p)
+		w.n -= int64(n)
+		return n, err
+	}
+	n, err := w.w.Write(p[:w.n])
+	w.n -= int64(n)
+	if err == nil {
+		err = ErrMaxBytes
+	}
+	return n, err
+}
+
+// NewEncoder returns a new encoder that writes XML plists to w, unless
+// WithFormat is one of opts.
+func NewEncoder(w io.Writer, opts ...EncoderOption) *Encoder {
+	e := &Encoder{w: w}
+	return e.apply(opts)
+}
+
+// NewBinaryEncoder returns a new encoder that writes binary plists to w.
+// Options that only affect XML output, like Indent, are ignored.
+func NewBinaryEncoder(w io.Writer, opts ...EncoderOption) *Encoder {
+	e := &Encoder{w: w, isBinary: true}
+	return e.apply(opts)
+}
+
+// Encode writes the plist encoding of v to the underlying writer.
+//
+// The value is converted in full before anything is written, so if v can't be
+// encoded, Encode returns an error without writing. Otherwise the document is
+// written through a buffer which is flushed before Encode returns, so when
+// Encode returns nil every byte of the document has been passed to the
+// writer. The first error returned by the writer stops the encoding and is
+// returned by Encode, in which case only part of the document may have been
+// written.
+//
+// Plists have no null value, so nil pointers and interfaces are left out of
+// dictionaries, as if tagged with omitempty. They can't be encoded as array
+// elements or as the top level value.
+//
+// Nil maps and slices are encoded like empty ones, as an empty <dict>,
+// <array> or <data>, except in struct fields tagged with omitempty, which are
+// left out when they hold a nil map or slice. Unlike with encoding/json, an
+// empty map or slice that isn't nil is still encoded as an empty container in
+// such a field, so that fields whose presence matters can use nil for absent:
+//
+//	nil, omitempty         left out
+//	nil, no omitempty      empty container
+//	empty, omitempty       empty container
+//	empty, no omitempty    empty container
 func (e *Encoder) Encode(v interface{}) error {
+	if e.stream != nil {
+		return errUnfinishedStream
+	}
+	e.path = e.path[:0]
 	pval, err := e.marshal(reflect.ValueOf(v))
 	if err != nil {
 		return err
 	}
+	if pval == nil {
+		if e.allowEmpty && !e.isBinary {
+			enc := e.newXMLEncoder()
+			if err := enc.writeHeader(); err != nil {
+				return err
+			}
+			return enc.writeFooter()
+		}
+		return &UnsupportedValueError{reflect.ValueOf(v), "nil"}
+	}
+
+	if e.isBinary {
+		enc := newBinaryEncoder(e.output())
+		enc.minIntSize = e.minIntWidth
+		return enc.generateDocument(pval)
+	}
+	return e.newXMLEncoder().generateDocument(pval)
+}
 
-	enc := newXMLEncoder(e.w)
-	enc.Indent("", e.indent)
-	return enc.generateDocument(pval)
+// output returns the writer a document is written to, limited to the size set
+// with SetMaxBytes.
+func (e *Encoder) output() io.Writer {
+	if e.maxBytes > 0 {
+		return &limitWriter{e.w, e.maxBytes}
+	}
+	return e.w
+}
+
+// newXMLEncoder returns an xmlEncoder for w configured with the options of e.
+func (e *Encoder) newXMLEncoder() *xmlEncoder {
+	enc := newXMLEncoder(e.output())
+	enc.Indent(e.indent)
+	enc.selfClosingEmpty = e.selfClosingEmpty
+	enc.noFinalNewline = e.noFinalNewline
+	enc.inlineKeys = e.inlineKeys
+	enc.bom = e.bom
+	enc.dataFormat = e.dataFormat
+	enc.subsecondDates = e.subsecondDates
+	if e.lineEnding != "" {
+		enc.newline = e.lineEnding
+	}
+	return enc
 }
 
 // Indent ...
@@ -60,7 +234,176 @@ func (e *Encoder) Indent(indent string) {
 	e.indent = indent
 }
 
+// SortKeys sets whether the keys of every dict are written in sorted order,
+// even those of a Dict and of structs encoded with PreserveFieldOrder, so
+// that the encoding only depends on the keys and values of dicts, not the
+// order they are held in. Maps are always written with their keys sorted.
+func (e *Encoder) SortKeys(sort bool) {
+	e.sortKeys = sort
+}
+
+// PreserveFieldOrder sets whether struct fields are encoded in the order they
+// are declared in, instead of sorted by key like maps, which is the default.
+// Fields of embedded structs are encoded in place of the embedded field.
+// This is needed for documents like signed configuration profiles whose key
+// order must not change.
+func (e *Encoder) PreserveFieldOrder(preserve bool) {
+	e.fieldOrder = preserve
+}
+
+// SelfClosingEmpty sets whether empty arrays and dictionaries are written as
+// self closing elements (<array/> and <dict/>) instead of a start and end
+// element pair (<array></array> and <dict></dict>), which is the default.
+// Booleans are always written as the self closing <true/> and <false/>
+// elements required by the plist DTD.
+func (e *Encoder) SelfClosingEmpty(selfClosing bool) {
+	e.selfClosingEmpty = selfClosing
+}
+
+// InlineKeys sets whether each dictionary value is written on the same line
+// as its <key> when indenting, like <key>Name</key><string>x</string>,
+// instead of on a line of its own, which is the default and the layout Apple
+// uses. The contents of array and dictionary values are still indented on
+// the lines that follow. It has no effect without indentation.
+func (e *Encoder) InlineKeys(inline bool) {
+	e.inlineKeys = inline
+}
+
+// SubsecondDates sets whether XML dates are written with the fraction of their
+// second, like 2011-05-12T01:00:00.25Z, so that high resolution timestamps
+// aren't truncated. By default dates are written to the second, which is all
+// most tools, Apple's included, read. Binary dates always keep the fraction.
+func (e *Encoder) SubsecondDates(subsecond bool) {
+	e.subsecondDates = subsecond
+}
+
+// SetFinalNewline sets whether XML documents end with a newline after
+// </plist>, which is the default, like the files written by plutil.
+func (e *Encoder) SetFinalNewline(newline bool) {
+	e.noFinalNewline = !newline
+}
+
+// SetLineEnding sets the line ending written after the XML header and
+// between indented elements, which is either "\n", the default, or "\r\n".
+// The text of elements, like newlines in strings, is written as is.
+// SetLineEnding panics if ending is anything else.
+func (e *Encoder) SetLineEnding(ending string) {
+	if ending != "\n" && ending != "\r\n" {
+		panic(fmt.Sprintf("plist: unsupported line ending %q", ending))
+	}
+	e.lineEnding = ending
+}
+
+// SetBOM sets whether XML documents start with a UTF-8 byte order mark, as
+// written by some Apple frameworks. It is off by default. Decoders accept
+// documents with or without one.
+func (e *Encoder) SetBOM(bom bool) {
+	e.bom = bom
+}
+
+// SetMinIntegerWidth sets the minimum number of bytes used for integers in
+// binary plists, which is 1, 2, 4 or 8, to match files written by other
+// encoders byte for byte. Each integer is written in the smallest of those
+// widths that holds its value and is at least width bytes. The default of 1
+// gives the minimal widths CoreFoundation writes. XML plists are unaffected.
+// SetMinIntegerWidth panics if width is anything else.
+func (e *Encoder) SetMinIntegerWidth(width int) {
+	switch width {
+	case 1, 2, 4, 8:
+		e.minIntWidth = uint8(width)
+	default:
+		panic(fmt.Sprintf("plist: unsupported integer width %d", width))
+	}
+}
+
+// SetMaxBytes limits the size of each document the encoder writes to n bytes,
+// to enforce limits like those on profiles delivered over the air. Encoding
+// stops with ErrMaxBytes as soon as the document outgrows the limit, once the
+// bytes that fit have been written. A limit of 0, the default, means no limit.
+func (e *Encoder) SetMaxBytes(n int64) {
+	e.maxBytes = n
+}
+
+// AllowEmptyDocument sets whether Encode writes an XML plist without a value,
+// <plist version="1.0"></plist>, when the value is nil, instead of returning
+// an error, which is the default. Decoding such a document returns
+// ErrEmptyDocument. Binary plists always have a value, so binary encoders
+// still return an error.
+func (e *Encoder) AllowEmptyDocument(allow bool) {
+	e.allowEmpty = allow
+}
+
+// BoolAsInteger sets whether booleans are encoded as the integers 0 and 1
+// instead of <true/> and <false/>, for consumers that expect them that way.
+// Integers of 0 and 1 can be decoded into bool values.
+func (e *Encoder) BoolAsInteger(asInteger bool) {
+	e.boolAsInteger = asInteger
+}
+
+// startDetectingCyclesAfter is the nesting depth of pointers, maps and slices
+// after which marshal starts checking for cycles, which would otherwise recurse
+// forever. Checking only past this depth keeps the common case fast, like in
+// encoding/json.
+const startDetectingCyclesAfter = 1000
+
+// cycleKey identifies a pointer, map or slice while checking for cycles.
+type cycleKey struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
 func (e *Encoder) marshal(v reflect.Value) (*plistValue, error) {
+	return e.marshalValue(e.transformValue(v))
+}
+
+// marshalValue encodes v without passing it to the value transform, for
+// values that replace one that already was, like those returned by
+// MarshalPlist methods.
+func (e *Encoder) marshalValue(v reflect.Value) (*plistValue, error) {
+	cv := v
+	if cv.Kind() == reflect.Interface && !cv.IsNil() {
+		cv = cv.Elem()
+	}
+	switch cv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		if cv.IsNil() {
+			break
+		}
+		e.ptrLevel++
+		defer func() { e.ptrLevel-- }()
+		if e.ptrLevel > startDetectingCyclesAfter {
+			key := cycleKey{cv.Pointer(), cv.Type(), 0}
+			if cv.Kind() == reflect.Slice {
+				key.len = cv.Len()
+			}
+			if _, ok := e.ptrSeen[key]; ok {
+				return nil, &UnsupportedValueError{cv, fmt.Sprintf("encountered a cycle via %s", cv.Type())}
+			}
+			if e.ptrSeen == nil {
+				e.ptrSeen = make(map[cycleKey]struct{})
+			}
+			e.ptrSeen[key] = struct{}{}
+			defer delete(e.ptrSeen, key)
+		}
+	}
+
+	// Nil pointers and interfaces have no plist representation. They are
+	// left out of dictionaries by the callers of marshal.
+	if !v.IsValid() || (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return nil, nil
+	}
+
+	if e.validate {
+		if err := e.validateRegistered(cv); err != nil {
+			return nil, err
+		}
+	}
+
+	if pval, ok, err := e.marshalRegistered(cv); ok {
+		return pval, err
+	}
+
 	marshalerType := reflect.TypeOf((*Marshaler)(nil)).Elem()
 
 	if v.CanInterface() && v.Type().Implements(marshalerType) {
@@ -69,7 +412,7 @@ func (e *Encoder) marshal(v reflect.Value) (*plistValue, error) {
 		if err != nil {
 			return nil, err
 		}
-		return e.marshal(reflect.ValueOf(val))
+		return e.marshalValue(reflect.ValueOf(val))
 	}
 
 	if v.CanAddr() {
@@ -80,12 +423,19 @@ func (e *Encoder) marshal(v reflect.Value) (*plistValue, error) {
 			if err != nil {
 				return nil, err
 			}
-			return e.marshal(reflect.ValueOf(val))
+			return e.marshalValue(reflect.ValueOf(val))
 		}
 	}
 
-	// check for empty interface v type
-	if v.Kind() == reflect.Interface && v.NumMethod() == 0 || v.Kind() == reflect.Ptr {
+	// check for interface and pointer types, including pointers stored in
+	// interfaces like the *Dict values of a decoded Dict
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, nil
+		}
 		v = v.Elem()
 	}
 
@@ -97,16 +447,61 @@ func (e *Encoder) marshal(v reflect.Value) (*plistValue, error) {
 		return nil, &UnsupportedValueError{v, v.String()}
 	}
 
+	if v.Type() == commentedType {
+		return e.marshalCommented(v.Interface().(Commented))
+	}
+
+	if v.Type() == uidType {
+		return &plistValue{UIDKind, UID(v.Uint())}, nil
+	}
+
+	if v.Type() == bigFloatType {
+		return bigFloatValue(v), nil
+	}
+
+	if pval, ok, err := e.marshalText(v); ok {
+		return pval, err
+	}
+
+	if v.Type() == dictType {
+		d := v.Interface().(Dict)
+		return e.marshalDict(&d)
+	}
+
+	if v.Type() == rawValueType {
+		return rawValue(RawValue(v.Bytes()))
+	}
+
+	if v.Type() == numberType {
+		if pval, ok := numberValue(Number(v.String())); ok {
+			return pval, nil
+		}
+		return nil, &UnsupportedValueError{v, v.String()}
+	}
+
+	if e.useStringer {
+		if pval, ok := stringerValue(v); ok {
+			return pval, nil
+		}
+	}
+
 	switch v.Kind() {
 	case reflect.String:
 		return &plistValue{String, v.String()}, nil
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		return &plistValue{Integer, signedInt{uint64(v.Int()), true}}, nil
+		return &plistValue{Integer, signedInt{uint64(v.Int()), true, false}}, nil
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-		return &plistValue{Integer, signedInt{uint64(v.Uint()), false}}, nil
+		return &plistValue{Integer, signedInt{uint64(v.Uint()), false, false}}, nil
 	case reflect.Float32, reflect.Float64:
-		return &plistValue{Real, sizedFloat{v.Float(), v.Type().Bits()}}, nil
+		return &plistValue{Real, sizedFloat{v.Float(), v.Type().Bits(), ""}}, nil
 	case reflect.Bool:
+		if e.boolAsInteger {
+			var i uint64
+			if v.Bool() {
+				i = 1
+			}
+			return &plistValue{Integer, signedInt{i, false, false}}, nil
+		}
 		return &plistValue{Boolean, v.Bool()}, nil
 	case reflect.Slice, reflect.Array:
 		return e.marshalArray(v)
@@ -121,29 +516,88 @@ func (e *Encoder) marshal(v reflect.Value) (*plistValue, error) {
 
 func (e *Encoder) marshalStruct(v reflect.Value) (*plistValue, error) {
 	fields := cachedTypeFields(v.Type())
+	if root := rootField(fields); root != nil {
+		return e.marshalRoot(root, v)
+	}
 	dict := &dictionary{
-		m: make(map[string]*plistValue, len(fields)),
+		m:       make(map[string]*plistValue, len(fields)),
+		ordered: e.fieldOrder && !e.sortKeys,
 	}
 	for _, field := range fields {
+		if field.format {
+			continue
+		}
 		val := field.value(v)
 		if field.omitEmpty && isEmptyValue(val) {
+			if e.validate {
+				if err := validateField(field, nil); err != nil {
+					return nil, err
+				}
+			}
 			continue
 		}
-		value, err := e.marshal(field.value(v))
+		e.enterField(field)
+		value, err := e.marshalField(field, val)
+		e.leaveField(field)
 		if err != nil {
-			return nil, err
+			return nil, inPath(err, field.name)
+		}
+		if e.validate {
+			if err := validateField(field, value); err != nil {
+				return nil, err
+			}
+		}
+		if value == nil {
+			// Nil pointers and interfaces are omitted.
+			continue
 		}
-		dict.m[field.name] = value
+		if e.fieldComments && field.comment != "" {
+			if value, err = commentField(field, value); err != nil {
+				return nil, err
+			}
+		}
+		if field.path != nil {
+			if err := insertPath(dict, field.path, value); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		dict.set(field.name, value)
 	}
 	return &plistValue{Dictionary, dict}, nil
 }
 
+// marshalField encodes the value v of the struct field f.
+func (e *Encoder) marshalField(f field, v reflect.Value) (*plistValue, error) {
+	if e.transform != nil {
+		tv := e.transformValue(v)
+		if !tv.IsValid() || tv.Type() != v.Type() {
+			// The tag options of the field don't apply to a replacement
+			// of another type.
+			return e.marshalValue(tv)
+		}
+		v = tv
+	}
+	if f.keyedBy != "" && reflect.Indirect(v).Kind() == reflect.Map {
+		return e.marshalKeyed(reflect.Indirect(v), f.keyedBy)
+	}
+	if value := f.taggedValue(v); value != nil {
+		return value, nil
+	}
+	return e.marshalValue(v)
+}
+
 func (e *Encoder) marshalArray(v reflect.Value) (*plistValue, error) {
 	if v.Type().Elem().Kind() == reflect.Uint8 {
+		// Slices and addressable arrays share their backing array with the
+		// plistValue instead of being copied, since data can be large.
 		bytes := []byte(nil)
-		if v.CanAddr() {
+		switch {
+		case v.Kind() == reflect.Slice:
+			bytes = v.Bytes()
+		case v.CanAddr():
 			bytes = v.Slice(0, v.Len()).Bytes()
-		} else {
+		default:
 			bytes = make([]byte, v.Len())
 			reflect.Copy(reflect.ValueOf(bytes), v)
 		}
@@ -151,13 +605,17 @@ func (e *Encoder) marshalArray(v reflect.Value) (*plistValue, error) {
 	}
 	subvalues := make([]*plistValue, v.Len())
 	for idx, length := 0, v.Len(); idx < length; idx++ {
+		e.enterIndex(idx)
 		subpval, err := e.marshal(v.Index(idx))
+		e.leave()
 		if err != nil {
-			return nil, err
+			return nil, inPath(err, strconv.Itoa(idx))
 		}
-		if subpval != nil {
-			subvalues[idx] = subpval
+		if subpval == nil {
+			// Leaving the value out would shift the rest of the array.
+			return nil, &UnsupportedValueError{v.Index(idx), "nil value in array"}
 		}
+		subvalues[idx] = subpval
 	}
 	return &plistValue{Array, subvalues}, nil
 }
@@ -172,9 +630,11 @@ func (e *Encoder) marshalMap(v reflect.Value) (*plistValue, error) {
 		m: make(map[string]*plistValue, l),
 	}
 	for _, keyv := range v.MapKeys() {
+		e.enter(keyv.String())
 		subpval, err := e.marshal(v.MapIndex(keyv))
+		e.leave()
 		if err != nil {
-			return nil, err
+			return nil, inPath(err, keyv.String())
 		}
 		if subpval != nil {
 			dict.m[keyv.String()] = subpval
@@ -205,8 +665,11 @@ func (e *UnsupportedValueError) Error() string {
 
 func isEmptyValue(v reflect.Value) bool {
 	switch v.Kind() {
-	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+	case reflect.Array, reflect.String:
 		return v.Len() == 0
+	case reflect.Map, reflect.Slice:
+		// Empty maps and slices are kept, see Encode.
+		return v.IsNil()
 	case reflect.Bool:
 		return !v.Bool()
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
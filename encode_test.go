@@ -2,6 +2,14 @@ package plist
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -325,3 +333,1968 @@ func TestSelfClosing(t *testing.T) {
 	}
 
 }
+
+func TestSelfClosingEmpty(t *testing.T) {
+	t.Parallel()
+	empty := struct {
+		Array []string          `plist:"array"`
+		Dict  map[string]string `plist:"dict"`
+		Bool  bool              `plist:"bool"`
+	}{
+		Array: []string{},
+		Dict:  map[string]string{},
+	}
+
+	tests := []struct {
+		selfClosing bool
+		want        string
+	}{
+		{false, `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+	<dict>
+		<key>array</key>
+		<array></array>
+		<key>bool</key>
+		<false/>
+		<key>dict</key>
+		<dict></dict>
+	</dict>
+</plist>
+`},
+		{true, `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+	<dict>
+		<key>array</key>
+		<array/>
+		<key>bool</key>
+		<false/>
+		<key>dict</key>
+		<dict/>
+	</dict>
+</plist>
+`},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.Indent("\t")
+		enc.SelfClosingEmpty(tt.selfClosing)
+		if err := enc.Encode(empty); err != nil {
+			t.Fatal(err)
+		}
+		if have := buf.String(); have != tt.want {
+			t.Errorf("SelfClosingEmpty(%t): expected \n%s got \n%s\n", tt.selfClosing, tt.want, have)
+		}
+	}
+}
+
+func BenchmarkEncodeLargeData(b *testing.B) {
+	v := &struct {
+		Payload []byte `plist:"payload"`
+	}{Payload: make([]byte, 10<<20)}
+	b.ReportAllocs()
+	b.SetBytes(int64(len(v.Payload)))
+	for i := 0; i < b.N; i++ {
+		if err := NewEncoder(ioutil.Discard).Encode(v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestEncodeNumber(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   Number
+		want string
+	}{
+		{"1", oneRef},
+		{"-1", minOneRef},
+		{"1.2", realRef},
+	}
+	for _, tt := range tests {
+		have, err := Marshal(tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(have) != tt.want {
+			t.Errorf("Marshal(%q) = \n%s, want\n%s", tt.in, have, tt.want)
+		}
+	}
+	if _, err := Marshal(Number("one")); err == nil {
+		t.Error("expected error encoding an invalid Number")
+	}
+}
+
+type orderedEmbedded struct {
+	PayloadType    string
+	PayloadVersion int
+}
+
+func TestPreserveFieldOrder(t *testing.T) {
+	t.Parallel()
+	profile := struct {
+		PayloadUUID string
+		orderedEmbedded
+		PayloadDisplayName string
+		Content            map[string]int
+	}{
+		PayloadUUID:        "6B4A8B4E-4E2C-4C2A-9B0E-2D9E7E0C1F3A",
+		orderedEmbedded:    orderedEmbedded{"Configuration", 1},
+		PayloadDisplayName: "Wi-Fi",
+		Content:            map[string]int{"b": 2, "a": 1},
+	}
+
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+	<dict>
+		<key>PayloadUUID</key>
+		<string>6B4A8B4E-4E2C-4C2A-9B0E-2D9E7E0C1F3A</string>
+		<key>PayloadType</key>
+		<string>Configuration</string>
+		<key>PayloadVersion</key>
+		<integer>1</integer>
+		<key>PayloadDisplayName</key>
+		<string>Wi-Fi</string>
+		<key>Content</key>
+		<dict>
+			<key>a</key>
+			<integer>1</integer>
+			<key>b</key>
+			<integer>2</integer>
+		</dict>
+	</dict>
+</plist>
+`
+	for i := 0; i < 2; i++ {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.Indent("\t")
+		enc.PreserveFieldOrder(true)
+		if err := enc.Encode(profile); err != nil {
+			t.Fatal(err)
+		}
+		if have := buf.String(); have != want {
+			t.Errorf("expected \n%s got \n%s\n", want, have)
+		}
+	}
+}
+
+func TestDeterministicBinary(t *testing.T) {
+	t.Parallel()
+	encode := func(v interface{}, sortKeys bool) []byte {
+		var buf bytes.Buffer
+		enc := NewBinaryEncoder(&buf)
+		enc.SortKeys(sortKeys)
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	value := map[string]interface{}{
+		"name":    "profile",
+		"version": 1,
+		"items":   []interface{}{"a", "b", "a", 2.5},
+		"nested":  map[string]int{"z": 1, "y": 2, "x": 3, "w": 4},
+	}
+	first := encode(value, false)
+	for i := 0; i < 10; i++ {
+		if have := encode(value, false); !bytes.Equal(have, first) {
+			t.Fatalf("encoding %d differs:\n%x\n%x", i, have, first)
+		}
+	}
+
+	var ab, ba Dict
+	ab.Set("a", 1)
+	ab.Set("b", 2)
+	ba.Set("b", 2)
+	ba.Set("a", 1)
+	if bytes.Equal(encode(&ab, false), encode(&ba, false)) {
+		t.Error("Dicts in different orders encoded the same without SortKeys")
+	}
+	if !bytes.Equal(encode(&ab, true), encode(&ba, true)) {
+		t.Error("Dicts in different orders encoded differently with SortKeys")
+	}
+	var decoded Dict
+	if err := Unmarshal(encode(&ba, true), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if keys := decoded.Keys(); !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Errorf("keys with SortKeys: have %v, want [a b]", keys)
+	}
+}
+
+func TestEncoderOptions(t *testing.T) {
+	t.Parallel()
+	var d Dict
+	d.Set("b", true)
+	d.Set("a", []int{})
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf, WithIndent("\t"), WithSortKeys(), WithSelfClosingEmpty(), WithoutFinalNewline())
+	if err := enc.Encode(&d); err != nil {
+		t.Fatal(err)
+	}
+	var want bytes.Buffer
+	enc = NewEncoder(&want)
+	enc.Indent("\t")
+	enc.SortKeys(true)
+	enc.SelfClosingEmpty(true)
+	enc.SetFinalNewline(false)
+	if err := enc.Encode(&d); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != want.String() {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want.String())
+	}
+
+	buf.Reset()
+	if err := NewEncoder(&buf, WithFormat(FormatBinary)).Encode(&d); err != nil {
+		t.Fatal(err)
+	}
+	bin, _ := MarshalBinary(&d)
+	if !bytes.Equal(buf.Bytes(), bin) {
+		t.Errorf("WithFormat(FormatBinary): got %x, want %x", buf.Bytes(), bin)
+	}
+}
+
+func TestDataFormat(t *testing.T) {
+	t.Parallel()
+	blob := make([]byte, 100)
+	for i := range blob {
+		blob[i] = byte(i)
+	}
+	v := map[string]interface{}{"blob": blob}
+	// The data element is indented twice, inside the top level dict.
+	tests := []struct {
+		format DataFormat
+		want   string
+	}{
+		{DataFormat{}, "<data>AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8gISIjJCUmJygpKissLS4vMDEyMzQ1Njc4OTo7PD0+P0BBQkNERUZHSElKS0xNTk9QUVJTVFVWV1hZWltcXV5fYGFiYw==</data>"},
+		// 76 columns less 8 for each tab.
+		{AppleDataFormat, "<data>\n" +
+			"\t\tAAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8gISIjJCUmJygpKiss\n" +
+			"\t\tLS4vMDEyMzQ1Njc4OTo7PD0+P0BBQkNERUZHSElKS0xNTk9QUVJTVFVWV1hZ\n" +
+			"\t\tWltcXV5fYGFiYw==\n" +
+			"\t\t</data>"},
+		{DataFormat{LineWidth: 64, Indent: "  "}, "<data>AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8gISIjJCUmJygpKissLS4v\n" +
+			"\t\t  MDEyMzQ1Njc4OTo7PD0+P0BBQkNERUZHSElKS0xNTk9QUVJTVFVWV1hZWltcXV5f\n" +
+			"\t\t  YGFiYw==</data>"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf, WithDataFormat(tt.format))
+		enc.Indent("\t")
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+		out := buf.String()
+		start := strings.Index(out, "<data>")
+		end := strings.Index(out, "</data>") + len("</data>")
+		if start < 0 || end < 0 {
+			t.Fatalf("%+v: no data element in\n%s", tt.format, out)
+		}
+		if got := out[start:end]; got != tt.want {
+			t.Errorf("%+v: got\n%s\nwant\n%s", tt.format, got, tt.want)
+		}
+		var decoded map[string][]byte
+		if err := Unmarshal(buf.Bytes(), &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decoded["blob"], blob) {
+			t.Errorf("%+v: decoded %x, want %x", tt.format, decoded["blob"], blob)
+		}
+	}
+}
+
+func TestSubsecondDates(t *testing.T) {
+	t.Parallel()
+	date := time.Date(2011, 5, 12, 1, 0, 0, 123456e3, time.UTC)
+	out, err := Marshal(date)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "<date>2011-05-12T01:00:00Z</date>") {
+		t.Errorf("expected the date to the second, got\n%s", out)
+	}
+
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf, WithSubsecondDates()).Encode(date); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "<date>2011-05-12T01:00:00.123456Z</date>") {
+		t.Errorf("expected the date with its fraction, got\n%s", buf.String())
+	}
+	var got time.Time
+	if err := Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equal(date) {
+		t.Errorf("XML: got %v, want %v", got, date)
+	}
+
+	// Binary dates keep the fraction, as closely as a float64 holds it.
+	bin, err := MarshalBinary(date)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Unmarshal(bin, &got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := got.Sub(date); diff < -time.Microsecond || diff > time.Microsecond {
+		t.Errorf("binary: got %v, want %v", got, date)
+	}
+}
+
+func TestEncodeEmptyDocument(t *testing.T) {
+	t.Parallel()
+	if _, err := Marshal(nil); err == nil {
+		t.Error("expected error encoding nil without AllowEmptyDocument")
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.Indent("\t")
+	enc.AllowEmptyDocument(true)
+	for _, v := range []interface{}{nil, (*string)(nil)} {
+		buf.Reset()
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+		want := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0"></plist>
+`
+		if buf.String() != want {
+			t.Errorf("%#v: got\n%s\nwant\n%s", v, buf.String(), want)
+		}
+		var decoded interface{}
+		if err := Unmarshal(buf.Bytes(), &decoded); err != ErrEmptyDocument {
+			t.Errorf("decoding: got error %v, want ErrEmptyDocument", err)
+		}
+	}
+
+	enc = NewBinaryEncoder(ioutil.Discard)
+	enc.AllowEmptyDocument(true)
+	if err := enc.Encode(nil); err == nil {
+		t.Error("expected error encoding nil as a binary plist")
+	}
+}
+
+func TestReindent(t *testing.T) {
+	t.Parallel()
+	in := utf8BOM + `<?xml version="1.0" encoding="UTF-8"?>  <!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0"><dict>
+<key>b</key>   <string>  two
+ lines &amp; &#10;</string><!-- note -->` + "\r\n" +
+		`<key>a</key><array><integer> 1 </integer><dict/><array></array><true/></array></dict></plist>`
+	want := utf8BOM + `<?xml version="1.0" encoding="UTF-8"?>
+> <!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+> <plist version="1.0">
+> 	<dict>
+> 		<key>b</key>
+> 		<string>  two
+ lines &amp; &#10;</string>
+> 		<!-- note -->
+> 		<key>a</key>
+> 		<array>
+> 			<integer> 1 </integer>
+> 			<dict/>
+> 			<array></array>
+> 			<true/>
+> 		</array>
+> 	</dict>
+> </plist>
+`
+	out, err := Reindent([]byte(in), "> ", "\t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != want {
+		t.Errorf("got\n%s\nwant\n%s", out, want)
+	}
+
+	// Reindenting the output of an Encoder with the same indent changes
+	// nothing.
+	data, err := MarshalIndent(map[string]interface{}{"a": []int{1, 2}, "b": map[string]string{}}, "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := Reindent(data, "", "  "); err != nil || !bytes.Equal(out, data) {
+		t.Errorf("got %v\n%s\nwant\n%s", err, out, data)
+	}
+
+	for _, bad := range []string{`<plist><dict>text<key>a</key><true/></dict></plist>`, `<plist><dict></plist>`} {
+		if _, err := Reindent([]byte(bad), "", "\t"); err == nil {
+			t.Errorf("%s: expected error", bad)
+		}
+	}
+}
+
+// failingWriter accepts n bytes and then fails.
+type failingWriter struct {
+	n   int
+	err error
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, w.err
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestEncodeWriteError(t *testing.T) {
+	t.Parallel()
+	want := errors.New("write failed")
+	v := make([]string, 1000)
+	for _, n := range []int{0, 100, 5000} {
+		err := NewEncoder(&failingWriter{n: n, err: want}).Encode(v)
+		if err != want {
+			t.Errorf("failing after %d bytes: have error %v, want %v", n, err, want)
+		}
+	}
+
+	// Everything has been written once Encode returns.
+	w := &failingWriter{n: len(arrRef), err: want}
+	if err := NewEncoder(w).Encode([]interface{}{"a", "b", "c", 4, true}); err != nil {
+		t.Fatal(err)
+	}
+	if w.n != 0 {
+		t.Errorf("%d bytes of output missing", w.n)
+	}
+}
+
+func TestEncodeComments(t *testing.T) {
+	t.Parallel()
+	v := struct {
+		Name    Commented   `plist:"name"`
+		Servers interface{} `plist:"servers"`
+	}{
+		Name:    Commented{"Shown in System Preferences", "Wi-Fi"},
+		Servers: []interface{}{Commented{"primary", "a.example.com"}, "b.example.com"},
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+	<dict>
+		<!-- Shown in System Preferences -->
+		<key>name</key>
+		<string>Wi-Fi</string>
+		<key>servers</key>
+		<array>
+			<!-- primary -->
+			<string>a.example.com</string>
+			<string>b.example.com</string>
+		</array>
+	</dict>
+</plist>
+`
+	have, err := MarshalIndent(v, "\t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(have) != want {
+		t.Errorf("expected \n%s got \n%s\n", want, have)
+	}
+
+	var decoded struct {
+		Name    string   `plist:"name"`
+		Servers []string `plist:"servers"`
+	}
+	if err := Unmarshal(have, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Name != "Wi-Fi" || len(decoded.Servers) != 2 {
+		t.Errorf("unexpected decoded value %+v", decoded)
+	}
+
+	if _, err := Marshal(Commented{"not -- allowed", true}); err == nil {
+		t.Error("expected error for comment containing --")
+	}
+}
+
+func TestFieldComments(t *testing.T) {
+	t.Parallel()
+	type proxy struct {
+		Host string `plist:"Host" plistComment:"Name or address of the proxy server"`
+		Port int    `plist:"Port"`
+	}
+	v := proxy{"proxy.example.com", 8080}
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+	<dict>
+		<!-- Name or address of the proxy server -->
+		<key>Host</key>
+		<string>proxy.example.com</string>
+		<key>Port</key>
+		<integer>8080</integer>
+	</dict>
+</plist>
+`
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.Indent("\t")
+	enc.FieldComments(true)
+	if err := enc.Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != want {
+		t.Errorf("expected \n%s got \n%s\n", want, buf.String())
+	}
+
+	// Without FieldComments the tag is ignored.
+	have, err := MarshalIndent(v, "\t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(have, []byte("<!--")) {
+		t.Errorf("unexpected comment in \n%s", have)
+	}
+
+	enc = NewEncoder(ioutil.Discard)
+	enc.FieldComments(true)
+	bad := struct {
+		A bool `plistComment:"not -- allowed"`
+	}{}
+	if err := enc.Encode(bad); err == nil {
+		t.Error("expected error for comment containing --")
+	}
+}
+
+func TestEncodeIncremental(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.Indent("  ")
+	steps := []func() error{
+		enc.BeginDict,
+		func() error { return enc.WriteKey("name") },
+		func() error { return enc.WriteString("bar") },
+		func() error { return enc.WriteKey("records") },
+		enc.BeginArray,
+		func() error { return enc.WriteString("foo") },
+		func() error { return enc.WriteValue(map[string]int{"id": 1}) },
+		enc.EndArray,
+		enc.EndDict,
+	}
+	for i, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+
+	v := map[string]interface{}{
+		"records": []interface{}{"foo", map[string]int{"id": 1}},
+		"name":    "bar",
+	}
+	want, err := MarshalIndent(v, "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != string(want) {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	// The encoder can start another document once one is finished.
+	buf.Reset()
+	if err := enc.WriteString("foo"); err != nil {
+		t.Fatal(err)
+	}
+	want, _ = MarshalIndent("foo", "  ")
+	if buf.String() != string(want) {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestEncodeIncrementalMismatch(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		steps func(enc *Encoder) error
+	}{
+		{"end without begin", func(enc *Encoder) error { return enc.EndDict() }},
+		{"key outside dict", func(enc *Encoder) error {
+			if err := enc.BeginArray(); err != nil {
+				return nil
+			}
+			return enc.WriteKey("foo")
+		}},
+		{"value without key", func(enc *Encoder) error {
+			if err := enc.BeginDict(); err != nil {
+				return nil
+			}
+			return enc.WriteString("foo")
+		}},
+		{"two keys", func(enc *Encoder) error {
+			if err := enc.BeginDict(); err != nil {
+				return nil
+			}
+			if err := enc.WriteKey("foo"); err != nil {
+				return nil
+			}
+			return enc.WriteKey("bar")
+		}},
+		{"key without value", func(enc *Encoder) error {
+			if err := enc.BeginDict(); err != nil {
+				return nil
+			}
+			if err := enc.WriteKey("foo"); err != nil {
+				return nil
+			}
+			return enc.EndDict()
+		}},
+		{"end array closing dict", func(enc *Encoder) error {
+			if err := enc.BeginDict(); err != nil {
+				return nil
+			}
+			return enc.EndArray()
+		}},
+		{"encode while unfinished", func(enc *Encoder) error {
+			if err := enc.BeginArray(); err != nil {
+				return nil
+			}
+			return enc.Encode("foo")
+		}},
+	}
+	for _, tt := range tests {
+		enc := NewEncoder(ioutil.Discard)
+		if err := tt.steps(enc); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
+
+func TestEncodeArrayFunc(t *testing.T) {
+	t.Parallel()
+	values := []interface{}{"a", 1, map[string]bool{"b": true}}
+	iterate := func(values []interface{}) func() (interface{}, bool, error) {
+		i := 0
+		return func() (interface{}, bool, error) {
+			if i == len(values) {
+				return nil, false, nil
+			}
+			i++
+			return values[i-1], true, nil
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.Indent("  ")
+	if err := enc.EncodeArrayFunc(iterate(values)); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := MarshalIndent(values, "  ")
+	if buf.String() != string(want) {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	// Inside an incrementally written document the array is its next value.
+	buf.Reset()
+	if err := enc.BeginDict(); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.WriteKey("items"); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.EncodeArrayFunc(iterate(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.EndDict(); err != nil {
+		t.Fatal(err)
+	}
+	want, _ = MarshalIndent(map[string][]int{"items": {}}, "  ")
+	if buf.String() != string(want) {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	binEnc := NewBinaryEncoder(&buf)
+	if err := binEnc.EncodeArrayFunc(iterate(values)); err != nil {
+		t.Fatal(err)
+	}
+	want, _ = MarshalBinary(values)
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("binary: got %x, want %x", buf.Bytes(), want)
+	}
+
+	// Errors from next are returned, and the encoder can be used again.
+	errNext := errors.New("cursor closed")
+	err := enc.EncodeArrayFunc(func() (interface{}, bool, error) {
+		return nil, false, errNext
+	})
+	if err != errNext {
+		t.Errorf("got error %v, want %v", err, errNext)
+	}
+	if err := enc.Encode("foo"); err != nil {
+		t.Errorf("encoding after an error: %v", err)
+	}
+	if err := enc.EncodeArrayFunc(iterate([]interface{}{make(chan int)})); err == nil {
+		t.Error("expected error encoding an unsupported value")
+	}
+}
+
+func TestEncodeBinaryIntegerWidths(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in   interface{}
+		want []byte // the encoded root object
+	}{
+		{0, []byte{0x10, 0x00}},
+		{uint8(255), []byte{0x10, 0xff}},
+		{256, []byte{0x11, 0x01, 0x00}},
+		{uint64(math.MaxUint16), []byte{0x11, 0xff, 0xff}},
+		{int32(math.MaxInt32), []byte{0x12, 0x7f, 0xff, 0xff, 0xff}},
+		{uint32(math.MaxUint32), []byte{0x12, 0xff, 0xff, 0xff, 0xff}},
+		{uint64(1 << 32), []byte{0x13, 0, 0, 0, 1, 0, 0, 0, 0}},
+		{-1, []byte{0x13, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{int64(math.MinInt64), []byte{0x13, 0x80, 0, 0, 0, 0, 0, 0, 0}},
+		{uint64(math.MaxUint64), []byte{0x14, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+	}
+	for _, tt := range tests {
+		out, err := MarshalBinary(tt.in)
+		if err != nil {
+			t.Fatalf("%v: %v", tt.in, err)
+		}
+		// The root object directly follows the header.
+		if got := out[8 : 8+len(tt.want)]; !bytes.Equal(got, tt.want) {
+			t.Errorf("%v: got % x, want % x", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeBinaryRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	in := map[string]interface{}{
+		"string":  "foo",
+		"unicode": "h\u00e9llo \u4e16\u754c",
+		"long":    strings.Repeat("x", 300),
+		"true":    true,
+		"false":   false,
+		"int":     int64(-42),
+		"uint":    uint64(math.MaxUint64),
+		"real":    3.25,
+		"date":    time.Date(2020, 5, 17, 12, 30, 15, 0, time.UTC),
+		"data":    []byte("hello"),
+		"array":   []interface{}{"a", uint64(1), []interface{}{}},
+		"dict":    map[string]interface{}{"nested": "value"},
+	}
+	out, err := MarshalBinary(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(out, []byte("bplist00")) {
+		t.Fatalf("missing binary header: % x", out[:8])
+	}
+	var got map[string]interface{}
+	if err := Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, in) {
+		t.Errorf("got %#v\nwant %#v", got, in)
+	}
+}
+
+func TestEncodeIntegerRange(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in   interface{}
+		want string
+	}{
+		{uint64(math.MaxUint64), "<integer>18446744073709551615</integer>"},
+		{uint64(math.MaxInt64 + 1), "<integer>9223372036854775808</integer>"},
+		{int64(math.MaxInt64), "<integer>9223372036854775807</integer>"},
+		{int64(math.MinInt64), "<integer>-9223372036854775808</integer>"},
+	}
+	for _, tt := range tests {
+		out, err := Marshal(tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(out, []byte(tt.want)) {
+			t.Errorf("%v: expected %s in\n%s", tt.in, tt.want, out)
+		}
+	}
+}
+
+func TestEncodeKeyPath(t *testing.T) {
+	t.Parallel()
+
+	v := struct {
+		Type string `plist:"PayloadContent.0.PayloadType,path"`
+		UUID string `plist:"PayloadContent.0.PayloadUUID,path"`
+		Name string `plist:"Meta.Name,path"`
+		Top  string `plist:"com.example.key"`
+	}{"wifi", "uuid-1", "name", "dotted"}
+	out, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"PayloadContent": []interface{}{
+			map[string]interface{}{"PayloadType": "wifi", "PayloadUUID": "uuid-1"},
+		},
+		"Meta":            map[string]interface{}{"Name": "name"},
+		"com.example.key": "dotted",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v\nwant %#v", got, want)
+	}
+
+	gap := struct {
+		Value string `plist:"Items.1,path"`
+	}{"foo"}
+	if _, err := Marshal(gap); err == nil {
+		t.Error("expected error for index past the end of an array")
+	}
+}
+
+func TestBoolAsInteger(t *testing.T) {
+	t.Parallel()
+
+	type flags struct {
+		On  bool
+		Off bool
+	}
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.BoolAsInteger(true)
+	if err := enc.Encode(flags{On: true}); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "<key>On</key><integer>1</integer>") || !strings.Contains(out, "<key>Off</key><integer>0</integer>") {
+		t.Errorf("unexpected output\n%s", out)
+	}
+
+	var got flags
+	if err := Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.On || got.Off {
+		t.Errorf("got %+v", got)
+	}
+
+	var b bool
+	if err := Unmarshal([]byte(`<plist version="1.0"><integer>2</integer></plist>`), &b); err == nil {
+		t.Error("expected error decoding 2 into bool")
+	}
+}
+
+func TestEncodeBinaryDedup(t *testing.T) {
+	t.Parallel()
+
+	records := make([]map[string]interface{}, 100)
+	for i := range records {
+		records[i] = map[string]interface{}{
+			"type":    "record",
+			"enabled": true,
+			"count":   42,
+			"data":    []byte("payload"),
+		}
+	}
+	out, err := MarshalBinary(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewBinaryReader(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without deduplication every record would add 4 keys and 4 values.
+	// With it, only the dictionaries themselves are added per record.
+	withoutDedup := 1 + len(records)*(1+4+4)
+	withDedup := 1 + len(records) + 4 + 4
+	if got := r.NumObjects(); got != withDedup {
+		t.Errorf("got %d objects, want %d (%d without deduplication)", got, withDedup, withoutDedup)
+	}
+
+	// Records with distinct values only share their keys, and take more
+	// space than the identical ones.
+	distinct := make([]map[string]interface{}, len(records))
+	for i := range distinct {
+		distinct[i] = map[string]interface{}{
+			"type":   fmt.Sprintf("record %d", i),
+			"weight": float64(i) + 0.5,
+			"count":  42 + i,
+			"data":   []byte(fmt.Sprint("payload", i)),
+		}
+	}
+	distinctOut, err := MarshalBinary(distinct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dr, err := NewBinaryReader(bytes.NewReader(distinctOut))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := dr.NumObjects(), 1+len(records)*(1+4)+4; got != want {
+		t.Errorf("distinct: got %d objects, want %d", got, want)
+	}
+	if len(out) >= len(distinctOut) {
+		t.Errorf("got %d bytes with duplicates, %d without", len(out), len(distinctOut))
+	}
+
+	// Values that are equal in Go but encoded differently are kept apart.
+	mixed := []interface{}{
+		uint64(math.MaxUint64), int64(-1),
+		1, 1.0, true,
+		"1", []byte("1"),
+		UID(1),
+	}
+	mixedOut, err := MarshalBinary(mixed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr, err := NewBinaryReader(bytes.NewReader(mixedOut))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := mr.NumObjects(), 1+len(mixed); got != want {
+		t.Errorf("mixed: got %d objects, want %d", got, want)
+	}
+
+	var got []map[string]interface{}
+	if err := Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(records) || got[99]["type"] != "record" {
+		t.Errorf("unexpected round trip %v", got)
+	}
+}
+
+func TestEncodeCycle(t *testing.T) {
+	t.Parallel()
+
+	type node struct {
+		Next *node
+	}
+	n := &node{}
+	n.Next = n
+	m := map[string]interface{}{}
+	m["self"] = m
+
+	for _, v := range []interface{}{n, m} {
+		if _, err := MarshalBinary(v); err == nil {
+			t.Errorf("%T: expected cycle error", v)
+		}
+		if _, err := Marshal(v); err == nil {
+			t.Errorf("%T: expected cycle error", v)
+		}
+	}
+}
+
+func TestEncodeNilPointer(t *testing.T) {
+	t.Parallel()
+
+	type expiring struct {
+		Expires *time.Time
+		Name    string
+	}
+	out, err := Marshal(expiring{Name: "foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(out, []byte("Expires")) {
+		t.Errorf("expected nil pointer to be omitted\n%s", out)
+	}
+
+	out, err = Marshal(map[string]interface{}{"nil": nil, "name": "foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(out, []byte("<key>nil</key>")) {
+		t.Errorf("expected nil map value to be omitted\n%s", out)
+	}
+
+	for _, v := range []interface{}{nil, (*time.Time)(nil), []interface{}{"foo", nil}} {
+		if _, err := Marshal(v); err == nil {
+			t.Errorf("%#v: expected error", v)
+		}
+	}
+}
+
+// canonicalRef is formatted the way plutil -convert xml1 writes it.
+const canonicalRef = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>array</key>
+	<array/>
+	<key>data</key>
+	<data>
+	aGVsbG8gd29ybGQgaGVsbG8gd29ybGQgaGVsbG8gd29ybGQgaGVsbG8gd29ybGQgaGVs
+	bG8gd29ybGQ=
+	</data>
+	<key>date</key>
+	<date>2020-01-02T03:04:05Z</date>
+	<key>int</key>
+	<integer>-3</integer>
+	<key>nested</key>
+	<dict>
+		<key>data</key>
+		<data>
+		aGk=
+		</data>
+		<key>empty</key>
+		<dict/>
+		<key>zero</key>
+		<real>0.0</real>
+	</dict>
+	<key>real</key>
+	<real>0.10000000000000001</real>
+	<key>string</key>
+	<string>a &amp; "b" &lt;c&gt;</string>
+	<key>true</key>
+	<true/>
+</dict>
+</plist>
+`
+
+func TestCanonicalize(t *testing.T) {
+	t.Parallel()
+
+	const messy = `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0"><dict><key>true</key><true/><key>string</key><string>a &amp; "b" &lt;c&gt;</string>
+<key>array</key><array></array><key>data</key><data>aGVsbG8gd29ybGQgaGVsbG8gd29ybGQg
+aGVsbG8gd29ybGQgaGVsbG8gd29ybGQgaGVsbG8gd29ybGQ=</data><key>nested</key><dict><key>zero</key><real>0</real>
+<key>data</key><data>aGk=</data><key>empty</key><dict></dict></dict><key>real</key><real>.1</real>
+<key>int</key><integer>-3</integer><key>date</key><date>2020-01-02T03:04:05Z</date></dict></plist>`
+
+	out, err := Canonicalize([]byte(messy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != canonicalRef {
+		t.Errorf("got\n%s\nwant\n%s", out, canonicalRef)
+	}
+
+	// Canonical output is unchanged by canonicalizing it again.
+	again, err := Canonicalize(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, out) {
+		t.Errorf("canonicalizing twice changed the output\n%s", again)
+	}
+
+	// Binary input gives the same output.
+	var v interface{}
+	if err := Unmarshal([]byte(messy), &v); err != nil {
+		t.Fatal(err)
+	}
+	bin, err := MarshalBinary(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err = Canonicalize(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != canonicalRef {
+		t.Errorf("binary: got\n%s\nwant\n%s", out, canonicalRef)
+	}
+
+	// Integers above math.MaxInt64 are stored in 16 bytes in binary plists,
+	// and are unsigned in both formats.
+	for _, tt := range []struct {
+		v    interface{}
+		want string
+	}{
+		{uint64(math.MaxUint64), "<integer>18446744073709551615</integer>"},
+		{int64(-1), "<integer>-1</integer>"},
+	} {
+		xml, err := Marshal(tt.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bin, err := MarshalBinary(tt.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fromXML, err := Canonicalize(xml)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fromBinary, err := Canonicalize(bin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Contains(fromXML, []byte(tt.want)) || !bytes.Equal(fromBinary, fromXML) {
+			t.Errorf("%v: want %s from both formats, got\n%s\nand\n%s", tt.v, tt.want, fromXML, fromBinary)
+		}
+	}
+}
+
+func TestSetFinalNewline(t *testing.T) {
+	t.Parallel()
+
+	out, err := Marshal("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(out, []byte("</plist>\n")) {
+		t.Errorf("expected final newline by default\n%q", out)
+	}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetFinalNewline(false)
+	if err := enc.Encode("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(buf.Bytes(), []byte("</plist>")) {
+		t.Errorf("expected no final newline\n%q", buf.Bytes())
+	}
+}
+
+func TestEncodeUnsupportedType(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		value interface{}
+		typ   reflect.Type
+	}{
+		{struct{ C chan int }{make(chan int)}, reflect.TypeOf(make(chan int))},
+		{struct{ F func() }{func() {}}, reflect.TypeOf(func() {})},
+		{[]interface{}{complex(1, 2)}, reflect.TypeOf(complex(1, 2))},
+	}
+	for _, tt := range tests {
+		for _, marshal := range []func(interface{}) ([]byte, error){Marshal, MarshalBinary} {
+			_, err := marshal(tt.value)
+			var typeErr *UnsupportedTypeError
+			if !errors.As(err, &typeErr) {
+				t.Errorf("%T: expected *UnsupportedTypeError, got %v", tt.value, err)
+				continue
+			}
+			if typeErr.Type != tt.typ {
+				t.Errorf("%T: expected type %v, got %v", tt.value, tt.typ, typeErr.Type)
+			}
+			if !strings.Contains(err.Error(), tt.typ.String()) {
+				t.Errorf("%T: expected type in message, got %q", tt.value, err)
+			}
+		}
+	}
+}
+
+func TestEncodeGenericTree(t *testing.T) {
+	t.Parallel()
+
+	in := map[string]interface{}{
+		"string": "foo",
+		"int":    uint64(42),
+		"neg":    int64(-3),
+		"real":   1.5,
+		"bool":   true,
+		"date":   time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		"data":   []byte("hello"),
+		"array": []interface{}{
+			"a",
+			[]byte{1, 2},
+			time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+			map[string]interface{}{"x": false},
+			[]interface{}{uint64(1), 2.5},
+		},
+		"dict": map[string]interface{}{
+			"nested": map[string]interface{}{
+				"date": time.Date(1999, 12, 31, 23, 59, 59, 0, time.UTC),
+				"data": []byte("world"),
+			},
+		},
+		"emptyArray": []interface{}{},
+		"emptyDict":  map[string]interface{}{},
+	}
+
+	out, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, elem := range []string{
+		"<date>2020-01-02T03:04:05Z</date>", "<data>aGVsbG8=</data>",
+		"<date>2021-01-01T00:00:00Z</date>", "<data>AQI=</data>",
+		"<date>1999-12-31T23:59:59Z</date>", "<dict><key>x</key><false/></dict>",
+		"<array><integer>1</integer><real>2.5</real></array>",
+	} {
+		if !bytes.Contains(out, []byte(elem)) {
+			t.Errorf("expected %s in\n%s", elem, out)
+		}
+	}
+
+	for _, marshal := range []func(interface{}) ([]byte, error){Marshal, MarshalBinary} {
+		out, err := marshal(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got map[string]interface{}
+		if err := Unmarshal(out, &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, in) {
+			t.Errorf("got %#v\nwant %#v", got, in)
+		}
+	}
+}
+
+func TestEncodeUnixDate(t *testing.T) {
+	t.Parallel()
+
+	type record struct {
+		Created  time.Time  `plist:"created,unixdate"`
+		Modified *time.Time `plist:"modified,unixdate,ms"`
+		Expires  time.Time  `plist:"expires,unixdate"`
+	}
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 678e6, time.UTC)
+	in := record{Created: created, Modified: &modified}
+
+	out, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, elem := range []string{
+		"<key>created</key><integer>1577934245</integer>",
+		"<key>modified</key><integer>1577934245678</integer>",
+	} {
+		if !bytes.Contains(out, []byte(elem)) {
+			t.Errorf("expected %s in\n%s", elem, out)
+		}
+	}
+
+	var got record
+	if err := Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Created.Equal(created) || got.Modified == nil || !got.Modified.Equal(modified) {
+		t.Errorf("got %+v, want %+v", got, in)
+	}
+
+	// A <date> still decodes into a unixdate field.
+	doc := `<plist version="1.0"><dict><key>expires</key><date>2021-01-01T00:00:00Z</date></dict></plist>`
+	if err := Unmarshal([]byte(doc), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !got.Expires.Equal(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected expires %v", got.Expires)
+	}
+}
+
+func TestSetLineEnding(t *testing.T) {
+	t.Parallel()
+
+	v := map[string]interface{}{"text": "two\nlines", "list": []interface{}{true}}
+	var lf, crlf bytes.Buffer
+	enc := NewEncoder(&lf)
+	enc.Indent("\t")
+	if err := enc.Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	enc = NewEncoder(&crlf)
+	enc.Indent("\t")
+	enc.SetLineEnding("\r\n")
+	if err := enc.Encode(v); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the line endings between elements change, not the string.
+	expected := strings.Replace(lf.String(), "\n", "\r\n", -1)
+	expected = strings.Replace(expected, "two\r\nlines", "two\nlines", 1)
+	if crlf.String() != expected {
+		t.Errorf("got %q\nwant %q", crlf.String(), expected)
+	}
+	if !strings.Contains(crlf.String(), "<string>two\nlines</string>") {
+		t.Errorf("string contents changed\n%q", crlf.String())
+	}
+}
+
+func TestEncodedLen(t *testing.T) {
+	t.Parallel()
+
+	values := []interface{}{
+		"foo",
+		map[string]interface{}{
+			"string": "héllo",
+			"int":    int64(-42),
+			"data":   bytes.Repeat([]byte{1}, 1000),
+			"list":   []interface{}{true, 1.5, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+		},
+	}
+	for _, v := range values {
+		for format, marshal := range map[Format]func(interface{}) ([]byte, error){
+			FormatXML:    Marshal,
+			FormatBinary: MarshalBinary,
+		} {
+			out, err := marshal(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, err := EncodedLen(v, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != len(out) {
+				t.Errorf("%v: EncodedLen %d, encoded %d bytes", format, n, len(out))
+			}
+		}
+	}
+
+	if _, err := EncodedLen(make(chan int), FormatBinary); err == nil {
+		t.Error("expected error for unsupported type")
+	}
+	if _, err := EncodedLen("foo", Format(9)); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+func TestMinify(t *testing.T) {
+	t.Parallel()
+
+	const minified = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0"><dict><key>array</key><array/><key>data</key><data>aGVsbG8gd29ybGQgaGVsbG8gd29ybGQgaGVsbG8gd29ybGQgaGVsbG8gd29ybGQgaGVsbG8gd29ybGQ=</data><key>date</key><date>2020-01-02T03:04:05Z</date><key>int</key><integer>-3</integer><key>nested</key><dict><key>data</key><data>aGk=</data><key>empty</key><dict/><key>zero</key><real>0.0</real></dict><key>real</key><real>0.10000000000000001</real><key>string</key><string>a &amp; &#34;b&#34; &lt;c&gt;</string><key>true</key><true/></dict></plist>`
+
+	out, err := Minify([]byte(canonicalRef))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != minified {
+		t.Errorf("got\n%s\nwant\n%s", out, minified)
+	}
+	if len(out) >= len(canonicalRef) {
+		t.Errorf("minified %d bytes to %d bytes", len(canonicalRef), len(out))
+	}
+
+	var before, after interface{}
+	if err := Unmarshal([]byte(canonicalRef), &before); err != nil {
+		t.Fatal(err)
+	}
+	if err := Unmarshal(out, &after); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("minifying changed the content\ngot  %#v\nwant %#v", after, before)
+	}
+}
+
+func TestEncodeHexBytes(t *testing.T) {
+	t.Parallel()
+
+	type key struct {
+		Key  []byte `plist:"key,hex"`
+		Data []byte `plist:"data"`
+	}
+	in := key{Key: []byte{0xde, 0xad, 0xbe, 0xef}, Data: []byte{1}}
+	out, err := Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(out, []byte("<key>key</key><string>deadbeef</string>")) {
+		t.Errorf("expected hex string in\n%s", out)
+	}
+	var got key
+	if err := Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, in) {
+		t.Errorf("got %+v, want %+v", got, in)
+	}
+
+	for _, s := range []string{"abc", "zz"} {
+		doc := `<plist version="1.0"><dict><key>key</key><string>` + s + `</string></dict></plist>`
+		if err := Unmarshal([]byte(doc), &got); err == nil {
+			t.Errorf("%s: expected error for invalid hex", s)
+		}
+	}
+}
+
+func TestEncodeIntegralReal(t *testing.T) {
+	t.Parallel()
+
+	const doc = `<plist version="1.0"><dict><key>int</key><integer>5</integer><key>real</key><real>5</real></dict></plist>`
+	var m map[string]interface{}
+	if err := Unmarshal([]byte(doc), &m); err != nil {
+		t.Fatal(err)
+	}
+	for _, marshal := range []func(interface{}) ([]byte, error){Marshal, MarshalBinary} {
+		out, err := marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(out, []byte("bplist")) && !bytes.Contains(out, []byte("<key>real</key><real>5</real>")) {
+			t.Errorf("expected integral real to stay a <real>\n%s", out)
+		}
+		var got map[string]interface{}
+		if err := Unmarshal(out, &got); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := got["real"].(float64); !ok {
+			t.Errorf("expected float64 for real, got %T", got["real"])
+		}
+		if _, ok := got["int"].(uint64); !ok {
+			t.Errorf("expected uint64 for integer, got %T", got["int"])
+		}
+	}
+}
+
+func TestSetBOM(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetBOM(true)
+	if err := enc.Encode("foo"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("\xef\xbb\xbf<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")) {
+		t.Errorf("expected BOM and XML header\n%q", buf.Bytes())
+	}
+
+	var s string
+	if err := Unmarshal(buf.Bytes(), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s != "foo" {
+		t.Errorf("got %q, want foo", s)
+	}
+
+	out, err := Marshal("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.HasPrefix(out, []byte("\xef\xbb\xbf")) {
+		t.Error("expected no BOM by default")
+	}
+}
+
+func TestEncodeNilCollections(t *testing.T) {
+	t.Parallel()
+
+	type collections struct {
+		NilMap     map[string]string
+		EmptyMap   map[string]string
+		NilSlice   []string
+		EmptySlice []string
+
+		OmitNilMap     map[string]string `plist:",omitempty"`
+		OmitEmptyMap   map[string]string `plist:",omitempty"`
+		OmitNilSlice   []string          `plist:",omitempty"`
+		OmitEmptySlice []string          `plist:",omitempty"`
+	}
+	v := collections{
+		EmptyMap:       map[string]string{},
+		EmptySlice:     []string{},
+		OmitEmptyMap:   map[string]string{},
+		OmitEmptySlice: []string{},
+	}
+	out, err := Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<plist version="1.0"><dict>` +
+		`<key>EmptyMap</key><dict></dict>` +
+		`<key>EmptySlice</key><array></array>` +
+		`<key>NilMap</key><dict></dict>` +
+		`<key>NilSlice</key><array></array>` +
+		`<key>OmitEmptyMap</key><dict></dict>` +
+		`<key>OmitEmptySlice</key><array></array>` +
+		`</dict></plist>`
+	if !bytes.Contains(out, []byte(expected)) {
+		t.Errorf("got\n%s\nwant\n%s", out, expected)
+	}
+}
+
+func TestEncodeSpecialKeys(t *testing.T) {
+	t.Parallel()
+
+	keys := []string{
+		"com.apple/with spaces & <brackets>",
+		`quotes "double" and 'single'`,
+		"]]>",
+		"tab\tand\nnewline",
+		"  padded  ",
+		"",
+	}
+	m := make(map[string]int)
+	for i, k := range keys {
+		m[k] = i
+	}
+
+	out, err := Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	escaped := "<key>com.apple/with spaces &amp; &lt;brackets&gt;</key>"
+	if !bytes.Contains(out, []byte(escaped)) {
+		t.Errorf("expected %s in\n%s", escaped, out)
+	}
+
+	for _, marshal := range []func(interface{}) ([]byte, error){Marshal, MarshalBinary} {
+		out, err := marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got map[string]int
+		if err := Unmarshal(out, &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, m) {
+			t.Errorf("got %q, want %q", got, m)
+		}
+	}
+
+	type domain struct {
+		Value string `plist:"com.apple/with spaces & <brackets>"`
+	}
+	out, err = Marshal(domain{"x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(out, []byte(escaped)) {
+		t.Errorf("expected %s in\n%s", escaped, out)
+	}
+	var d domain
+	if err := Unmarshal(out, &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Value != "x" {
+		t.Errorf("got %q, want x", d.Value)
+	}
+}
+
+func TestInlineKeys(t *testing.T) {
+	t.Parallel()
+
+	type child struct {
+		Name string
+	}
+	type document struct {
+		Name     string
+		Enabled  bool
+		Children []child
+		Empty    map[string]string
+	}
+	v := document{"root", true, []child{{"a"}}, map[string]string{}}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.Indent("\t")
+	enc.InlineKeys(true)
+	if err := enc.Encode(v); err != nil {
+		t.Fatal(err)
+	}
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+	<dict>
+		<key>Children</key><array>
+			<dict>
+				<key>Name</key><string>a</string>
+			</dict>
+		</array>
+		<key>Empty</key><dict></dict>
+		<key>Enabled</key><true/>
+		<key>Name</key><string>root</string>
+	</dict>
+</plist>
+`
+	if buf.String() != expected {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), expected)
+	}
+
+	var got document
+	if err := Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, v) {
+		t.Errorf("got %+v, want %+v", got, v)
+	}
+}
+
+func TestSetMinIntegerWidth(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		in    interface{}
+		width int
+		want  []byte // the encoded root object
+	}{
+		{1, 1, []byte{0x10, 0x01}},
+		{1, 2, []byte{0x11, 0x00, 0x01}},
+		{1, 4, []byte{0x12, 0, 0, 0, 0x01}},
+		{1, 8, []byte{0x13, 0, 0, 0, 0, 0, 0, 0, 0x01}},
+		{uint32(math.MaxUint32), 2, []byte{0x12, 0xff, 0xff, 0xff, 0xff}},
+		{-1, 2, []byte{0x13, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		enc := NewBinaryEncoder(&buf)
+		enc.SetMinIntegerWidth(tt.width)
+		if err := enc.Encode(tt.in); err != nil {
+			t.Fatalf("%v: %v", tt.in, err)
+		}
+		out := buf.Bytes()
+		if got := out[8 : 8+len(tt.want)]; !bytes.Equal(got, tt.want) {
+			t.Errorf("%v with width %d: got % x, want % x", tt.in, tt.width, got, tt.want)
+		}
+		var got int64
+		if err := Unmarshal(out, &got); err != nil {
+			t.Fatal(err)
+		}
+		if want := reflect.ValueOf(tt.in).Convert(reflect.TypeOf(got)).Int(); got != want {
+			t.Errorf("got %d, want %d", got, want)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for width 3")
+		}
+	}()
+	NewBinaryEncoder(ioutil.Discard).SetMinIntegerWidth(3)
+}
+
+func TestMarshalHash(t *testing.T) {
+	t.Parallel()
+
+	var ordered Dict
+	ordered.Set("b", float32(1.5))
+	ordered.Set("a", []string{"x"})
+	same := []interface{}{
+		map[string]interface{}{"a": []interface{}{"x"}, "b": 1.5},
+		&ordered,
+		struct {
+			B float64  `plist:"b"`
+			A []string `plist:"a"`
+		}{1.5, []string{"x"}},
+	}
+	for _, format := range []Format{FormatXML, FormatBinary} {
+		var sums []string
+		for _, v := range same {
+			h := sha256.New()
+			if err := MarshalHash(v, h, format); err != nil {
+				t.Fatal(err)
+			}
+			sums = append(sums, hex.EncodeToString(h.Sum(nil)))
+		}
+		for i, sum := range sums {
+			if sum != sums[0] {
+				t.Errorf("%v: hash of %#v differs from hash of %#v", format, same[i], same[0])
+			}
+		}
+
+		h := sha256.New()
+		if err := MarshalHash(map[string]interface{}{"a": []interface{}{"x"}, "b": 2.5}, h, format); err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(h.Sum(nil)) == sums[0] {
+			t.Errorf("%v: different values have the same hash", format)
+		}
+	}
+
+	h := sha256.New()
+	if err := MarshalHash(same[0], h, FormatXML); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := Marshal(same[0])
+	canonical, _ := Canonicalize(data)
+	if want := sha256.Sum256(canonical); !bytes.Equal(h.Sum(nil), want[:]) {
+		t.Error("XML hash isn't the hash of the canonical document")
+	}
+}
+
+// testPort is a port number checked by a registered validator.
+type testPort int
+
+func init() {
+	RegisterValidator(reflect.TypeOf(testPort(0)), func(v interface{}) error {
+		if p := v.(testPort); p < 1 || p > 65535 {
+			return fmt.Errorf("port %d out of range", p)
+		}
+		return nil
+	})
+}
+
+func TestEncodeValidation(t *testing.T) {
+	t.Parallel()
+
+	type proxy struct {
+		Host string   `plist:"Host,required"`
+		Port testPort `plist:"Port"`
+	}
+	type payload struct {
+		PayloadType string  `plist:"PayloadType,omitempty,required"`
+		Mode        string  `plist:"Mode,enum=auto|manual"`
+		Level       int     `plist:"Level,omitempty,enum=1|2|3"`
+		Proxies     []proxy `plist:"Proxies,omitempty"`
+		Proxy       *proxy  `plist:"Proxy,omitempty"`
+	}
+
+	tests := []struct {
+		in   interface{}
+		want string // the error, or empty for no error
+	}{
+		{payload{PayloadType: "com.example", Mode: "auto"}, ""},
+		{payload{PayloadType: "com.example", Mode: "manual", Level: 2}, ""},
+		{payload{Mode: "auto"}, "plist: invalid value at PayloadType: required key is missing"},
+		{payload{PayloadType: "com.example", Mode: "off"}, `plist: invalid value at Mode: "off" is not one of auto, manual`},
+		{payload{PayloadType: "com.example", Mode: "auto", Level: 4}, `plist: invalid value at Level: "4" is not one of 1, 2, 3`},
+		{
+			payload{PayloadType: "com.example", Mode: "auto", Proxies: []proxy{{Host: "a", Port: 80}, {Host: "b", Port: 0}}},
+			"plist: invalid value at Proxies.1.Port: port 0 out of range",
+		},
+		{
+			map[string]interface{}{"Proxy": &proxy{Host: "a", Port: 0}},
+			"plist: invalid value at Proxy.Port: port 0 out of range",
+		},
+		{
+			struct {
+				Proxy *proxy `plist:"Proxy,required"`
+			}{},
+			"plist: invalid value at Proxy: required key is missing",
+		},
+		{testPort(70000), "plist: invalid value: port 70000 out of range"},
+		// Nil pointers in interfaces aren't validated, they are left out.
+		{map[string]interface{}{"Port": (*testPort)(nil)}, ""},
+		{struct{ Port interface{} }{(*testPort)(nil)}, ""},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetValidation(true)
+		err := enc.Encode(tt.in)
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%+v: %v", tt.in, err)
+			}
+			continue
+		}
+		var verr *ValidationError
+		if !errors.As(err, &verr) {
+			t.Errorf("%+v: got error %v, want a *ValidationError", tt.in, err)
+			continue
+		}
+		if err.Error() != tt.want {
+			t.Errorf("%+v: got error %q, want %q", tt.in, err, tt.want)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("%+v: wrote %q for an invalid value", tt.in, buf.String())
+		}
+	}
+
+	// Without validation, the same values are encoded.
+	if _, err := Marshal(payload{Mode: "off", Proxy: &proxy{Port: 0}}); err != nil {
+		t.Errorf("Marshal without validation: %v", err)
+	}
+}
+
+func TestSetValueTransform(t *testing.T) {
+	t.Parallel()
+
+	redact := func(path []string, v interface{}) interface{} {
+		if len(path) == 0 {
+			return v
+		}
+		switch path[len(path)-1] {
+		case "Password":
+			return "REDACTED"
+		case "Certificate":
+			return nil
+		}
+		return v
+	}
+	decode := func(data []byte) interface{} {
+		var got interface{}
+		if err := Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	encode := func(v interface{}) []byte {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		enc.SetValueTransform(redact)
+		if err := enc.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	type account struct {
+		User        string `plist:"User"`
+		Password    string `plist:"Password"`
+		Certificate []byte `plist:"Certificate,hex"`
+	}
+	type profile struct {
+		Accounts []account `plist:"Accounts"`
+		Secret   string    `plist:"Settings.Password,path"`
+	}
+	want := map[string]interface{}{
+		"Accounts": []interface{}{
+			map[string]interface{}{"User": "a", "Password": "REDACTED"},
+			map[string]interface{}{"User": "b", "Password": "REDACTED"},
+		},
+		"Settings": map[string]interface{}{"Password": "REDACTED"},
+	}
+
+	in := profile{
+		Accounts: []account{
+			{User: "a", Password: "secret", Certificate: []byte{1, 2}},
+			{User: "b", Password: "hunter2", Certificate: []byte{3}},
+		},
+		Secret: "s3cret",
+	}
+	if got := decode(encode(in)); !reflect.DeepEqual(got, want) {
+		t.Errorf("struct: got %v, want %v", got, want)
+	}
+	if in.Accounts[0].Password != "secret" {
+		t.Error("transform changed the encoded value")
+	}
+
+	tree := decode(encode(map[string]interface{}{
+		"Accounts": []interface{}{
+			map[string]interface{}{"User": "a", "Password": "secret", "Certificate": []byte{1, 2}},
+			map[string]interface{}{"User": "b", "Password": "hunter2"},
+		},
+		"Settings": map[string]interface{}{"Password": "s3cret"},
+	}))
+	if !reflect.DeepEqual(tree, want) {
+		t.Errorf("tree: got %v, want %v", tree, want)
+	}
+
+	var paths []string
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetValueTransform(func(path []string, v interface{}) interface{} {
+		paths = append(paths, strings.Join(path, "/"))
+		return v
+	})
+	if err := enc.Encode(profile{Accounts: []account{{User: "a"}}}); err != nil {
+		t.Fatal(err)
+	}
+	wantPaths := []string{
+		"",
+		"Accounts",
+		"Accounts/0",
+		"Accounts/0/User",
+		"Accounts/0/Password",
+		"Accounts/0/Certificate",
+		"Settings/Password",
+	}
+	if !reflect.DeepEqual(paths, wantPaths) {
+		t.Errorf("got paths %q, want %q", paths, wantPaths)
+	}
+}
+
+func TestSetMaxBytes(t *testing.T) {
+	t.Parallel()
+
+	large := make([]string, 10000)
+	for i := range large {
+		large[i] = "a value long enough to matter"
+	}
+	small := []string{"small"}
+	for _, format := range []Format{FormatXML, FormatBinary} {
+		var buf bytes.Buffer
+		enc := NewEncoder(&buf)
+		if format == FormatBinary {
+			enc = NewBinaryEncoder(&buf)
+		}
+		enc.SetMaxBytes(1000)
+		if err := enc.Encode(large); !errors.Is(err, ErrMaxBytes) {
+			t.Errorf("%v: got error %v, want %v", format, err, ErrMaxBytes)
+		}
+		if buf.Len() != 1000 {
+			t.Errorf("%v: wrote %d bytes, want 1000", format, buf.Len())
+		}
+
+		// A document that fits is written in full, and the limit applies to
+		// each document.
+		want, err := EncodedLen(small, format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		enc.SetMaxBytes(int64(want))
+		for i := 0; i < 2; i++ {
+			buf.Reset()
+			if err := enc.Encode(small); err != nil {
+				t.Errorf("%v: %v", format, err)
+			}
+			if buf.Len() != want {
+				t.Errorf("%v: wrote %d bytes, want %d", format, buf.Len(), want)
+			}
+		}
+	}
+}
+
+type testLevel int
+
+func (l testLevel) String() string {
+	return [...]string{"low", "high"}[l]
+}
+
+type testMode struct{ name string }
+
+func (m *testMode) String() string { return m.name }
+
+func TestUseStringer(t *testing.T) {
+	t.Parallel()
+
+	v := struct {
+		Level testLevel   `plist:"level"`
+		Mode  testMode    `plist:"mode"`
+		Err   interface{} `plist:"err"`
+		When  time.Time   `plist:"when"`
+	}{
+		Level: 1,
+		Mode:  testMode{"auto"},
+		Err:   errors.New("failed"),
+		When:  time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.UseStringer(true)
+	if err := enc.Encode(&v); err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"level": "high",
+		"mode":  "auto",
+		"err":   "failed",
+		"when":  v.When,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Without UseStringer the values keep their usual encoding.
+	data, err := Marshal(struct {
+		Level testLevel `plist:"level"`
+	}{1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte("<integer>1</integer>")) {
+		t.Errorf("expected an integer in %s", data)
+	}
+}
@@ -0,0 +1,79 @@
+package plist
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// A FieldNameFunc returns the dict key of the struct field f, which has no
+// name in a plist tag. It returns "" to use the name of the field, like
+// fields of unregistered types, or "-" to leave the field out.
+type FieldNameFunc func(f reflect.StructField) string
+
+var fieldNamers = struct {
+	sync.RWMutex
+	types  map[reflect.Type]FieldNameFunc
+	ifaces []reflect.Type // the interface types of types, in registration order
+}{types: make(map[reflect.Type]FieldNameFunc)}
+
+// RegisterFieldNames records fn as the source of the dict keys of the fields
+// of the struct type t that have no name in a plist tag, for both encoding
+// and decoding. This lets structs that follow the naming conventions of
+// another package, like types generated from a schema, be used without
+// adding plist tags or writing a mapping layer. Fields named by fn are
+// treated like fields with a named plist tag, and other tag options of the
+// field, like `plist:",omitempty"`, still apply.
+//
+// If t is an interface type, fn applies to every struct type that implements
+// it, or whose pointer does, unless the struct type is registered itself. For
+// example, the protobuf field names of generated messages can be their keys
+// with:
+//
+//	messageType := reflect.TypeOf((*proto.Message)(nil)).Elem()
+//	plist.RegisterFieldNames(messageType, func(f reflect.StructField) string {
+//		for _, opt := range strings.Split(f.Tag.Get("protobuf"), ",") {
+//			if strings.HasPrefix(opt, "name=") {
+//				return strings.TrimPrefix(opt, "name=")
+//			}
+//		}
+//		return "-" // not a message field
+//	})
+//
+// The fields of a type are worked out the first time it is encoded or
+// decoded and kept from then on, so RegisterFieldNames must be called before
+// that, typically from an init function. It panics if t is nil or neither a
+// struct nor an interface type, if fn is nil, or if t is registered twice.
+func RegisterFieldNames(t reflect.Type, fn FieldNameFunc) {
+	if t == nil || fn == nil {
+		panic("plist: RegisterFieldNames of nil type or function")
+	}
+	if t.Kind() != reflect.Struct && t.Kind() != reflect.Interface {
+		panic(fmt.Sprintf("plist: RegisterFieldNames of %v, which is neither a struct nor an interface", t))
+	}
+	fieldNamers.Lock()
+	defer fieldNamers.Unlock()
+	if _, ok := fieldNamers.types[t]; ok {
+		panic(fmt.Sprintf("plist: RegisterFieldNames called twice for %v", t))
+	}
+	fieldNamers.types[t] = fn
+	if t.Kind() == reflect.Interface {
+		fieldNamers.ifaces = append(fieldNamers.ifaces, t)
+	}
+}
+
+// fieldNamer returns the FieldNameFunc registered for the struct type t, or
+// nil.
+func fieldNamer(t reflect.Type) FieldNameFunc {
+	fieldNamers.RLock()
+	defer fieldNamers.RUnlock()
+	if fn, ok := fieldNamers.types[t]; ok {
+		return fn
+	}
+	for _, iface := range fieldNamers.ifaces {
+		if t.Implements(iface) || reflect.PtrTo(t).Implements(iface) {
+			return fieldNamers.types[iface]
+		}
+	}
+	return nil
+}
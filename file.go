@@ -0,0 +1,54 @@
+package plist
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+)
+
+// UnmarshalFile parses the plist file at path and stores the result in the
+// value pointed to by v.
+//
+// Binary plists are memory-mapped where the platform supports it, so that only
+// the objects reachable from the root are paged in as the offset table is
+// followed. On other platforms the file is read into memory instead. XML plists
+// are streamed from the file.
+func UnmarshalFile(path string, v interface{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	magic, err := br.Peek(len(binaryMagic))
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if !bytes.Equal(magic, binaryMagic) {
+		return NewXMLDecoder(br).Decode(v)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	data, release, err := mapFile(f, info.Size())
+	if err != nil {
+		return fmt.Errorf("plist: couldn't map %s: %v", path, err)
+	}
+	defer release()
+	return NewBinaryDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// readFile reads the first size bytes of f into memory. It is the fallback
+// used by mapFile when the file can't be memory-mapped.
+func readFile(f *os.File, size int64) ([]byte, func() error, error) {
+	data := make([]byte, size)
+	if _, err := f.ReadAt(data, 0); err != nil && err != io.EOF {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
@@ -0,0 +1,37 @@
+package plist
+
+import "reflect"
+
+// A struct field of type Format tagged with the format option, like
+// `plist:",format"`, isn't a dict key. When decoding, it is set to the format
+// of the document the struct is decoded from, and when encoding, it is left
+// out, so that tools rewriting a plist can pick the encoder that matches the
+// original:
+//
+//	var p struct {
+//		Name   string
+//		Format plist.Format `plist:",format"`
+//	}
+//	err := plist.Unmarshal(data, &p)
+//	...
+//	enc := plist.NewEncoder(w)
+//	if p.Format == plist.FormatBinary {
+//		enc = plist.NewBinaryEncoder(w)
+//	}
+//	err = enc.Encode(&p)
+
+var formatType = reflect.TypeOf(Format(0))
+
+// Format returns the format of the plists the decoder reads.
+func (d *Decoder) Format() Format {
+	if d.isBinary {
+		return FormatBinary
+	}
+	return FormatXML
+}
+
+// isFormatField reports whether the struct field sf holds the format of the
+// document.
+func isFormatField(sf reflect.StructField, opts tagOptions) bool {
+	return sf.Type == formatType && opts.Contains("format")
+}
@@ -0,0 +1,35 @@
+package plist
+
+import (
+	"encoding/hex"
+	"fmt"
+	"reflect"
+)
+
+// Struct fields of type []byte tagged with the hex option, like
+// `plist:"key,hex"`, are stored as a <string> of hexadecimal digits instead
+// of <data>. A <data> still decodes into such a field.
+
+// hexValue returns the string plistValue holding the hex encoding of the
+// bytes in v, or nil if v isn't a []byte.
+func hexValue(v reflect.Value) *plistValue {
+	if v.Kind() != reflect.Slice || v.Type().Elem().Kind() != reflect.Uint8 {
+		return nil
+	}
+	return &plistValue{String, hex.EncodeToString(v.Bytes())}
+}
+
+// unmarshalHex decodes a string of hexadecimal digits into a []byte, and
+// reports whether v is one.
+func (d *Decoder) unmarshalHex(pval *plistValue, v reflect.Value) (bool, error) {
+	if v.Kind() != reflect.Slice || v.Type().Elem().Kind() != reflect.Uint8 {
+		return false, nil
+	}
+	s := pval.value.(string)
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return true, fmt.Errorf("plist: can't decode %q as hex: %v", s, err)
+	}
+	v.SetBytes(b)
+	return true, nil
+}
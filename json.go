@@ -0,0 +1,302 @@
+package plist
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// ToJSON and ToTypedJSON convert plists to JSON, and FromJSON converts JSON
+// back to plists.
+//
+// ToJSON writes the JSON a plist would naturally map to: strings, integers,
+// booleans, arrays and dicts as their JSON counterparts, reals as numbers,
+// dates as RFC 3339 strings, data as base64 strings and UIDs as numbers. The
+// types of dates, data, UIDs and reals are lost on the way.
+//
+// ToTypedJSON keeps them, so that FromJSON converts its output back to the
+// same plist. Values of these types are written as an object tagged with the
+// key "$plist", whose value names the type, and "value":
+//
+//	{"$plist": "date", "value": "2006-01-02T15:04:05Z"}  // RFC 3339, in UTC
+//	{"$plist": "data", "value": "AAEC"}                  // standard base64
+//	{"$plist": "uid", "value": 7}
+//	{"$plist": "real", "value": 1.5}                     // or "inf", "-inf", "nan"
+//
+// A dict that has a "$plist" key of its own is written as
+// {"$plist": "dict", "value": {...}}, so that it can't be mistaken for a tag.
+//
+// FromJSON accepts both: objects tagged with "$plist" are converted to the
+// value they describe, and other JSON numbers are integers if they are
+// written without a fraction or exponent and fit in 64 bits, and reals
+// otherwise. JSON has no null, so null is an error.
+
+// jsonTag is the key of the objects that describe typed values.
+const jsonTag = "$plist"
+
+// ToJSON converts the plist document data, in XML or binary format, to JSON.
+func ToJSON(data []byte) ([]byte, error) {
+	return toJSON(data, false)
+}
+
+// ToTypedJSON converts the plist document data, in XML or binary format, to
+// JSON that keeps the types JSON lacks, so that FromJSON can convert it back.
+func ToTypedJSON(data []byte) ([]byte, error) {
+	return toJSON(data, true)
+}
+
+func toJSON(data []byte, typed bool) ([]byte, error) {
+	var d *Decoder
+	if bytes.HasPrefix(data, binaryMagic) {
+		d = NewBinaryDecoder(bytes.NewReader(data))
+	} else {
+		d = NewXMLDecoder(bytes.NewReader(data))
+	}
+	pval, err := d.parse()
+	if err != nil {
+		return nil, err
+	}
+	v, err := jsonValue(pval, typed)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// jsonValue returns the value encoding/json encodes as the JSON of pval.
+func jsonValue(pval *plistValue, typed bool) (interface{}, error) {
+	tag := func(kind string, value interface{}) interface{} {
+		return map[string]interface{}{jsonTag: kind, "value": value}
+	}
+	switch pval.kind {
+	case String:
+		return pval.value.(string), nil
+	case Boolean:
+		return pval.value.(bool), nil
+	case Integer:
+		i := pval.value.(signedInt)
+		if i.signed {
+			return json.Number(strconv.FormatInt(int64(i.value), 10)), nil
+		}
+		return json.Number(strconv.FormatUint(i.value, 10)), nil
+	case Real:
+		f := pval.value.(sizedFloat).value
+		var value interface{}
+		switch {
+		case math.IsInf(f, 1):
+			value = "inf"
+		case math.IsInf(f, -1):
+			value = "-inf"
+		case math.IsNaN(f):
+			value = "nan"
+		default:
+			value = json.Number(strconv.FormatFloat(f, 'g', -1, 64))
+		}
+		if typed {
+			return tag("real", value), nil
+		}
+		if _, ok := value.(string); ok {
+			return nil, fmt.Errorf("plist: real %v can't be converted to JSON", f)
+		}
+		return value, nil
+	case Date:
+		var text string
+		if date, ok := pval.value.(time.Time); ok {
+			text = date.UTC().Format(time.RFC3339Nano)
+		} else {
+			text = pval.value.(string)
+		}
+		if typed {
+			return tag("date", text), nil
+		}
+		return text, nil
+	case Data:
+		text := base64.StdEncoding.EncodeToString(pval.value.([]byte))
+		if typed {
+			return tag("data", text), nil
+		}
+		return text, nil
+	case UIDKind:
+		n := json.Number(strconv.FormatUint(uint64(pval.value.(UID)), 10))
+		if typed {
+			return tag("uid", n), nil
+		}
+		return n, nil
+	case Array:
+		values := pval.value.([]*plistValue)
+		array := make([]interface{}, len(values))
+		for i, sval := range values {
+			v, err := jsonValue(sval, typed)
+			if err != nil {
+				return nil, err
+			}
+			array[i] = v
+		}
+		return array, nil
+	case Dictionary:
+		dict := pval.value.(*dictionary)
+		obj := make(map[string]interface{}, len(dict.m))
+		for k, sval := range dict.m {
+			v, err := jsonValue(sval, typed)
+			if err != nil {
+				return nil, err
+			}
+			obj[k] = v
+		}
+		if _, ok := obj[jsonTag]; ok && typed {
+			return tag("dict", obj), nil
+		}
+		return obj, nil
+	default:
+		return nil, fmt.Errorf("plist: %v can't be converted to JSON", pval.kind)
+	}
+}
+
+// FromJSON converts the JSON document data, as written by ToJSON or
+// ToTypedJSON, to a plist document in format.
+func FromJSON(data []byte, format Format) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	pval, err := fromJSONValue(v)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	switch format {
+	case FormatXML:
+		err = newXMLEncoder(&buf).generateDocument(pval)
+	case FormatBinary:
+		err = newBinaryEncoder(&buf).generateDocument(pval)
+	default:
+		return nil, fmt.Errorf("plist: unknown format %v", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// fromJSONValue returns the plistValue of v, a value decoded by encoding/json
+// with UseNumber.
+func fromJSONValue(v interface{}) (*plistValue, error) {
+	switch v := v.(type) {
+	case string:
+		return &plistValue{String, v}, nil
+	case bool:
+		return &plistValue{Boolean, v}, nil
+	case json.Number:
+		if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return &plistValue{Integer, signedInt{uint64(n), n < 0, false}}, nil
+		}
+		if n, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return &plistValue{Integer, signedInt{n, false, false}}, nil
+		}
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			return nil, fmt.Errorf("plist: invalid JSON number %s", v)
+		}
+		return &plistValue{Real, sizedFloat{f, 64, ""}}, nil
+	case []interface{}:
+		values := make([]*plistValue, len(v))
+		for i, elem := range v {
+			pval, err := fromJSONValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = pval
+		}
+		return &plistValue{Array, values}, nil
+	case map[string]interface{}:
+		if _, ok := v[jsonTag]; ok {
+			return fromTaggedJSON(v)
+		}
+		return fromJSONObject(v)
+	case nil:
+		return nil, errors.New("plist: JSON null can't be converted to a plist")
+	default:
+		return nil, fmt.Errorf("plist: unexpected JSON value %T", v)
+	}
+}
+
+// fromJSONObject returns the dict of the JSON object obj.
+func fromJSONObject(obj map[string]interface{}) (*plistValue, error) {
+	dict := &dictionary{m: make(map[string]*plistValue, len(obj))}
+	for k, elem := range obj {
+		pval, err := fromJSONValue(elem)
+		if err != nil {
+			return nil, err
+		}
+		dict.m[k] = pval
+	}
+	return &plistValue{Dictionary, dict}, nil
+}
+
+// fromTaggedJSON returns the plistValue described by an object tagged with
+// jsonTag.
+func fromTaggedJSON(obj map[string]interface{}) (*plistValue, error) {
+	kind, _ := obj[jsonTag].(string)
+	value, ok := obj["value"]
+	if !ok || len(obj) != 2 {
+		return nil, fmt.Errorf("plist: JSON object tagged with %s must only have a value", jsonTag)
+	}
+	invalid := func() error {
+		return fmt.Errorf("plist: invalid value for JSON %s %q: %v", jsonTag, kind, value)
+	}
+	switch kind {
+	case "date":
+		text, _ := value.(string)
+		date, err := time.Parse(time.RFC3339Nano, text)
+		if err != nil {
+			return nil, invalid()
+		}
+		return &plistValue{Date, date}, nil
+	case "data":
+		text, _ := value.(string)
+		data, err := base64.StdEncoding.DecodeString(text)
+		if err != nil {
+			return nil, invalid()
+		}
+		return &plistValue{Data, data}, nil
+	case "uid":
+		n, _ := value.(json.Number)
+		uid, err := strconv.ParseUint(string(n), 10, 64)
+		if err != nil {
+			return nil, invalid()
+		}
+		return &plistValue{UIDKind, UID(uid)}, nil
+	case "real":
+		var f float64
+		switch value {
+		case "inf":
+			f = math.Inf(1)
+		case "-inf":
+			f = math.Inf(-1)
+		case "nan":
+			f = math.NaN()
+		default:
+			n, _ := value.(json.Number)
+			var err error
+			if f, err = strconv.ParseFloat(string(n), 64); err != nil {
+				return nil, invalid()
+			}
+		}
+		return &plistValue{Real, sizedFloat{f, 64, ""}}, nil
+	case "dict":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, invalid()
+		}
+		return fromJSONObject(obj)
+	default:
+		return nil, fmt.Errorf("plist: unknown JSON %s type %q", jsonTag, kind)
+	}
+}
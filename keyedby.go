@@ -0,0 +1,92 @@
+package plist
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// Struct fields holding a map with string keys tagged with the keyedBy
+// option, like `plist:"items,keyedBy=id"`, are stored as an <array> of dicts
+// instead of a <dict>. When decoding, each element is stored in the map under
+// the string value of its id key. An element without the key, or whose key
+// isn't a string, is an error, and so is a key shared by two elements unless
+// the field is also tagged with lastwins, which keeps the last of them. When
+// encoding, the map values are written in the order of their map keys, and
+// must encode as dicts; the map keys themselves aren't written, so the values
+// should hold the key as one of their fields.
+
+// marshalKeyed encodes the map v of a field tagged with keyedBy as an array.
+func (e *Encoder) marshalKeyed(v reflect.Value, key string) (*plistValue, error) {
+	if v.Type().Key().Kind() != reflect.String {
+		return nil, &UnsupportedTypeError{v.Type()}
+	}
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	values := make([]*plistValue, 0, len(keys))
+	for i, k := range keys {
+		e.enterIndex(i)
+		pval, err := e.marshal(v.MapIndex(k))
+		e.leave()
+		if err != nil {
+			return nil, inPath(err, strconv.Itoa(i))
+		}
+		if pval == nil || pval.kind != Dictionary {
+			return nil, &UnsupportedValueError{v.MapIndex(k), fmt.Sprintf("map value keyed by %q is not a dict", key)}
+		}
+		values = append(values, pval)
+	}
+	return &plistValue{Array, values}, nil
+}
+
+// unmarshalKeyed decodes the array pval into the map v of a field tagged with
+// keyedBy.
+func (d *Decoder) unmarshalKeyed(f field, pval *plistValue, v reflect.Value) error {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+		return UnmarshalTypeError{describe(pval), v.Type()}
+	}
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(v.Type()))
+	}
+	seen := make(map[string]bool)
+	for i, sval := range pval.value.([]*plistValue) {
+		d.enter(strconv.Itoa(i))
+		err := d.collect(d.unmarshalKeyedElem(f, sval, v, seen))
+		d.leave()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Decoder) unmarshalKeyedElem(f field, pval *plistValue, v reflect.Value, seen map[string]bool) error {
+	if pval.kind != Dictionary {
+		return UnmarshalTypeError{describe(pval), v.Type().Elem()}
+	}
+	kval, ok := pval.value.(*dictionary).m[f.keyedBy]
+	if !ok {
+		return fmt.Errorf("plist: array element has no %q key to index %v by", f.keyedBy, v.Type())
+	}
+	if kval.kind != String {
+		return fmt.Errorf("plist: %q key of array element is %s, not a string", f.keyedBy, describe(kval))
+	}
+	key := kval.value.(string)
+	if seen[key] && !f.lastWins {
+		return fmt.Errorf("plist: duplicate %q key %q in array", f.keyedBy, key)
+	}
+	seen[key] = true
+	elem := reflect.New(v.Type().Elem()).Elem()
+	if err := d.unmarshal(pval, elem); err != nil {
+		return err
+	}
+	v.SetMapIndex(reflect.ValueOf(key).Convert(v.Type().Key()), elem)
+	return nil
+}
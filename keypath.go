@@ -0,0 +1,99 @@
+package plist
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Struct fields tagged with the path option, like
+// `plist:"PayloadContent.0.PayloadUUID,path"`, hold a value nested below the
+// dictionary of the struct. The path is split at dots; each element is a
+// dictionary key, or a decimal index when the value it applies to is an array.
+// Without the path option a dotted name is a plain key, as used by keys like
+// com.apple.example.
+
+// lookupPath returns the value found by following path from pval, or false if
+// a value along the path is missing or isn't a collection.
+func lookupPath(pval *plistValue, path []string) (*plistValue, bool) {
+	for _, elem := range path {
+		switch pval.kind {
+		case Dictionary:
+			sub, ok := pval.value.(*dictionary).m[elem]
+			if !ok {
+				return nil, false
+			}
+			pval = sub
+		case Array:
+			values := pval.value.([]*plistValue)
+			n, err := strconv.Atoi(elem)
+			if err != nil || n < 0 || n >= len(values) {
+				return nil, false
+			}
+			pval = values[n]
+		default:
+			return nil, false
+		}
+	}
+	return pval, true
+}
+
+// insertPath stores value at path below dict, creating the dictionaries and
+// arrays along the path that don't exist yet. A created value is an array if
+// the element after it is a decimal index, and a dictionary otherwise. Arrays
+// are only grown at their end, so an index may at most be the length of the
+// array it applies to.
+func insertPath(dict *dictionary, path []string, value *plistValue) error {
+	parent := &plistValue{Dictionary, dict}
+	for i, elem := range path {
+		child := value
+		if i < len(path)-1 {
+			if existing, ok := childValue(parent, elem); ok {
+				parent = existing
+				continue
+			}
+			if _, err := strconv.Atoi(path[i+1]); err == nil {
+				child = &plistValue{Array, []*plistValue{}}
+			} else {
+				child = &plistValue{Dictionary, &dictionary{
+					m:       make(map[string]*plistValue),
+					ordered: dict.ordered,
+				}}
+			}
+		}
+		if err := setChild(parent, elem, child); err != nil {
+			return fmt.Errorf("plist: can't store value at path %q: %v", strings.Join(path, "."), err)
+		}
+		parent = child
+	}
+	return nil
+}
+
+// childValue returns the value elem of a dictionary or array.
+func childValue(parent *plistValue, elem string) (*plistValue, bool) {
+	return lookupPath(parent, []string{elem})
+}
+
+// setChild stores child as the value elem of a dictionary or array. Arrays are
+// grown by one value when elem is their length.
+func setChild(parent *plistValue, elem string, child *plistValue) error {
+	switch parent.kind {
+	case Dictionary:
+		parent.value.(*dictionary).set(elem, child)
+		return nil
+	case Array:
+		values := parent.value.([]*plistValue)
+		n, err := strconv.Atoi(elem)
+		if err != nil || n < 0 || n > len(values) {
+			return fmt.Errorf("invalid array index %s", elem)
+		}
+		if n == len(values) {
+			parent.value = append(values, child)
+		} else {
+			values[n] = child
+		}
+		return nil
+	default:
+		return fmt.Errorf("%s is not a dictionary or array", parent.kind)
+	}
+}
@@ -0,0 +1,114 @@
+package plist
+
+import (
+	"errors"
+	"sort"
+)
+
+// A Merger deep-merges decoded plist dictionaries, such as the
+// map[string]interface{} values produced by decoding into an empty interface
+// and the *Dict values produced by decoding into a Dict.
+type Merger struct {
+	// ConcatArrays makes arrays present in both dictionaries merge into an
+	// array of the base elements followed by the override elements. By
+	// default the override array replaces the base array.
+	ConcatArrays bool
+}
+
+// Merge is shorthand for Merger{}.Merge.
+func Merge(base, override interface{}) (interface{}, error) {
+	return Merger{}.Merge(base, override)
+}
+
+// Merge returns the result of merging override into base, which must both be
+// dictionaries: a map[string]interface{}, a Dict or a *Dict. Keys only
+// present in one of them are copied over. For keys present in both,
+// dictionary values are merged recursively, arrays are handled as set by
+// ConcatArrays, and any other value in override, including one of a different
+// type than in base, replaces the base value.
+//
+// A merged dictionary has the type of the base dictionary, except that a Dict
+// gives a *Dict. Merging into a Dict keeps the order of its keys, with the
+// keys only present in override added after them, in the order of override
+// if it is a Dict and sorted otherwise.
+//
+// Neither base nor override is modified, though values that aren't merged are
+// shared with the result rather than copied.
+func (m Merger) Merge(base, override interface{}) (interface{}, error) {
+	if _, _, ok := dictEntries(base); !ok {
+		return nil, errors.New("plist: Merge base is not a dictionary")
+	}
+	if _, _, ok := dictEntries(override); !ok {
+		return nil, errors.New("plist: Merge override is not a dictionary")
+	}
+	return m.mergeValues(base, override), nil
+}
+
+func (m Merger) mergeValues(base, override interface{}) interface{} {
+	if keys, get, ok := dictEntries(override); ok {
+		switch b := base.(type) {
+		case map[string]interface{}:
+			out := make(map[string]interface{}, len(b)+len(keys))
+			for k, v := range b {
+				out[k] = v
+			}
+			for _, k := range keys {
+				out[k] = m.mergeValues(out[k], get(k))
+			}
+			return out
+		case Dict:
+			return m.mergeOrdered(&b, keys, get)
+		case *Dict:
+			if b != nil {
+				return m.mergeOrdered(b, keys, get)
+			}
+		}
+	}
+	if o, ok := override.([]interface{}); ok && m.ConcatArrays {
+		if b, ok := base.([]interface{}); ok {
+			out := make([]interface{}, 0, len(b)+len(o))
+			return append(append(out, b...), o...)
+		}
+	}
+	return override
+}
+
+// mergeOrdered merges the dictionary with the given keys and values into a
+// copy of base.
+func (m Merger) mergeOrdered(base *Dict, keys []string, get func(string) interface{}) *Dict {
+	out := &Dict{
+		keys: append([]string(nil), base.keys...),
+		m:    make(map[string]interface{}, len(base.keys)+len(keys)),
+	}
+	for k, v := range base.m {
+		out.m[k] = v
+	}
+	for _, k := range keys {
+		old, _ := out.Get(k)
+		out.Set(k, m.mergeValues(old, get(k)))
+	}
+	return out
+}
+
+// dictEntries returns the keys of the dictionary v, in order for a Dict and
+// sorted for a map, and a function returning their values. ok is false if v
+// isn't a dictionary.
+func dictEntries(v interface{}) (keys []string, get func(string) interface{}, ok bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys = make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return keys, func(k string) interface{} { return v[k] }, true
+	case Dict:
+		return v.keys, func(k string) interface{} { return v.m[k] }, true
+	case *Dict:
+		if v == nil {
+			return nil, nil, false
+		}
+		return v.keys, func(k string) interface{} { return v.m[k] }, true
+	}
+	return nil, nil, false
+}
@@ -0,0 +1,144 @@
+package plist
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	base := map[string]interface{}{
+		"name":    "base",
+		"servers": []interface{}{"a"},
+		"wifi": map[string]interface{}{
+			"ssid":   "corp",
+			"hidden": false,
+		},
+		"conflict": map[string]interface{}{"x": uint64(1)},
+	}
+	override := map[string]interface{}{
+		"servers": []interface{}{"b"},
+		"wifi": map[string]interface{}{
+			"hidden":   true,
+			"password": "secret",
+		},
+		"conflict": "replaced",
+		"extra":    uint64(2),
+	}
+
+	tests := []struct {
+		merger Merger
+		want   map[string]interface{}
+	}{
+		{Merger{}, map[string]interface{}{
+			"name":    "base",
+			"servers": []interface{}{"b"},
+			"wifi": map[string]interface{}{
+				"ssid":     "corp",
+				"hidden":   true,
+				"password": "secret",
+			},
+			"conflict": "replaced",
+			"extra":    uint64(2),
+		}},
+		{Merger{ConcatArrays: true}, map[string]interface{}{
+			"name":    "base",
+			"servers": []interface{}{"a", "b"},
+			"wifi": map[string]interface{}{
+				"ssid":     "corp",
+				"hidden":   true,
+				"password": "secret",
+			},
+			"conflict": "replaced",
+			"extra":    uint64(2),
+		}},
+	}
+	for _, tt := range tests {
+		have, err := tt.merger.Merge(base, override)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(have, tt.want) {
+			t.Errorf("%+v: have %v, want %v", tt.merger, have, tt.want)
+		}
+	}
+
+	// The inputs are left as they were.
+	if base["wifi"].(map[string]interface{})["hidden"] != false || len(base["servers"].([]interface{})) != 1 {
+		t.Errorf("base was modified: %v", base)
+	}
+
+	if _, err := Merge([]interface{}{}, override); err == nil {
+		t.Error("expected error merging an array")
+	}
+}
+
+func TestMergeDict(t *testing.T) {
+	var wifi Dict
+	wifi.Set("ssid", "corp")
+	wifi.Set("hidden", false)
+	var base Dict
+	base.Set("name", "base")
+	base.Set("wifi", &wifi)
+	base.Set("servers", []interface{}{"a"})
+
+	var overrideWifi Dict
+	overrideWifi.Set("password", "secret")
+	overrideWifi.Set("hidden", true)
+	var override Dict
+	override.Set("wifi", &overrideWifi)
+	override.Set("extra", uint64(2))
+	override.Set("servers", []interface{}{"b"})
+
+	for _, b := range []interface{}{base, &base} {
+		have, err := Merger{ConcatArrays: true}.Merge(b, &override)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, ok := have.(*Dict)
+		if !ok {
+			t.Fatalf("got %T, want *Dict", have)
+		}
+		if want := []string{"name", "wifi", "servers", "extra"}; !reflect.DeepEqual(out.Keys(), want) {
+			t.Errorf("keys: have %q, want %q", out.Keys(), want)
+		}
+		w, _ := out.Get("wifi")
+		merged, ok := w.(*Dict)
+		if !ok {
+			t.Fatalf("wifi: got %T, want *Dict", w)
+		}
+		if want := []string{"ssid", "hidden", "password"}; !reflect.DeepEqual(merged.Keys(), want) {
+			t.Errorf("wifi keys: have %q, want %q", merged.Keys(), want)
+		}
+		if hidden, _ := merged.Get("hidden"); hidden != true {
+			t.Errorf("wifi hidden: have %v, want true", hidden)
+		}
+		if servers, _ := out.Get("servers"); !reflect.DeepEqual(servers, []interface{}{"a", "b"}) {
+			t.Errorf("servers: have %v", servers)
+		}
+	}
+
+	// The inputs are left as they were.
+	if hidden, _ := wifi.Get("hidden"); hidden != false || base.Len() != 3 {
+		t.Error("base was modified")
+	}
+
+	// Maps and Dicts merge into each other, keeping the type of the base.
+	have, err := Merge(map[string]interface{}{"wifi": map[string]interface{}{"ssid": "corp"}}, &override)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := have.(map[string]interface{})
+	if !ok {
+		t.Fatalf("got %T, want a map", have)
+	}
+	if w, ok := m["wifi"].(map[string]interface{}); !ok || w["ssid"] != "corp" || w["password"] != "secret" {
+		t.Errorf("wifi: have %v", m["wifi"])
+	}
+	have, err = Merge(&base, map[string]interface{}{"z": 1, "a": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"name", "wifi", "servers", "a", "z"}; !reflect.DeepEqual(have.(*Dict).Keys(), want) {
+		t.Errorf("keys: have %q, want %q", have.(*Dict).Keys(), want)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package plist
+
+import "os"
+
+// mapFile reads the first size bytes of f into memory on platforms without
+// mmap support.
+func mapFile(f *os.File, size int64) ([]byte, func() error, error) {
+	return readFile(f, size)
+}
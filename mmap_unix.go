@@ -0,0 +1,23 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package plist
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile memory-maps the first size bytes of f read-only. The returned
+// release func unmaps the data, which must not be used afterwards.
+func mapFile(f *os.File, size int64) ([]byte, func() error, error) {
+	if int64(int(size)) != size {
+		return readFile(f, size)
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		// Some filesystems can't be mapped, so fall back to reading.
+		return readFile(f, size)
+	}
+	return data, func() error { return syscall.Munmap(data) }, nil
+}
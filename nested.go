@@ -0,0 +1,64 @@
+package plist
+
+import "bytes"
+
+// DecodeNestedPlistData sets how deeply to decode <data> values that hold
+// plists of their own, like the archived objects in files written by
+// `defaults export`. Such a value is decoded as the plist it holds wherever
+// it isn't decoded into a []byte: into an empty interface, it becomes the
+// nested plist's value, and into a struct, map or other Go value, the nested
+// plist is decoded into it. Data that holds a plist nested within such a
+// plist is decoded the same way, down to depth levels of nesting; deeper
+// data is kept as bytes. Data that doesn't start like an XML or binary plist,
+// or that fails to parse, is kept as bytes. It is off, with a depth of 0, by
+// default.
+func (d *Decoder) DecodeNestedPlistData(depth int) {
+	d.nestedDepth = depth
+}
+
+// nestedPlist returns the plist held by the data value pval, or false if it
+// doesn't hold one or nested plists aren't decoded at the current depth.
+func (d *Decoder) nestedPlist(pval *plistValue) (*plistValue, bool) {
+	if d.nestedLevel >= d.nestedDepth {
+		return nil, false
+	}
+	data := pval.value.([]byte)
+	var nested *plistValue
+	var err error
+	switch {
+	case bytes.HasPrefix(data, binaryMagic):
+		var parser *binaryParser
+		if parser, err = d.newBinaryParser(bytes.NewReader(data)); err == nil {
+			nested, err = parser.parseDocument()
+		}
+	case looksLikeXMLPlist(data):
+		nested, err = d.newXMLParser(bytes.NewReader(data)).parseDocument(nil)
+	default:
+		return nil, false
+	}
+	if err != nil {
+		return nil, false
+	}
+	return nested, true
+}
+
+// looksLikeXMLPlist reports whether data starts like an XML plist document,
+// after any byte order mark and whitespace.
+func looksLikeXMLPlist(data []byte) bool {
+	data = bytes.TrimLeft(bytes.TrimPrefix(data, []byte(utf8BOM)), " \t\r\n")
+	return bytes.HasPrefix(data, []byte("<?xml")) ||
+		bytes.HasPrefix(data, []byte("<!DOCTYPE plist")) ||
+		bytes.HasPrefix(data, []byte("<plist"))
+}
+
+// decodeNested calls decode with the plist held by the data value pval, one
+// level of nesting deeper, and reports whether it holds one.
+func (d *Decoder) decodeNested(pval *plistValue, decode func(*plistValue) error) (bool, error) {
+	nested, ok := d.nestedPlist(pval)
+	if !ok {
+		return false, nil
+	}
+	d.nestedLevel++
+	defer func() { d.nestedLevel-- }()
+	return true, decode(nested)
+}
@@ -0,0 +1,35 @@
+//go:build go1.18
+// +build go1.18
+
+package plist
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestNetipValues(t *testing.T) {
+	type network struct {
+		Addr   netip.Addr   `plist:"addr"`
+		Prefix netip.Prefix `plist:"prefix"`
+	}
+	const doc = `<plist version="1.0"><dict>
+	<key>addr</key><string>2001:db8::1</string>
+	<key>prefix</key><string>10.0.0.0/8</string>
+</dict></plist>`
+	var n network
+	if err := Unmarshal([]byte(doc), &n); err != nil {
+		t.Fatal(err)
+	}
+	if n.Addr != netip.MustParseAddr("2001:db8::1") || n.Prefix != netip.MustParsePrefix("10.0.0.0/8") {
+		t.Errorf("unexpected value %+v", n)
+	}
+	out, err := Marshal(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "<string>2001:db8::1</string>") || !strings.Contains(string(out), "<string>10.0.0.0/8</string>") {
+		t.Errorf("unexpected encoding\n%s", out)
+	}
+}
@@ -0,0 +1,67 @@
+package plist
+
+import (
+	"reflect"
+	"strconv"
+)
+
+// A Number represents a plist <integer> or <real> by its literal text.
+//
+// Decoding an integer or real into a Number stores the number without
+// converting it to a Go numeric type, so that the caller can choose how to
+// interpret it. Reals decoded from XML keep the exact text of the element.
+// Encoding a Number writes an <integer> if the literal is an integer, and a
+// <real> otherwise.
+type Number string
+
+var numberType = reflect.TypeOf(Number(""))
+
+// String returns the literal text of the number.
+func (n Number) String() string { return string(n) }
+
+// Float64 returns the number as a float64.
+func (n Number) Float64() (float64, error) {
+	return strconv.ParseFloat(string(n), 64)
+}
+
+// Int64 returns the number as an int64.
+func (n Number) Int64() (int64, error) {
+	return strconv.ParseInt(string(n), 10, 64)
+}
+
+// Uint64 returns the number as a uint64.
+func (n Number) Uint64() (uint64, error) {
+	return strconv.ParseUint(string(n), 10, 64)
+}
+
+// numberValue returns the plistValue for n, or false if n isn't a number.
+func numberValue(n Number) (*plistValue, bool) {
+	s := string(n)
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return &plistValue{Integer, signedInt{uint64(i), i < 0, false}}, true
+	}
+	if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return &plistValue{Integer, signedInt{u, false, false}}, true
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return &plistValue{Real, sizedFloat{f, 64, s}}, true
+	}
+	return nil, false
+}
+
+// numberLiteral returns the literal text of an integer or real plistValue.
+func numberLiteral(pval *plistValue) string {
+	switch v := pval.value.(type) {
+	case signedInt:
+		if v.signed {
+			return strconv.FormatInt(int64(v.value), 10)
+		}
+		return strconv.FormatUint(v.value, 10)
+	case sizedFloat:
+		if v.literal != "" {
+			return v.literal
+		}
+		return strconv.FormatFloat(v.value, 'g', -1, v.bits)
+	}
+	return ""
+}
@@ -0,0 +1,79 @@
+package plist
+
+import (
+	"reflect"
+	"sort"
+)
+
+// OnlyKeys sets the keys of the root dictionary that Decode parses, so that a
+// few values can be extracted from a large document without materializing
+// the rest of it. The values of other keys are skipped over: the elements of
+// XML plists are read without being decoded, and the objects of binary plists
+// aren't read at all. The skipped keys are as good as absent, so they aren't
+// reported by DisallowUnknownFields and aren't stored into maps, and fields
+// tagged with required whose keys aren't listed are missing. The filter only
+// applies to the root value, and only if it is a dictionary. Calling OnlyKeys
+// with no keys, the default, parses all of them.
+//
+// StructKeys returns the keys used by a struct, to only parse those:
+//
+//	dec.OnlyKeys(plist.StructKeys(&profile)...)
+func (d *Decoder) OnlyKeys(keys ...string) {
+	if len(keys) == 0 {
+		d.onlyKeys = nil
+		return
+	}
+	d.onlyKeys = make(map[string]bool, len(keys))
+	for _, k := range keys {
+		d.onlyKeys[k] = true
+	}
+}
+
+// StructKeys returns the sorted keys of the dictionary entries that decoding
+// into v, a struct or a pointer to one, stores into its fields, following
+// the plist tags of the fields. For a field tagged with path, it is the first
+// key of the path. It returns nil if v isn't a struct, or if it has a field
+// tagged with root, which is decoded from every key.
+func StructKeys(v interface{}) []string {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+	plan := cachedDecodePlan(t)
+	if plan.root != nil {
+		return nil
+	}
+	known := plan.known
+	keys := make([]string, 0, len(known))
+	for k := range known {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// readFilteredList reads the object refs of the values of a dictionary with
+// the given keys, like readObjectList, but only decodes the objects whose key
+// is in filter, if it is set. The other elements of the list are nil.
+func (bp *binaryParser) readFilteredList(keys []*plistValue, filter map[string]bool) ([]*plistValue, error) {
+	if filter == nil {
+		return bp.readObjectList(uint64(len(keys)))
+	}
+	list := make([]*plistValue, len(keys))
+	for i, key := range keys {
+		ref, err := bp.readObjectRef()
+		if err != nil {
+			return nil, err
+		}
+		if k, ok := key.value.(string); !ok || !filter[k] {
+			continue
+		}
+		if list[i], err = bp.parseObjectRef(ref); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
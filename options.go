@@ -0,0 +1,274 @@
+package plist
+
+import (
+	"fmt"
+	"time"
+)
+
+// A DecoderOption configures a Decoder when it is created, like a call of the
+// Decoder method it is named after, so that decoders can be set up in one
+// expression:
+//
+//	dec := plist.NewXMLDecoder(r, plist.WithStrict(), plist.WithMaxElements(1e6))
+//
+// Options are applied in order, after the decoder's defaults.
+type DecoderOption func(*Decoder)
+
+// An EncoderOption configures an Encoder when it is created, like a call of
+// the Encoder method it is named after:
+//
+//	enc := plist.NewEncoder(w, plist.WithIndent("\t"), plist.WithSortKeys())
+//
+// Options are applied in order, after the encoder's defaults.
+type EncoderOption func(*Encoder)
+
+func (d *Decoder) apply(opts []DecoderOption) *Decoder {
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+func (e *Encoder) apply(opts []EncoderOption) *Encoder {
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// WithAutoDecompress enables Decoder.AutoDecompress.
+func WithAutoDecompress() DecoderOption {
+	return func(d *Decoder) { d.AutoDecompress(true) }
+}
+
+// WithConstructor sets the constructor of the decoder, see
+// Decoder.SetConstructor.
+func WithConstructor(fn ConstructorFunc) DecoderOption {
+	return func(d *Decoder) { d.SetConstructor(fn) }
+}
+
+// WithAllocator sets the allocator of the decoder, see Decoder.SetAllocator.
+func WithAllocator(a Allocator) DecoderOption {
+	return func(d *Decoder) { d.SetAllocator(a) }
+}
+
+// WithRawDates enables Decoder.RawDates.
+func WithRawDates() DecoderOption {
+	return func(d *Decoder) { d.RawDates(true) }
+}
+
+// WithSubsecondDateStrings enables Decoder.SubsecondDateStrings.
+func WithSubsecondDateStrings() DecoderOption {
+	return func(d *Decoder) { d.SubsecondDateStrings(true) }
+}
+
+// WithTimeLocation sets the location of decoded dates, see
+// Decoder.SetTimeLocation.
+func WithTimeLocation(loc *time.Location) DecoderOption {
+	return func(d *Decoder) { d.SetTimeLocation(loc) }
+}
+
+// WithStrict enables strict mode, see Decoder.SetStrict.
+func WithStrict() DecoderOption {
+	return func(d *Decoder) { d.SetStrict(true) }
+}
+
+// WithRealParser sets the function parsing invalid reals, see
+// Decoder.SetRealParser.
+func WithRealParser(fn func(string) (float64, error)) DecoderOption {
+	return func(d *Decoder) { d.SetRealParser(fn) }
+}
+
+// WithTrimStringSpace enables Decoder.TrimStringSpace.
+func WithTrimStringSpace() DecoderOption {
+	return func(d *Decoder) { d.TrimStringSpace(true) }
+}
+
+// WithMaxElements limits the number of elements of a document, see
+// Decoder.SetMaxElements.
+func WithMaxElements(n int) DecoderOption {
+	return func(d *Decoder) { d.SetMaxElements(n) }
+}
+
+// WithCaptureComments enables Decoder.CaptureComments.
+func WithCaptureComments() DecoderOption {
+	return func(d *Decoder) { d.CaptureComments(true) }
+}
+
+// WithRecordLocations enables Decoder.RecordLocations.
+func WithRecordLocations() DecoderOption {
+	return func(d *Decoder) { d.RecordLocations(true) }
+}
+
+// WithDisallowUnknownFields calls Decoder.DisallowUnknownFields.
+func WithDisallowUnknownFields() DecoderOption {
+	return func(d *Decoder) { d.DisallowUnknownFields() }
+}
+
+// WithDisallowAmbiguousKeys calls Decoder.DisallowAmbiguousKeys.
+func WithDisallowAmbiguousKeys() DecoderOption {
+	return func(d *Decoder) { d.DisallowAmbiguousKeys() }
+}
+
+// WithMergeIntoMap enables Decoder.MergeIntoMap.
+func WithMergeIntoMap() DecoderOption {
+	return func(d *Decoder) { d.MergeIntoMap(true) }
+}
+
+// WithCoerceToString enables Decoder.CoerceToString.
+func WithCoerceToString() DecoderOption {
+	return func(d *Decoder) { d.CoerceToString(true) }
+}
+
+// WithUnwrapSingleton enables Decoder.UnwrapSingleton.
+func WithUnwrapSingleton() DecoderOption {
+	return func(d *Decoder) { d.UnwrapSingleton(true) }
+}
+
+// WithCollectErrors enables Decoder.CollectErrors.
+func WithCollectErrors() DecoderOption {
+	return func(d *Decoder) { d.CollectErrors(true) }
+}
+
+// WithNestedPlistData decodes plists held in data values down to depth
+// levels, see Decoder.DecodeNestedPlistData.
+func WithNestedPlistData(depth int) DecoderOption {
+	return func(d *Decoder) { d.DecodeNestedPlistData(depth) }
+}
+
+// WithOnlyKeys only parses the given keys of the root dict, see
+// Decoder.OnlyKeys.
+func WithOnlyKeys(keys ...string) DecoderOption {
+	return func(d *Decoder) { d.OnlyKeys(keys...) }
+}
+
+// WithRootPath decodes the value at path instead of the root value, see
+// Decoder.SetRootPath.
+func WithRootPath(path ...string) DecoderOption {
+	return func(d *Decoder) { d.SetRootPath(path...) }
+}
+
+// WithRawFallback sets where documents that don't match the decoded value
+// are stored, see Decoder.SetRawFallback.
+func WithRawFallback(raw *RawValue) DecoderOption {
+	return func(d *Decoder) { d.SetRawFallback(raw) }
+}
+
+// WithReplaceInvalidUTF8 enables Decoder.ReplaceInvalidUTF8.
+func WithReplaceInvalidUTF8() DecoderOption {
+	return func(d *Decoder) { d.ReplaceInvalidUTF8(true) }
+}
+
+// WithFormat sets the format the encoder writes, so that NewEncoder(w,
+// WithFormat(FormatBinary)) is the same as NewBinaryEncoder(w). WithFormat
+// panics if format is unknown.
+func WithFormat(format Format) EncoderOption {
+	switch format {
+	case FormatXML, FormatBinary:
+	default:
+		panic(fmt.Sprintf("plist: unknown format %v", format))
+	}
+	return func(e *Encoder) { e.isBinary = format == FormatBinary }
+}
+
+// WithIndent sets the indentation of XML output, see Encoder.Indent.
+func WithIndent(indent string) EncoderOption {
+	return func(e *Encoder) { e.Indent(indent) }
+}
+
+// WithSortKeys enables Encoder.SortKeys.
+func WithSortKeys() EncoderOption {
+	return func(e *Encoder) { e.SortKeys(true) }
+}
+
+// WithPreserveFieldOrder enables Encoder.PreserveFieldOrder.
+func WithPreserveFieldOrder() EncoderOption {
+	return func(e *Encoder) { e.PreserveFieldOrder(true) }
+}
+
+// WithSelfClosingEmpty enables Encoder.SelfClosingEmpty.
+func WithSelfClosingEmpty() EncoderOption {
+	return func(e *Encoder) { e.SelfClosingEmpty(true) }
+}
+
+// WithInlineKeys enables Encoder.InlineKeys.
+func WithInlineKeys() EncoderOption {
+	return func(e *Encoder) { e.InlineKeys(true) }
+}
+
+// WithoutFinalNewline leaves out the newline after </plist>, see
+// Encoder.SetFinalNewline.
+func WithoutFinalNewline() EncoderOption {
+	return func(e *Encoder) { e.SetFinalNewline(false) }
+}
+
+// WithLineEnding sets the line ending of XML output, see
+// Encoder.SetLineEnding. Creating the encoder panics if ending is
+// unsupported.
+func WithLineEnding(ending string) EncoderOption {
+	return func(e *Encoder) { e.SetLineEnding(ending) }
+}
+
+// WithDataFormat sets the layout of <data> elements, see
+// Encoder.SetDataFormat.
+func WithDataFormat(f DataFormat) EncoderOption {
+	return func(e *Encoder) { e.SetDataFormat(f) }
+}
+
+// WithSubsecondDates enables Encoder.SubsecondDates.
+func WithSubsecondDates() EncoderOption {
+	return func(e *Encoder) { e.SubsecondDates(true) }
+}
+
+// WithBOM enables Encoder.SetBOM.
+func WithBOM() EncoderOption {
+	return func(e *Encoder) { e.SetBOM(true) }
+}
+
+// WithMinIntegerWidth sets the minimum width of binary integers, see
+// Encoder.SetMinIntegerWidth. Creating the encoder panics if width is
+// unsupported.
+func WithMinIntegerWidth(width int) EncoderOption {
+	return func(e *Encoder) { e.SetMinIntegerWidth(width) }
+}
+
+// WithMaxBytes limits the size of each document, see Encoder.SetMaxBytes.
+func WithMaxBytes(n int64) EncoderOption {
+	return func(e *Encoder) { e.SetMaxBytes(n) }
+}
+
+// WithAllowEmptyDocument enables Encoder.AllowEmptyDocument.
+func WithAllowEmptyDocument() EncoderOption {
+	return func(e *Encoder) { e.AllowEmptyDocument(true) }
+}
+
+// WithBoolAsInteger enables Encoder.BoolAsInteger.
+func WithBoolAsInteger() EncoderOption {
+	return func(e *Encoder) { e.BoolAsInteger(true) }
+}
+
+// WithStringer enables Encoder.UseStringer.
+func WithStringer() EncoderOption {
+	return func(e *Encoder) { e.UseStringer(true) }
+}
+
+// WithTextMarshaler enables Encoder.UseTextMarshaler.
+func WithTextMarshaler() EncoderOption {
+	return func(e *Encoder) { e.UseTextMarshaler(true) }
+}
+
+// WithFieldComments enables Encoder.FieldComments.
+func WithFieldComments() EncoderOption {
+	return func(e *Encoder) { e.FieldComments(true) }
+}
+
+// WithValueTransform sets the function values are passed through before they
+// are encoded, see Encoder.SetValueTransform.
+func WithValueTransform(fn func(path []string, v interface{}) interface{}) EncoderOption {
+	return func(e *Encoder) { e.SetValueTransform(fn) }
+}
+
+// WithValidation enables Encoder.SetValidation.
+func WithValidation() EncoderOption {
+	return func(e *Encoder) { e.SetValidation(true) }
+}
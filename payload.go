@@ -0,0 +1,64 @@
+package plist
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// payloadTypeKey is the dictionary key that selects a type registered with
+// RegisterPayloadType.
+const payloadTypeKey = "PayloadType"
+
+var payloadTypes = struct {
+	sync.RWMutex
+	m map[string]reflect.Type
+}{m: make(map[string]reflect.Type)}
+
+// RegisterPayloadType records the type of proto as the Go type of dictionaries
+// whose PayloadType key holds the string name, like the payloads of a
+// configuration profile. When a dictionary is decoded into an empty interface,
+// or into an interface implemented by the registered type, a new value of that
+// type is decoded from the dictionary and stored instead of a
+// map[string]interface{}. If proto is a pointer, the stored value is a pointer
+// too. Dictionaries with an unregistered PayloadType are decoded as maps.
+//
+// RegisterPayloadType panics if proto is nil or if name is registered twice.
+// It is meant to be called from init functions.
+func RegisterPayloadType(name string, proto interface{}) {
+	t := reflect.TypeOf(proto)
+	if t == nil {
+		panic("plist: RegisterPayloadType of nil value for " + name)
+	}
+	payloadTypes.Lock()
+	defer payloadTypes.Unlock()
+	if prev, ok := payloadTypes.m[name]; ok {
+		panic(fmt.Sprintf("plist: RegisterPayloadType called twice for %s (%v and %v)", name, prev, t))
+	}
+	payloadTypes.m[name] = t
+}
+
+// payloadType returns the type registered for the PayloadType of the
+// dictionary pval, or nil if pval isn't a dictionary with a registered
+// PayloadType.
+func payloadType(pval *plistValue) reflect.Type {
+	if pval.kind != Dictionary {
+		return nil
+	}
+	name, ok := pval.value.(*dictionary).m[payloadTypeKey]
+	if !ok || name.kind != String {
+		return nil
+	}
+	payloadTypes.RLock()
+	defer payloadTypes.RUnlock()
+	return payloadTypes.m[name.value.(string)]
+}
+
+// payloadValue decodes pval into a new value of type t.
+func (d *Decoder) payloadValue(t reflect.Type, pval *plistValue) (interface{}, error) {
+	v := reflect.New(t)
+	if err := d.unmarshal(pval, v); err != nil {
+		return nil, err
+	}
+	return v.Elem().Interface(), nil
+}
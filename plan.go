@@ -0,0 +1,156 @@
+package plist
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+var unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+
+// A decodePlan holds what decoding into a Go type needs to know that depends
+// only on the type, so that it is worked out once per type rather than for
+// every value decoded. For struct types it holds the fields, the dict keys
+// they are filled from and the function decoding each of them.
+type decodePlan struct {
+	unmarshaler    bool // the type implements Unmarshaler
+	ptrUnmarshaler bool // a pointer to the type implements Unmarshaler
+	// ptrTextUnmarshaler is set if a pointer to the type implements
+	// encoding.TextUnmarshaler.
+	ptrTextUnmarshaler bool
+
+	fields  []fieldPlan
+	known   map[string]bool // the top level keys of fields, for DisallowUnknownFields
+	formats []field         // fields set to the format of the document
+	root    *field          // the field decoded from the whole value, see root.go
+}
+
+var planCache sync.Map // map[reflect.Type]*decodePlan
+
+// cachedDecodePlan returns the decodePlan of t, computing it the first time.
+func cachedDecodePlan(t reflect.Type) *decodePlan {
+	if p, ok := planCache.Load(t); ok {
+		return p.(*decodePlan)
+	}
+	p := &decodePlan{
+		unmarshaler:    t.Implements(unmarshalerType),
+		ptrUnmarshaler: reflect.PtrTo(t).Implements(unmarshalerType),
+
+		ptrTextUnmarshaler: reflect.PtrTo(t).Implements(textUnmarshalerType),
+	}
+	if t.Kind() == reflect.Struct {
+		p.known = make(map[string]bool)
+		fields := cachedTypeFields(t)
+		p.root = rootField(fields)
+		for _, f := range fields {
+			if f.format {
+				p.formats = append(p.formats, f)
+				continue
+			}
+			if f.root {
+				// Decoded from the whole value rather than from a key.
+				continue
+			}
+			p.fields = append(p.fields, newFieldPlan(t, f))
+			if f.path != nil {
+				p.known[f.path[0]] = true
+			} else {
+				p.known[f.name] = true
+			}
+			for _, name := range f.aliases {
+				p.known[name] = true
+			}
+		}
+	}
+	actual, _ := planCache.LoadOrStore(t, p)
+	return actual.(*decodePlan)
+}
+
+// A fieldPlan is a struct field with the decoder of its values, if the values
+// of one plist kind can be decoded without the checks Decoder.unmarshal makes
+// for the field's type, which has none of the methods or tag options they
+// look for.
+type fieldPlan struct {
+	field
+	kind   Kind // the kind of the values decoded by direct
+	direct func(d *Decoder, pval *plistValue, v reflect.Value) error
+	// unconverted is the value of registered when the type of the field was
+	// last found to have no registered decoder, which direct would bypass.
+	unconverted int32
+}
+
+// scalarDecoders maps the kinds of the Go types the values of a plist kind
+// decode into to that kind and its decoder.
+var scalarDecoders = map[reflect.Kind]struct {
+	kind   Kind
+	decode func(d *Decoder, pval *plistValue, v reflect.Value) error
+}{
+	reflect.String:  {String, (*Decoder).unmarshalString},
+	reflect.Bool:    {Boolean, (*Decoder).unmarshalBoolean},
+	reflect.Int:     {Integer, (*Decoder).unmarshalInteger},
+	reflect.Int8:    {Integer, (*Decoder).unmarshalInteger},
+	reflect.Int16:   {Integer, (*Decoder).unmarshalInteger},
+	reflect.Int32:   {Integer, (*Decoder).unmarshalInteger},
+	reflect.Int64:   {Integer, (*Decoder).unmarshalInteger},
+	reflect.Uint:    {Integer, (*Decoder).unmarshalInteger},
+	reflect.Uint8:   {Integer, (*Decoder).unmarshalInteger},
+	reflect.Uint16:  {Integer, (*Decoder).unmarshalInteger},
+	reflect.Uint32:  {Integer, (*Decoder).unmarshalInteger},
+	reflect.Uint64:  {Integer, (*Decoder).unmarshalInteger},
+	reflect.Float32: {Real, (*Decoder).unmarshalReal},
+	reflect.Float64: {Real, (*Decoder).unmarshalReal},
+}
+
+// newFieldPlan returns the plan of the field f of the struct type st.
+func newFieldPlan(st reflect.Type, f field) fieldPlan {
+	p := fieldPlan{field: f}
+	// The type of f is that of the value its pointers point to, so the
+	// declared type is looked up.
+	t := st
+	for _, i := range f.index {
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		t = t.Field(i).Type
+	}
+	if f.unixDate != 0 || f.hex || f.keyedBy != "" || t == numberType ||
+		t.Implements(unmarshalerType) || reflect.PtrTo(t).Implements(unmarshalerType) ||
+		reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		return p
+	}
+	if dec, ok := scalarDecoders[t.Kind()]; ok {
+		p.kind, p.direct = dec.kind, dec.decode
+	}
+	return p
+}
+
+// decodeField decodes pval into v, the value of the field f.
+func (d *Decoder) decodeField(f *fieldPlan, pval *plistValue, v reflect.Value) error {
+	if f.direct != nil && pval.kind == f.kind && f.noConverter(v.Type()) {
+		return f.direct(d, pval, v)
+	}
+	return d.unmarshalField(f.field, pval, v)
+}
+
+// noConverter reports whether no decoder is registered for t, the type of the
+// field. Converters can be registered after the plan is made, so the registry
+// is looked up again whenever one has been.
+func (f *fieldPlan) noConverter(t reflect.Type) bool {
+	n := atomic.LoadInt32(&registered)
+	if atomic.LoadInt32(&f.unconverted) == n {
+		return true
+	}
+	if fn, _ := converter(converters.decoders, t); fn != nil {
+		return false
+	}
+	atomic.StoreInt32(&f.unconverted, n)
+	return true
+}
+
+// value returns the value of f in the struct v, like field.value.
+func (f *fieldPlan) value(v reflect.Value) reflect.Value {
+	if len(f.index) == 1 {
+		return v.Field(f.index[0])
+	}
+	return f.field.value(v)
+}
@@ -1,11 +1,18 @@
 package plist
 
-import "sort"
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+)
 
-type plistKind uint
+// Kind is the type of a plist value.
+type Kind uint
 
+// The kinds of plist values.
 const (
-	Invalid plistKind = iota
+	Invalid Kind = iota
 	Dictionary
 	Array
 	String
@@ -14,9 +21,10 @@ const (
 	Boolean
 	Data
 	Date
+	UIDKind // a UID, see UID
 )
 
-var plistKindNames = map[plistKind]string{
+var plistKindNames = map[Kind]string{
 	Invalid:    "invalid",
 	Dictionary: "dictionary",
 	Array:      "array",
@@ -26,21 +34,116 @@ var plistKindNames = map[plistKind]string{
 	Boolean:    "boolean",
 	Data:       "data",
 	Date:       "date",
+	UIDKind:    "uid",
+}
+
+func (k Kind) String() string {
+	if name, ok := plistKindNames[k]; ok {
+		return name
+	}
+	return plistKindNames[Invalid]
+}
+
+// Format is the encoding of a plist document.
+type Format uint
+
+// The formats of plist documents.
+const (
+	FormatXML Format = iota
+	FormatBinary
+)
+
+var plistFormatNames = map[Format]string{
+	FormatXML:    "xml",
+	FormatBinary: "binary",
+}
+
+func (f Format) String() string {
+	if name, ok := plistFormatNames[f]; ok {
+		return name
+	}
+	return fmt.Sprintf("Format(%d)", uint(f))
+}
+
+// TypeOf returns the kind of plist value v is encoded as, or Invalid if v
+// can't be encoded. It classifies the values stored by decoding into an empty
+// interface, like uint64, []interface{} and map[string]interface{}. Other Go
+// values are classified by their type the way the Encoder encodes them,
+// without calling MarshalPlist. A Number is an Integer or a Real depending on
+// its literal, a big.Float is a Real, and values encoded as their text, like
+// a net.IP, are Strings.
+func TypeOf(v interface{}) Kind {
+	switch v := v.(type) {
+	case nil:
+		return Invalid
+	case UID:
+		return UIDKind
+	case Number:
+		if pval, ok := numberValue(v); ok {
+			return pval.kind
+		}
+		return Invalid
+	case time.Time:
+		return Date
+	case Commented:
+		return TypeOf(v.Value)
+	}
+	val := reflect.ValueOf(v)
+	for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+		if val.IsNil() {
+			return Invalid
+		}
+		val = val.Elem()
+	}
+	if val.Type() != reflect.TypeOf(v) {
+		return TypeOf(val.Interface())
+	}
+	if val.Type() == bigFloatType {
+		return Real
+	}
+	if isTextType(val.Type()) {
+		return String
+	}
+	switch val.Kind() {
+	case reflect.String:
+		return String
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return Integer
+	case reflect.Float32, reflect.Float64:
+		return Real
+	case reflect.Bool:
+		return Boolean
+	case reflect.Slice, reflect.Array:
+		if val.Type().Elem().Kind() == reflect.Uint8 {
+			return Data
+		}
+		return Array
+	case reflect.Map:
+		if val.Type().Key().Kind() == reflect.String {
+			return Dictionary
+		}
+	case reflect.Struct:
+		return Dictionary
+	}
+	return Invalid
 }
 
 type plistValue struct {
-	kind  plistKind
+	kind  Kind
 	value interface{}
 }
 
 type signedInt struct {
-	value  uint64
-	signed bool
+	value   uint64
+	signed  bool
+	anySign bool // signed, but can be decoded into unsigned values as is, see binaryParser.parseInteger
 }
 
 type sizedFloat struct {
-	value float64
-	bits  int
+	value   float64
+	bits    int
+	literal string // the text of the real, when decoded from XML or a Number
 }
 
 type dictionary struct {
@@ -48,6 +151,15 @@ type dictionary struct {
 	m      map[string]*plistValue
 	keys   sort.StringSlice
 	values []*plistValue
+	// ordered is set when keys and values are already populated in the
+	// order they should be encoded in.
+	ordered bool
+	// duplicates lists the keys that appeared more than once in a parsed
+	// dict, whose last value is kept.
+	duplicates []string
+	// order lists the keys of a parsed dict in the order they first
+	// appeared in the document.
+	order []string
 }
 
 func (d *dictionary) Len() int {
@@ -63,7 +175,38 @@ func (d *dictionary) Swap(i, j int) {
 	d.values[i], d.values[j] = d.values[j], d.values[i]
 }
 
+// set stores v under key. When the dictionary is ordered, new keys are added
+// after the existing ones.
+func (d *dictionary) set(key string, v *plistValue) {
+	if d.ordered {
+		if _, ok := d.m[key]; ok {
+			for i, k := range d.keys {
+				if k == key {
+					d.values[i] = v
+				}
+			}
+		} else {
+			d.keys = append(d.keys, key)
+			d.values = append(d.values, v)
+		}
+	}
+	d.m[key] = v
+}
+
+// keyOrder returns the keys of the dictionary in document order if it was
+// parsed, and in the order they are encoded in otherwise.
+func (d *dictionary) keyOrder() []string {
+	if d.order != nil {
+		return d.order
+	}
+	d.populateArrays()
+	return d.keys
+}
+
 func (d *dictionary) populateArrays() {
+	if d.ordered {
+		return
+	}
 	d.keys = make([]string, len(d.m))
 	d.values = make([]*plistValue, len(d.m))
 	i := 0
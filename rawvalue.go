@@ -0,0 +1,91 @@
+package plist
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+)
+
+// A RawValue is a complete encoded plist document, used to pass a value
+// through without decoding it into Go values, or to decode it later.
+//
+// Decoding into a RawValue stores the value as a document in the format of
+// the input: binary if the input is binary, and XML otherwise. The value is
+// encoded again rather than copied from the input, so whitespace, comments
+// and the order of dictionary keys may differ. Encoding a RawValue writes the
+// value of the document it holds, which can be in either format. An empty
+// RawValue has no value, like a nil pointer, and is left out of dictionaries.
+type RawValue []byte
+
+var rawValueType = reflect.TypeOf(RawValue(nil))
+
+// rawValue parses the document held by raw, or returns nil if raw is empty.
+func rawValue(raw RawValue) (*plistValue, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	return parseDocument(raw, true)
+}
+
+// encodeRaw returns pval encoded as a document in the format of the decoder's
+// input.
+func (d *Decoder) encodeRaw(pval *plistValue) (RawValue, error) {
+	var buf bytes.Buffer
+	var err error
+	if d.isBinary {
+		err = newBinaryEncoder(&buf).generateDocument(pval)
+	} else {
+		err = newXMLEncoder(&buf).generateDocument(pval)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SetRawFallback sets a RawValue that the root value of each plist is stored
+// in when its type doesn't match the value passed to Decode, like an array
+// decoded into a struct. Decode then returns nil instead of an
+// UnmarshalTypeError. After a plist that decoded normally, *raw is set to
+// nil, so that the caller can tell which happened. Passing nil turns the
+// fallback off.
+//
+// Only a mismatch at the root is caught. Mismatches within a dictionary or
+// array that matched, syntax errors and the limits set with SetMaxElements
+// still make Decode fail. With SetStrict, the document is checked while it is
+// parsed, before the fallback applies, so a document rejected by strict mode
+// is never stored.
+func (d *Decoder) SetRawFallback(raw *RawValue) {
+	d.rawFallback = raw
+}
+
+// fallBack stores pval in the decoder's raw fallback if err is a mismatch
+// between pval and v at the root, and reports whether it did. Errors from
+// the values within pval pass through collect, which sets d.innerErr, so a
+// mismatch below the root is never taken for one at the root.
+func (d *Decoder) fallBack(pval *plistValue, v reflect.Value, err error) (bool, error) {
+	if d.rawFallback == nil {
+		return false, nil
+	}
+	if err == nil {
+		*d.rawFallback = nil
+		return false, nil
+	}
+	var te UnmarshalTypeError
+	if d.innerErr || !errors.As(err, &te) {
+		return false, nil
+	}
+	t := v.Type()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if te.Type != t {
+		return false, nil
+	}
+	raw, err := d.encodeRaw(pval)
+	if err != nil {
+		return true, err
+	}
+	*d.rawFallback = raw
+	return true, nil
+}
@@ -0,0 +1,141 @@
+package plist
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// A ConvertFunc converts a value for RegisterEncoder and RegisterDecoder.
+type ConvertFunc func(v interface{}) (interface{}, error)
+
+var converters = struct {
+	sync.RWMutex
+	encoders   map[reflect.Type]ConvertFunc
+	decoders   map[reflect.Type]ConvertFunc
+	validators map[reflect.Type]ConvertFunc
+}{
+	encoders:   make(map[reflect.Type]ConvertFunc),
+	decoders:   make(map[reflect.Type]ConvertFunc),
+	validators: make(map[reflect.Type]ConvertFunc),
+}
+
+// registered counts the converters registered, so that encoding and decoding
+// don't take the lock for every value when none is, and so that decode plans
+// know when to look for converters again.
+var registered int32
+
+// RegisterEncoder records fn as the conversion of values of type t for
+// encoding, for types that can't implement Marshaler, like types of other
+// packages. Values of type t, and values pointed to by a *t, are replaced by
+// the value fn returns, which is encoded instead. A registered encoder takes
+// precedence over a MarshalPlist method and the default encoding of t.
+//
+// RegisterEncoder panics if t or fn is nil or if t is registered twice. It is
+// safe to call concurrently with encoding, but is meant to be called from
+// init functions.
+func RegisterEncoder(t reflect.Type, fn ConvertFunc) {
+	register(converters.encoders, "RegisterEncoder", t, fn)
+}
+
+// RegisterDecoder records fn as the conversion of plist values to values of
+// type t for decoding, the counterpart of RegisterEncoder. When decoding into
+// a t, or into a *t, fn is called with the plist value as it is decoded into
+// an empty interface, like a string or a map[string]interface{}, and the
+// value it returns is stored. It must be assignable to t. A registered
+// decoder takes precedence over an UnmarshalPlist method and the default
+// decoding of t.
+//
+// RegisterDecoder panics if t or fn is nil or if t is registered twice. It is
+// safe to call concurrently with decoding, but is meant to be called from
+// init functions.
+func RegisterDecoder(t reflect.Type, fn ConvertFunc) {
+	register(converters.decoders, "RegisterDecoder", t, fn)
+}
+
+func register(m map[reflect.Type]ConvertFunc, name string, t reflect.Type, fn ConvertFunc) {
+	if t == nil || fn == nil {
+		panic("plist: " + name + " of nil type or function")
+	}
+	converters.Lock()
+	defer converters.Unlock()
+	if _, ok := m[t]; ok {
+		panic(fmt.Sprintf("plist: %s called twice for %v", name, t))
+	}
+	m[t] = fn
+	atomic.AddInt32(&registered, 1)
+}
+
+// converter returns the function registered in m for t, or for the element
+// type of t if t is a pointer, and whether it is for the element type.
+func converter(m map[reflect.Type]ConvertFunc, t reflect.Type) (ConvertFunc, bool) {
+	if atomic.LoadInt32(&registered) == 0 {
+		return nil, false
+	}
+	converters.RLock()
+	defer converters.RUnlock()
+	if len(m) == 0 {
+		return nil, false
+	}
+	if fn, ok := m[t]; ok {
+		return fn, false
+	}
+	if t.Kind() == reflect.Ptr {
+		if fn, ok := m[t.Elem()]; ok {
+			return fn, true
+		}
+	}
+	return nil, false
+}
+
+// marshalRegistered encodes v with the encoder registered for its type, and
+// reports whether there is one.
+func (e *Encoder) marshalRegistered(v reflect.Value) (*plistValue, bool, error) {
+	fn, elem := converter(converters.encoders, v.Type())
+	if fn == nil || !v.CanInterface() {
+		return nil, false, nil
+	}
+	if elem {
+		if v.IsNil() {
+			// Left to the usual handling of nil pointers.
+			return nil, false, nil
+		}
+		v = v.Elem()
+	}
+	val, err := fn(v.Interface())
+	if err != nil {
+		return nil, true, err
+	}
+	pval, err := e.marshalValue(reflect.ValueOf(val))
+	return pval, true, err
+}
+
+// unmarshalRegistered decodes pval into v with the decoder registered for
+// the type of v, and reports whether there is one.
+func (d *Decoder) unmarshalRegistered(pval *plistValue, v reflect.Value) (bool, error) {
+	fn, elem := converter(converters.decoders, v.Type())
+	if fn == nil {
+		return false, nil
+	}
+	if elem {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	iface, err := d.valueInterface(pval)
+	if err != nil {
+		return true, err
+	}
+	val, err := fn(iface)
+	if err != nil {
+		return true, err
+	}
+	rv := reflect.ValueOf(val)
+	if !rv.IsValid() || !rv.Type().AssignableTo(v.Type()) {
+		return true, fmt.Errorf("plist: decoder registered for %v returned %T", v.Type(), val)
+	}
+	v.Set(rv)
+	return true, nil
+}
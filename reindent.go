@@ -0,0 +1,125 @@
+package plist
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strconv"
+)
+
+// Reindent returns the XML plist data with the whitespace between its elements
+// replaced, so that every element of a dict or array starts on a line of its
+// own, indented with one copy of indent for each level of nesting like the
+// output of an Encoder set to the same indent. As with json.Indent, every
+// line but the first also begins with prefix. Everything else is copied byte
+// for byte, since the document is reformatted as a stream of XML tokens
+// without converting its values: the text of elements like <string> is kept
+// as it is, whitespace included, and so are the XML header, comments,
+// character references and the order of dict keys. Empty arrays and dicts
+// stay on one line.
+//
+// Reindent returns an error if data isn't well formed XML, or if text other
+// than whitespace appears directly inside a <plist>, <dict> or <array>
+// element, since it couldn't be moved without changing the document.
+func Reindent(data []byte, prefix, indent string) ([]byte, error) {
+	var out bytes.Buffer
+	if bytes.HasPrefix(data, []byte(utf8BOM)) {
+		out.WriteString(utf8BOM)
+		data = data[len(utf8BOM):]
+	}
+	r := &reindenter{out: &out, prefix: prefix, indent: indent}
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		start := dec.InputOffset()
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := r.token(tok, data[start:dec.InputOffset()], start); err != nil {
+			return nil, err
+		}
+	}
+	out.WriteByte('\n')
+	return out.Bytes(), nil
+}
+
+// reindenter writes the tokens of a document with new whitespace between the
+// elements of containers. Tokens inside other elements are written as is.
+type reindenter struct {
+	out            *bytes.Buffer
+	prefix, indent string
+
+	depth     int  // number of open containers
+	leafDepth int  // number of open elements inside the innermost leaf
+	started   bool // a token has been written
+	empty     bool // the innermost container has no children yet
+}
+
+// isReindentContainer reports whether the elements of name are laid out on
+// lines of their own.
+func isReindentContainer(name string) bool {
+	return name == "plist" || name == "dict" || name == "array"
+}
+
+// token writes tok, whose text in the input is raw, starting at offset.
+func (r *reindenter) token(tok xml.Token, raw []byte, offset int64) error {
+	if r.leafDepth > 0 {
+		// Inside an element like <string>, everything is content.
+		switch tok.(type) {
+		case xml.StartElement:
+			r.leafDepth++
+		case xml.EndElement:
+			r.leafDepth--
+		}
+		r.out.Write(raw)
+		return nil
+	}
+
+	switch t := tok.(type) {
+	case xml.CharData:
+		if len(bytes.TrimSpace(t)) == 0 {
+			// Whitespace between elements is replaced.
+			return nil
+		}
+		return &SyntaxError{"unexpected text " + strconv.Quote(string(bytes.TrimSpace(t))) + " between elements", offset}
+	case xml.EndElement:
+		// Only containers are ended here, leaves end above.
+		r.depth--
+		if !r.empty {
+			r.newline()
+		}
+		r.empty = false
+		r.out.Write(raw)
+		return nil
+	}
+
+	// Elements, comments, processing instructions and directives are
+	// written on a line of their own.
+	if r.started {
+		r.newline()
+	}
+	r.started = true
+	r.empty = false
+	r.out.Write(raw)
+	if t, ok := tok.(xml.StartElement); ok {
+		if isReindentContainer(t.Name.Local) {
+			r.depth++
+			r.empty = true
+		} else {
+			r.leafDepth = 1
+		}
+	}
+	return nil
+}
+
+// newline starts a new line indented for the open containers.
+func (r *reindenter) newline() {
+	r.out.WriteByte('\n')
+	r.out.WriteString(r.prefix)
+	for i := 0; i < r.depth; i++ {
+		r.out.WriteString(r.indent)
+	}
+}
@@ -0,0 +1,40 @@
+package plist
+
+import "reflect"
+
+// A struct field tagged with the root option, like `plist:",root"`, stands
+// for the whole value the struct is decoded from or encoded as, which can be
+// of any kind. This is useful for envelope types that carry a document along
+// with metadata kept outside of it:
+//
+//	type Envelope struct {
+//		Source  string      `plist:"-"`
+//		Payload interface{} `plist:",root"`
+//	}
+//
+// Decoding into a struct with a root field decodes the value into that field
+// and leaves the other fields untouched, tagged or not. Encoding it encodes
+// the root field in place of the struct, so the other fields are left out. A
+// struct with more than one root field only uses the first.
+
+// rootField returns the first of fields tagged with the root option, or nil.
+func rootField(fields []field) *field {
+	for i := range fields {
+		if fields[i].root {
+			return &fields[i]
+		}
+	}
+	return nil
+}
+
+// marshalRoot encodes the root field f of the struct v.
+func (e *Encoder) marshalRoot(f *field, v reflect.Value) (*plistValue, error) {
+	value, err := e.marshalField(*f, f.value(v))
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, &UnsupportedValueError{v, "nil root field " + f.name}
+	}
+	return value, nil
+}
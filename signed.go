@@ -0,0 +1,83 @@
+package plist
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+)
+
+var (
+	oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidData       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+)
+
+// contentInfo and signedData are the parts of the CMS structures of RFC 5652
+// needed to get at the signed content and the certificates. The rest of the
+// SignedData is kept raw.
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	EncapContentInfo encapContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      asn1.RawValue
+}
+
+type encapContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     []byte `asn1:"optional,explicit,tag:0"`
+}
+
+// SignedContent extracts the content of a DER encoded CMS (PKCS #7)
+// SignedData, like a signed .mobileconfig profile, and returns it with the
+// certificates included in the container. The signature is not verified; the
+// certificates are returned so that the caller can verify it.
+func SignedContent(data []byte) ([]byte, []*x509.Certificate, error) {
+	var info contentInfo
+	if rest, err := asn1.Unmarshal(data, &info); err != nil {
+		return nil, nil, fmt.Errorf("plist: invalid signed data: %v", err)
+	} else if len(rest) > 0 {
+		return nil, nil, errors.New("plist: trailing data after signed data")
+	}
+	if !info.ContentType.Equal(oidSignedData) {
+		return nil, nil, fmt.Errorf("plist: unsupported content type %v, expected signed data", info.ContentType)
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &sd); err != nil {
+		return nil, nil, fmt.Errorf("plist: invalid signed data: %v", err)
+	}
+	if !sd.EncapContentInfo.ContentType.Equal(oidData) {
+		return nil, nil, fmt.Errorf("plist: unsupported signed content type %v", sd.EncapContentInfo.ContentType)
+	}
+	if sd.EncapContentInfo.Content == nil {
+		return nil, nil, errors.New("plist: signed data has detached content")
+	}
+	var certs []*x509.Certificate
+	if len(sd.Certificates.Bytes) > 0 {
+		var err error
+		if certs, err = x509.ParseCertificates(sd.Certificates.Bytes); err != nil {
+			return nil, nil, fmt.Errorf("plist: invalid certificate in signed data: %v", err)
+		}
+	}
+	return sd.EncapContentInfo.Content, certs, nil
+}
+
+// UnmarshalSigned decodes the plist enclosed in a signed container as
+// described for SignedContent into the value pointed to by v, and returns the
+// certificates included in the container. The signature is not verified.
+func UnmarshalSigned(data []byte, v interface{}) ([]*x509.Certificate, error) {
+	content, certs, err := SignedContent(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := Unmarshal(content, v); err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
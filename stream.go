@@ -0,0 +1,231 @@
+package plist
+
+import (
+	"errors"
+	"reflect"
+)
+
+var errUnfinishedStream = errors.New("plist: Encode called before the incrementally written document was finished")
+
+// encodeStream is the state of a document being written incrementally. The
+// stack has one frame for every open array or dictionary.
+type encodeStream struct {
+	enc   *xmlEncoder
+	stack []streamFrame
+}
+
+type streamFrame struct {
+	dict bool
+	// keyPending is true when a dictionary key has been written but its value
+	// hasn't.
+	keyPending bool
+}
+
+// BeginDict starts a dictionary. It is followed by alternating calls to
+// WriteKey and a value method, then by EndDict.
+//
+// BeginDict, BeginArray, WriteKey, the Write value methods and the End methods
+// build a document one element at a time instead of converting a complete Go
+// value like Encode does, so that large documents can be written with bounded
+// memory. Binary encoders don't support this. The document header is written
+// by the first call, and the document is finished and flushed once the top
+// level value is complete. A call that doesn't fit the structure written so
+// far, like an EndArray that closes a dictionary, returns an error and writes
+// nothing. Empty arrays and dictionaries are always written as a start and end
+// element pair.
+func (e *Encoder) BeginDict() error {
+	return e.beginCollection("dict", true)
+}
+
+// EndDict ends the dictionary started by the matching BeginDict.
+func (e *Encoder) EndDict() error {
+	return e.endCollection("dict", true)
+}
+
+// BeginArray starts an array. It is followed by the values of the array, then
+// by EndArray.
+func (e *Encoder) BeginArray() error {
+	return e.beginCollection("array", false)
+}
+
+// EndArray ends the array started by the matching BeginArray.
+func (e *Encoder) EndArray() error {
+	return e.endCollection("array", false)
+}
+
+// WriteKey writes the key of the next value of the current dictionary.
+func (e *Encoder) WriteKey(key string) error {
+	s := e.stream
+	if s == nil || len(s.stack) == 0 || !s.stack[len(s.stack)-1].dict {
+		return errors.New("plist: WriteKey called outside of a dictionary")
+	}
+	top := &s.stack[len(s.stack)-1]
+	if top.keyPending {
+		return errors.New("plist: WriteKey called twice without a value")
+	}
+	top.keyPending = true
+	return s.enc.writeKey(key)
+}
+
+// WriteString writes a string value.
+func (e *Encoder) WriteString(value string) error {
+	return e.writeStreamValue(&plistValue{String, value})
+}
+
+// WriteValue writes the plist encoding of v as the next value, converting it
+// the same way as Encode does.
+func (e *Encoder) WriteValue(v interface{}) error {
+	pval, err := e.marshal(reflect.ValueOf(v))
+	if err != nil {
+		return err
+	}
+	if pval == nil {
+		return &UnsupportedValueError{reflect.ValueOf(v), "nil"}
+	}
+	return e.writeStreamValue(pval)
+}
+
+// Flush writes any buffered output of an unfinished document to the
+// underlying writer.
+func (e *Encoder) Flush() error {
+	if e.stream == nil {
+		return nil
+	}
+	return e.stream.enc.w.Flush()
+}
+
+func (e *Encoder) writeStreamValue(pval *plistValue) error {
+	if err := e.beginValue(); err != nil {
+		return err
+	}
+	if err := e.stream.enc.writePlistValue(pval); err != nil {
+		return err
+	}
+	return e.endValue()
+}
+
+func (e *Encoder) beginCollection(name string, dict bool) error {
+	if err := e.beginValue(); err != nil {
+		return err
+	}
+	e.stream.stack = append(e.stream.stack, streamFrame{dict: dict})
+	return e.stream.enc.writeStart(name)
+}
+
+func (e *Encoder) endCollection(name string, dict bool) error {
+	s := e.stream
+	if s == nil || len(s.stack) == 0 {
+		return errors.New("plist: End" + kindName(dict) + " called without a matching Begin" + kindName(dict))
+	}
+	top := s.stack[len(s.stack)-1]
+	if top.dict != dict {
+		if dict {
+			return errors.New("plist: EndDict called while an array is open")
+		}
+		return errors.New("plist: EndArray called while a dictionary is open")
+	}
+	if top.keyPending {
+		return errors.New("plist: EndDict called after a key without a value")
+	}
+	s.stack = s.stack[:len(s.stack)-1]
+	if err := s.enc.writeEnd(name); err != nil {
+		return err
+	}
+	return e.endValue()
+}
+
+// beginValue checks that a value may be written next, starting the document
+// if this is its first value.
+func (e *Encoder) beginValue() error {
+	if e.stream == nil {
+		if e.isBinary {
+			return errors.New("plist: binary plists can't be encoded incrementally")
+		}
+		enc := e.newXMLEncoder()
+		if err := enc.writeHeader(); err != nil {
+			return err
+		}
+		e.stream = &encodeStream{enc: enc}
+		return nil
+	}
+	if len(e.stream.stack) == 0 {
+		return nil
+	}
+	top := &e.stream.stack[len(e.stream.stack)-1]
+	if top.dict {
+		if !top.keyPending {
+			return errors.New("plist: dictionary value written without a key")
+		}
+		top.keyPending = false
+	}
+	return nil
+}
+
+// endValue finishes the document once the top level value is complete.
+func (e *Encoder) endValue() error {
+	if len(e.stream.stack) != 0 {
+		return nil
+	}
+	enc := e.stream.enc
+	e.stream = nil
+	return enc.writeFooter()
+}
+
+func kindName(dict bool) string {
+	if dict {
+		return "Dict"
+	}
+	return "Array"
+}
+
+// EncodeArrayFunc writes an array of the values returned by next, calling it
+// until it returns false, so that the values don't need to be collected in a
+// slice first. The values are converted the same way as Encode does. An error
+// returned by next, or by encoding a value, stops the encoding and is
+// returned.
+//
+// If a document is being written incrementally, the array is written as its
+// next value; otherwise the array is a document of its own. Binary encoders
+// can't write values before they have all of them, so they collect the values
+// and encode them as a slice once next is done.
+func (e *Encoder) EncodeArrayFunc(next func() (interface{}, bool, error)) error {
+	if e.isBinary {
+		values := []interface{}{}
+		for {
+			v, ok, err := next()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return e.Encode(values)
+			}
+			values = append(values, v)
+		}
+	}
+	started := e.stream != nil
+	err := e.encodeArrayFunc(next)
+	if err != nil && !started {
+		// Drop the unfinished document, so that the encoder can be used for
+		// the next one.
+		e.stream = nil
+	}
+	return err
+}
+
+func (e *Encoder) encodeArrayFunc(next func() (interface{}, bool, error)) error {
+	if err := e.BeginArray(); err != nil {
+		return err
+	}
+	for {
+		v, ok, err := next()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return e.EndArray()
+		}
+		if err := e.WriteValue(v); err != nil {
+			return err
+		}
+	}
+}
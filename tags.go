@@ -5,6 +5,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
 	"unicode"
 )
 
@@ -43,12 +44,35 @@ func (o tagOptions) Contains(optionName string) bool {
 	return false
 }
 
+// Value returns the value of an option written as name=value, or the empty
+// string if there is no such option.
+func (o tagOptions) Value(name string) string {
+	for _, opt := range strings.Split(string(o), ",") {
+		if strings.HasPrefix(opt, name+"=") {
+			return opt[len(name)+1:]
+		}
+	}
+	return ""
+}
+
 type field struct {
 	name      string
 	tag       bool
 	index     []int
 	typ       reflect.Type
 	omitEmpty bool
+	required  bool
+	asDate    bool
+	hex       bool          // []byte fields tagged with hex, see hexbytes.go
+	unixDate  time.Duration // unit of fields tagged with unixdate, see unixdate.go
+	path      []string      // the elements of name for fields tagged with path
+	aliases   []string      // alternate keys the field is decoded from, see alternates.go
+	keyedBy   string        // key indexing the elements of map fields, see keyedby.go
+	lastWins  bool          // duplicate keyedBy keys keep the last element
+	enum      []string      // values allowed by Encoder.SetValidation, see validate.go
+	format    bool          // the field holds the format of the document, see format.go
+	comment   string        // the plistComment tag, see Encoder.FieldComments
+	root      bool          // the field holds the whole value, see root.go
 }
 
 func (f field) value(v reflect.Value) reflect.Value {
@@ -64,6 +88,22 @@ func (f field) value(v reflect.Value) reflect.Value {
 	return v
 }
 
+// taggedValue returns the plistValue of the field value v for fields with the
+// tag options that change how values are encoded, or nil if v is encoded as
+// usual.
+func (f field) taggedValue(v reflect.Value) *plistValue {
+	switch {
+	case f.asDate && v.Kind() == reflect.String:
+		// A string tagged as a date is encoded as the date's text.
+		return &plistValue{Date, v.String()}
+	case f.hex:
+		return hexValue(v)
+	case f.unixDate != 0:
+		return unixDateValue(v, f.unixDate)
+	}
+	return nil
+}
+
 type byName []field
 
 func (x byName) Len() int { return len(x) }
@@ -128,6 +168,7 @@ func typeFields(t reflect.Type) []field {
 				continue
 			}
 			visited[f.typ] = true
+			namer := fieldNamer(f.typ)
 
 			// Scan f.typ for fields to include.
 			for i := 0; i < f.typ.NumField(); i++ {
@@ -143,6 +184,12 @@ func typeFields(t reflect.Type) []field {
 				if !isValidTag(name) {
 					name = ""
 				}
+				if name == "" && namer != nil && !sf.Anonymous {
+					// Names given by a registered FieldNameFunc.
+					if name = namer(sf); name == "-" {
+						continue
+					}
+				}
 				index := make([]int, len(f.index)+1)
 				copy(index, f.index)
 				index[len(f.index)] = i
@@ -159,12 +206,26 @@ func typeFields(t reflect.Type) []field {
 					if name == "" {
 						name = sf.Name
 					}
+					var aliases []string
+					name, aliases = alternateNames(name, opts)
 					fields = append(fields, field{
 						name:      name,
+						aliases:   aliases,
 						tag:       tagged,
 						index:     index,
 						typ:       ft,
 						omitEmpty: opts.Contains("omitempty"),
+						required:  opts.Contains("required"),
+						asDate:    opts.Contains("date"),
+						hex:       opts.Contains("hex"),
+						unixDate:  unixDateUnit(opts),
+						path:      fieldPath(name, opts),
+						keyedBy:   opts.Value("keyedBy"),
+						lastWins:  opts.Contains("lastwins"),
+						enum:      enumValues(opts),
+						format:    isFormatField(sf, opts),
+						comment:   sf.Tag.Get("plistComment"),
+						root:      opts.Contains("root"),
 					})
 					if count[f.typ] > 1 {
 						// If there were multiple instances, add a second,
@@ -284,6 +345,15 @@ func cachedTypeFields(t reflect.Type) []field {
 	return f
 }
 
+// fieldPath returns the elements of the key path name if the field is tagged
+// with the path option, or nil otherwise. See keypath.go.
+func fieldPath(name string, opts tagOptions) []string {
+	if !opts.Contains("path") {
+		return nil
+	}
+	return strings.Split(name, ".")
+}
+
 func isValidTag(s string) bool {
 	if s == "" {
 		return false
@@ -0,0 +1,118 @@
+package plist
+
+import (
+	"encoding"
+	"fmt"
+	"net/url"
+	"reflect"
+)
+
+// url.URL values and the types of net/netip, like netip.Addr and
+// netip.Prefix, are encoded as a <string> holding their text, with String for
+// url.URL and MarshalText for the others. Other values implementing
+// encoding.TextMarshaler, like net.IP, keep their usual encoding unless
+// Encoder.UseTextMarshaler is set, since a []byte or a struct that happens to
+// have a MarshalText method would otherwise change to a <string>. A <string>
+// is decoded into a value implementing encoding.TextUnmarshaler with its
+// UnmarshalText method, or into a url.URL with url.Parse. Marshaler and
+// Unmarshaler take precedence, and time.Time values are still dates.
+
+var (
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	urlType             = reflect.TypeOf(url.URL{})
+)
+
+// isTextType reports whether values of type t are encoded as their text
+// without UseTextMarshaler.
+func isTextType(t reflect.Type) bool {
+	return t == urlType || t.PkgPath() == "net/netip" && t.Implements(textMarshalerType)
+}
+
+// marshalText encodes v as a string of its text, and reports whether v is
+// encoded that way.
+func (e *Encoder) marshalText(v reflect.Value) (*plistValue, bool, error) {
+	if v.Type() == urlType {
+		u := v.Interface().(url.URL)
+		return &plistValue{String, u.String()}, true, nil
+	}
+	if !v.CanInterface() || !e.useTextMarshaler && !isTextType(v.Type()) {
+		return nil, false, nil
+	}
+	m, ok := v.Interface().(encoding.TextMarshaler)
+	if !ok && v.CanAddr() {
+		m, ok = v.Addr().Interface().(encoding.TextMarshaler)
+	}
+	if !ok {
+		return nil, false, nil
+	}
+	text, err := m.MarshalText()
+	if err != nil {
+		return nil, true, err
+	}
+	return &plistValue{String, string(text)}, true, nil
+}
+
+// unmarshalText decodes the string pval into v with the UnmarshalText method
+// of v, or url.Parse for a url.URL, and reports whether v is decoded that
+// way.
+func (d *Decoder) unmarshalText(pval *plistValue, v reflect.Value) (bool, error) {
+	if pval.kind != String {
+		return false, nil
+	}
+	if v.Type() == urlType {
+		u, err := url.Parse(pval.value.(string))
+		if err != nil {
+			return true, err
+		}
+		v.Set(reflect.ValueOf(*u))
+		return true, nil
+	}
+	if !cachedDecodePlan(v.Type()).ptrTextUnmarshaler || !v.CanAddr() || !v.Addr().CanInterface() {
+		return false, nil
+	}
+	u := v.Addr().Interface().(encoding.TextUnmarshaler)
+	return true, u.UnmarshalText([]byte(pval.value.(string)))
+}
+
+// UseTextMarshaler sets whether all values implementing
+// encoding.TextMarshaler are encoded as a <string> of what MarshalText
+// returns, rather than only url.URL and the types of net/netip. It is off by
+// default, so that a net.IP is still a <data> and a struct with a MarshalText
+// method still a <dict>.
+func (e *Encoder) UseTextMarshaler(use bool) {
+	e.useTextMarshaler = use
+}
+
+// UseStringer sets whether values implementing fmt.Stringer, like enums with
+// a String method, are encoded as a <string> of what String returns, and
+// values implementing error as a <string> of their Error message. It is off
+// by default, so that types that happen to have such a method keep their
+// usual encoding. Marshaler, the values encoded as text, and the types with
+// an encoding of their own, like time.Time and Number, take precedence. The
+// strings can't be decoded back into such types unless they also implement
+// encoding.TextUnmarshaler or Unmarshaler.
+func (e *Encoder) UseStringer(use bool) {
+	e.useStringer = use
+}
+
+// stringerValue encodes v as a string with its String or Error method, and
+// reports whether it has one.
+func stringerValue(v reflect.Value) (*plistValue, bool) {
+	if !v.CanInterface() {
+		return nil, false
+	}
+	i := v.Interface()
+	if _, ok := i.(fmt.Stringer); !ok && v.CanAddr() {
+		if _, ok := i.(error); !ok {
+			i = v.Addr().Interface()
+		}
+	}
+	switch s := i.(type) {
+	case fmt.Stringer:
+		return &plistValue{String, s.String()}, true
+	case error:
+		return &plistValue{String, s.Error()}, true
+	}
+	return nil, false
+}
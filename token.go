@@ -0,0 +1,197 @@
+package plist
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A Token is an element of an XML plist read by Decoder.Token: the start or
+// the end of an array or dictionary, or a complete scalar value. Dict keys
+// aren't tokens of their own; they are the Key of the value that follows.
+type Token struct {
+	// Kind is the kind of the value the token is part of.
+	Kind Kind
+	// End is set for the token that ends an array or dictionary. The other
+	// tokens of arrays and dictionaries start them.
+	End bool
+	// Key is the dict key of the value, for values in a dictionary. It is
+	// empty for end tokens.
+	Key string
+	// Raw is the text of a scalar value as it is written in the document,
+	// with entities like &amp; replaced: the characters of a string, the
+	// digits of a number, the text of a date, the base64 text of data, and
+	// true or false for booleans. It is empty for arrays and dictionaries.
+	Raw string
+
+	value interface{}
+}
+
+// Value returns the value of a scalar token as Decode would store it in an
+// empty interface, like a uint64 for an integer or a []byte for data. It
+// returns nil for the tokens of arrays and dictionaries.
+func (t Token) Value() interface{} {
+	return t.value
+}
+
+// tokenReader is the state of a document read with Decoder.Token. The stack
+// has one frame for every open array or dictionary.
+type tokenReader struct {
+	p     *xmlParser
+	stack []tokenFrame
+	done  bool // the root value has been read
+}
+
+type tokenFrame struct {
+	dict      bool
+	key       *string // key read for the next value of a dictionary
+	keyOffset int64
+}
+
+// Token returns the next token of the plist in the input, so that large
+// documents can be scanned one value at a time, for example to decide which
+// values to keep, without holding the whole document in memory. The
+// document is checked as it is read, with the options set for Decode, like
+// SetStrict.
+//
+// Token returns io.EOF once the root value has been read. Only XML plists
+// can be read with Token, and a document read with Token can't be decoded
+// with Decode.
+func (d *Decoder) Token() (Token, error) {
+	if err := d.decompress(); err != nil {
+		return Token{}, err
+	}
+	if d.isBinary {
+		return Token{}, errors.New("plist: Token requires an XML plist")
+	}
+	if d.tokens == nil {
+		p := d.newXMLParser(d.reader)
+		if d.captureComments {
+			p.comments = &d.comments
+		}
+		d.tokens = &tokenReader{p: p}
+	}
+	return d.tokens.next(d)
+}
+
+func (r *tokenReader) next(d *Decoder) (Token, error) {
+	for !r.done {
+		tok, err := r.p.Token()
+		if err == io.EOF && len(r.stack) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return Token{}, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "plist" && len(r.stack) == 0 {
+				continue
+			}
+			if t.Name.Local == "key" {
+				if err := r.readKey(&t); err != nil {
+					return Token{}, err
+				}
+				continue
+			}
+			return r.startValue(d, &t)
+		case xml.EndElement:
+			if t.Name.Local == "plist" && len(r.stack) == 0 {
+				continue
+			}
+			top := r.stack[len(r.stack)-1]
+			if top.key != nil {
+				return Token{}, errMissingValue(*top.key, top.keyOffset)
+			}
+			r.stack = r.stack[:len(r.stack)-1]
+			r.done = len(r.stack) == 0
+			kind := Array
+			if top.dict {
+				kind = Dictionary
+			}
+			return Token{Kind: kind, End: true}, nil
+		}
+	}
+	return Token{}, io.EOF
+}
+
+// readKey reads the <key> element that was the last token read.
+func (r *tokenReader) readKey(el *xml.StartElement) error {
+	offset := r.p.tokenOffset
+	if len(r.stack) == 0 || !r.stack[len(r.stack)-1].dict {
+		return &SyntaxError{"<key> outside of a dict", offset}
+	}
+	top := &r.stack[len(r.stack)-1]
+	if top.key != nil {
+		return errMissingValue(*top.key, top.keyOffset)
+	}
+	if err := r.p.limit.add(); err != nil {
+		return err
+	}
+	var k string
+	if err := r.p.DecodeElement(&k, el); err != nil {
+		return err
+	}
+	top.key, top.keyOffset = &k, offset
+	return nil
+}
+
+// startValue returns the token of the value element that was the last token
+// read, reading the whole element if it is a scalar.
+func (r *tokenReader) startValue(d *Decoder, el *xml.StartElement) (Token, error) {
+	kind, ok := elementKinds[el.Name.Local]
+	if !ok {
+		return Token{}, fmt.Errorf("plist: Unknown plist element %s", el.Name.Local)
+	}
+	if err := r.p.limit.add(); err != nil {
+		return Token{}, err
+	}
+	tok := Token{Kind: kind}
+	if len(r.stack) > 0 && r.stack[len(r.stack)-1].dict {
+		top := &r.stack[len(r.stack)-1]
+		if top.key == nil {
+			return Token{}, errMissingKey(el, r.p.tokenOffset)
+		}
+		tok.Key, top.key = *top.key, nil
+	}
+	if kind == Dictionary || kind == Array {
+		r.stack = append(r.stack, tokenFrame{dict: kind == Dictionary})
+		return tok, nil
+	}
+	if err := r.p.DecodeElement(&tok.Raw, el); err != nil {
+		return Token{}, err
+	}
+	pval, err := r.p.textValue(el, tok.Raw)
+	if err != nil {
+		return Token{}, err
+	}
+	if tok.value, err = d.valueInterface(pval); err != nil {
+		return Token{}, err
+	}
+	if kind == Boolean {
+		tok.Raw = el.Name.Local
+	}
+	r.done = len(r.stack) == 0
+	return tok, nil
+}
+
+// textValue returns the value of the scalar element whose text is s.
+func (p *xmlParser) textValue(el *xml.StartElement, s string) (*plistValue, error) {
+	switch el.Name.Local {
+	case "string":
+		return p.stringValue(s), nil
+	case "true", "false":
+		return &plistValue{Boolean, el.Name.Local == "true"}, nil
+	case "real":
+		return p.realValue(el, s)
+	case "integer":
+		return p.integerValue(el, s)
+	case "data":
+		return p.dataValue(s)
+	case "date":
+		return p.dateValue(el, s)
+	default:
+		return nil, fmt.Errorf("plist: Unknown plist element %s", el.Name.Local)
+	}
+}
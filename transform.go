@@ -0,0 +1,99 @@
+package plist
+
+import (
+	"reflect"
+	"strconv"
+)
+
+// SetValueTransform sets a function that Encode calls with every value it
+// encodes, before encoding it, and that returns the value to encode in its
+// place, so that values like passwords can be replaced with placeholders
+// without changing the value passed to Encode:
+//
+//	enc.SetValueTransform(func(path []string, v interface{}) interface{} {
+//		if len(path) > 0 && path[len(path)-1] == "Password" {
+//			return "REDACTED"
+//		}
+//		return v
+//	})
+//
+// path is the key path of the value from the root value, which has an empty
+// path: the keys of map, Dict and struct values, using the plist names of
+// struct fields, and the decimal indexes of array elements. The path of a
+// field tagged with path holds each of the keys of its path. The slice is
+// reused once fn returns.
+//
+// The value returned by fn is encoded as the value at path, but isn't passed
+// to fn again; the values it holds are, at their own paths. Returning nil
+// leaves the value out, like a nil pointer, and returning v keeps it. A
+// replacement of another type than a struct field encodes without the
+// field's tag options, like hex. Values returned by MarshalPlist methods and
+// registered encoders aren't passed to fn, and neither are the fields left
+// out with omitempty. A nil fn, the default, encodes values as they are.
+func (e *Encoder) SetValueTransform(fn func(path []string, v interface{}) interface{}) {
+	e.transform = fn
+}
+
+// transformValue returns the value the transform replaces v with, or v if
+// there is no transform.
+func (e *Encoder) transformValue(v reflect.Value) reflect.Value {
+	if e.transform == nil || !v.IsValid() || !v.CanInterface() {
+		return v
+	}
+	nv := reflect.ValueOf(e.transform(e.path, v.Interface()))
+	if nv.IsValid() && nv.Type() == v.Type() && v.CanAddr() {
+		// Keep the value addressable, so that the methods of a pointer to
+		// it are still found.
+		av := reflect.New(v.Type()).Elem()
+		av.Set(nv)
+		return av
+	}
+	return nv
+}
+
+// enter adds key to the path of the value being encoded, if it is kept.
+func (e *Encoder) enter(key string) {
+	if e.transform != nil {
+		e.path = append(e.path, key)
+	}
+}
+
+// enterIndex adds the array index i to the path of the value being encoded,
+// if it is kept.
+func (e *Encoder) enterIndex(i int) {
+	if e.transform != nil {
+		e.path = append(e.path, strconv.Itoa(i))
+	}
+}
+
+// enterField adds the keys of the struct field f to the path of the value
+// being encoded, if it is kept.
+func (e *Encoder) enterField(f field) {
+	if e.transform == nil {
+		return
+	}
+	if f.path != nil {
+		e.path = append(e.path, f.path...)
+	} else {
+		e.path = append(e.path, f.name)
+	}
+}
+
+// leave removes the last key added by enter or enterIndex.
+func (e *Encoder) leave() {
+	if e.transform != nil {
+		e.path = e.path[:len(e.path)-1]
+	}
+}
+
+// leaveField removes the keys added by enterField.
+func (e *Encoder) leaveField(f field) {
+	if e.transform == nil {
+		return
+	}
+	n := 1
+	if f.path != nil {
+		n = len(f.path)
+	}
+	e.path = e.path[:len(e.path)-n]
+}
@@ -0,0 +1,97 @@
+package plist
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+// A UID is a reference to another object of a binary plist, as used by
+// NSKeyedArchiver to link the objects of an archive. Decoding a binary UID
+// into an empty interface stores a UID, and encoding a UID as a binary plist
+// writes it as a UID object.
+//
+// XML plists have no UID element, so tools like plutil convert UIDs to a
+// dictionary with a single CF$UID key holding the UID as an integer:
+//
+//	<dict><key>CF$UID</key><integer>7</integer></dict>
+//
+// The XML decoder reads such dictionaries as UIDs, like the binary decoder
+// reads UID objects, and the XML encoder writes UIDs in this form, so that
+// archives are handled the same way in both formats.
+type UID uint64
+
+var uidType = reflect.TypeOf(UID(0))
+
+// cfUIDKey is the key of the dictionaries that hold UIDs in XML plists.
+const cfUIDKey = "CF$UID"
+
+// xmlUID returns the UID held by dict if it is the XML form of a UID, a dict
+// with only a CF$UID key whose value is a non-negative integer.
+func xmlUID(dict *dictionary) (*plistValue, bool) {
+	if len(dict.m) != 1 || len(dict.duplicates) != 0 {
+		return nil, false
+	}
+	pval, ok := dict.m[cfUIDKey]
+	if !ok || pval.kind != Integer || pval.value.(signedInt).signed {
+		return nil, false
+	}
+	return &plistValue{UIDKind, UID(pval.value.(signedInt).value)}, true
+}
+
+// uidDict returns the XML form of uid, as a dict.
+func uidDict(uid UID) *plistValue {
+	value := &plistValue{Integer, signedInt{uint64(uid), false, false}}
+	return &plistValue{Dictionary, &dictionary{m: map[string]*plistValue{cfUIDKey: value}}}
+}
+
+func (bp *binaryParser) parseUID(marker byte) (*plistValue, error) {
+	// The low 4 bits of the marker are the number of bytes minus one.
+	nbytes := int(marker&0xf) + 1
+	if nbytes > 8 {
+		return nil, fmt.Errorf("plist: cannot decode UIDs longer than 8 bytes (%d)", nbytes)
+	}
+	buf := make([]byte, 8)
+	if _, err := bp.Read(buf[8-nbytes:]); err != nil {
+		return nil, err
+	}
+	return &plistValue{UIDKind, UID(binary.BigEndian.Uint64(buf))}, nil
+}
+
+// unmarshalUID decodes a UID into a UID or any unsigned integer type. A UID
+// decoded into a map or struct is decoded as its XML form, a dict with a
+// CF$UID key, as it was before such dicts were read as UIDs.
+func (d *Decoder) unmarshalUID(pval *plistValue, v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16,
+		reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		v.SetUint(uint64(pval.value.(UID)))
+	case reflect.Map, reflect.Struct:
+		return d.unmarshal(uidDict(pval.value.(UID)), v)
+	default:
+		return UnmarshalTypeError{"uid", v.Type()}
+	}
+	return nil
+}
+
+// writeUID writes a UID using the smallest of the 1, 2, 4 and 8 byte forms
+// that holds it.
+func (e *binaryEncoder) writeUID(uid UID) error {
+	size := uintSize(uint64(uid))
+	return e.writeMarkerUint(0x80|(size-1), uint64(uid), size)
+}
+
+// writeUIDValue writes a UID in its XML form, a dict with a CF$UID key.
+func (e *xmlEncoder) writeUIDValue(pval *plistValue) error {
+	if err := e.writeStart("dict"); err != nil {
+		return err
+	}
+	if err := e.writeKey(cfUIDKey); err != nil {
+		return err
+	}
+	if err := e.writeElement("integer", strconv.FormatUint(uint64(pval.value.(UID)), 10)); err != nil {
+		return err
+	}
+	return e.writeEnd("dict")
+}
@@ -0,0 +1,71 @@
+package plist
+
+import (
+	"reflect"
+	"time"
+)
+
+// Struct fields of type time.Time or *time.Time tagged with the unixdate
+// option, like `plist:"created,unixdate"`, are stored as an <integer> number
+// of seconds since the Unix epoch instead of a <date>. With the ms option as
+// well, like `plist:"created,unixdate,ms"`, the integer is in milliseconds.
+// A <date> still decodes into such a field.
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// unixDateUnit returns the unit of a field tagged with unixdate, or 0 if the
+// field isn't.
+func unixDateUnit(opts tagOptions) time.Duration {
+	switch {
+	case !opts.Contains("unixdate"):
+		return 0
+	case opts.Contains("ms"):
+		return time.Millisecond
+	default:
+		return time.Second
+	}
+}
+
+// unixDateValue returns the integer plistValue for the time in v in units
+// since the Unix epoch, or nil if v doesn't hold a time.Time.
+func unixDateValue(v reflect.Value, unit time.Duration) *plistValue {
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	if v.Type() != timeType {
+		return nil
+	}
+	t := v.Interface().(time.Time)
+	n := t.Unix()
+	if unit == time.Millisecond {
+		n = n*1000 + int64(t.Nanosecond())/1e6
+	}
+	return &plistValue{Integer, signedInt{uint64(n), n < 0, false}}
+}
+
+// unmarshalUnixDate decodes an integer in units since the Unix epoch into a
+// time.Time or *time.Time, and reports whether v is one.
+func (d *Decoder) unmarshalUnixDate(pval *plistValue, v reflect.Value, unit time.Duration) (bool, error) {
+	if v.Kind() == reflect.Ptr && v.Type().Elem() == timeType {
+		if v.IsNil() {
+			v.Set(reflect.New(timeType))
+		}
+		v = v.Elem()
+	}
+	if v.Type() != timeType {
+		return false, nil
+	}
+	i := pval.value.(signedInt)
+	if !i.signed && int64(i.value) < 0 {
+		return true, UnmarshalTypeError{describe(pval), v.Type()}
+	}
+	n := int64(i.value)
+	var t time.Time
+	if unit == time.Millisecond {
+		t = time.Unix(n/1000, n%1000*1e6)
+	} else {
+		t = time.Unix(n, 0)
+	}
+	v.Set(reflect.ValueOf(d.inLocation(t.UTC())))
+	return true, nil
+}
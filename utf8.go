@@ -0,0 +1,84 @@
+package plist
+
+import (
+	"io"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// ReplaceInvalidUTF8 sets whether the strings of binary plists that aren't
+// well-formed, like ASCII strings holding invalid UTF-8 and UTF-16 strings
+// with unpaired surrogates, are decoded with each invalid sequence replaced
+// by U+FFFD, the Unicode replacement character. By default invalid UTF-8 is
+// kept as is, and SetStrict makes it an error. XML plists are always
+// rejected when their text isn't valid UTF-8.
+func (d *Decoder) ReplaceInvalidUTF8(replace bool) {
+	d.replaceUTF8 = replace
+}
+
+// checkUTF8 returns the string s of a binary plist that started at offset
+// as the decoder is set to handle invalid UTF-8, or an error.
+func (bp *binaryParser) checkUTF8(s string, offset int64) (string, error) {
+	if utf8.ValidString(s) {
+		return s, nil
+	}
+	if bp.strict {
+		return "", &SyntaxError{"invalid UTF-8 in string", offset + int64(invalidUTF8(s))}
+	}
+	if bp.replaceUTF8 {
+		return strings.ToValidUTF8(s, "\uFFFD"), nil
+	}
+	return s, nil
+}
+
+// checkUTF16 returns the string of the UTF-16 code units uni of a binary
+// plist that started at offset as the decoder is set to handle unpaired
+// surrogates, or an error. Unless it is strict, they are replaced by U+FFFD,
+// since they have no UTF-8 encoding to keep.
+func (bp *binaryParser) checkUTF16(uni []uint16, offset int64) (string, error) {
+	if bp.strict {
+		if i := invalidUTF16(uni); i >= 0 {
+			return "", &SyntaxError{"invalid UTF-16 in string", offset + 2*int64(i)}
+		}
+	}
+	return string(utf16.Decode(uni)), nil
+}
+
+// stringOffset returns the offset of the contents of the string being read.
+func (bp *binaryParser) stringOffset() int64 {
+	offset, err := bp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return -1
+	}
+	return offset
+}
+
+// invalidUTF8 returns the index of the first invalid UTF-8 sequence in s.
+func invalidUTF8(s string) int {
+	for i, r := range s {
+		if r == utf8.RuneError {
+			if _, size := utf8.DecodeRuneInString(s[i:]); size == 1 {
+				return i
+			}
+		}
+	}
+	return len(s)
+}
+
+// invalidUTF16 returns the index of the first unpaired surrogate in uni, or
+// -1 if there is none.
+func invalidUTF16(uni []uint16) int {
+	for i := 0; i < len(uni); i++ {
+		switch u := rune(uni[i]); {
+		case u >= 0xd800 && u < 0xdc00:
+			if i+1 == len(uni) || uni[i+1] < 0xdc00 || uni[i+1] >= 0xe000 {
+				return i
+			}
+			i++
+		case u >= 0xdc00 && u < 0xe000:
+			return i
+		}
+	}
+	return -1
+}
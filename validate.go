@@ -0,0 +1,133 @@
+package plist
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// A ValidateFunc checks a value for an Encoder with validation enabled, and
+// returns an error describing what is wrong with it, if anything.
+type ValidateFunc func(v interface{}) error
+
+// RegisterValidator records fn as the check of values of type t, and of
+// values pointed to by a *t, made by Encoders with validation enabled before
+// the values are encoded. See Encoder.SetValidation.
+//
+// RegisterValidator panics if t or fn is nil or if t is registered twice. It
+// is safe to call concurrently with encoding, but is meant to be called from
+// init functions.
+func RegisterValidator(t reflect.Type, fn ValidateFunc) {
+	var convert ConvertFunc
+	if fn != nil {
+		convert = func(v interface{}) (interface{}, error) {
+			return nil, fn(v)
+		}
+	}
+	register(converters.validators, "RegisterValidator", t, convert)
+}
+
+// SetValidation sets whether Encode checks the value before writing it, so
+// that nothing is written for a value that doesn't validate:
+//
+//   - struct fields tagged with required, like `plist:"PayloadType,required"`,
+//     must be encoded, so they can't be nil or omitted as empty
+//   - struct fields tagged with enum, like `plist:"Mode,enum=auto|manual"`,
+//     must be encoded as a string or integer whose text is one of the values
+//     separated by |
+//   - values of types registered with RegisterValidator must pass its check
+//
+// The first problem found is returned as a *ValidationError. Validation is
+// off by default.
+func (e *Encoder) SetValidation(validate bool) {
+	e.validate = validate
+}
+
+// A ValidationError is a value that failed the validation done by an Encoder
+// with SetValidation.
+type ValidationError struct {
+	Path string // the dict keys and array indexes leading to the value, joined by dots
+	Err  error
+}
+
+func (e *ValidationError) Error() string {
+	msg := strings.TrimPrefix(e.Err.Error(), "plist: ")
+	if e.Path == "" {
+		return "plist: invalid value: " + msg
+	}
+	return "plist: invalid value at " + e.Path + ": " + msg
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// inPath adds key in front of the path of err if it is a *ValidationError,
+// as the error is returned from the values it was found in.
+func inPath(err error, key string) error {
+	if ve, ok := err.(*ValidationError); ok {
+		if ve.Path == "" {
+			ve.Path = key
+		} else {
+			ve.Path = key + "." + ve.Path
+		}
+	}
+	return err
+}
+
+// validateRegistered checks v with the validator registered for its type.
+func (e *Encoder) validateRegistered(v reflect.Value) error {
+	fn, elem := converter(converters.validators, v.Type())
+	if fn == nil || !v.CanInterface() {
+		return nil
+	}
+	if elem {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if _, err := fn(v.Interface()); err != nil {
+		return &ValidationError{Err: err}
+	}
+	return nil
+}
+
+// validateField checks the encoding of a struct field against its tag
+// options. value is nil if the field is left out.
+func validateField(f field, value *plistValue) error {
+	if value == nil {
+		if f.required {
+			return &ValidationError{Path: f.name, Err: errors.New("required key is missing")}
+		}
+		return nil
+	}
+	if f.enum == nil {
+		return nil
+	}
+	var text string
+	switch value.kind {
+	case String:
+		text = value.value.(string)
+	case Integer:
+		text = numberLiteral(value)
+	default:
+		return &ValidationError{Path: f.name, Err: fmt.Errorf("%v can't be checked against an enum", value.kind)}
+	}
+	for _, allowed := range f.enum {
+		if text == allowed {
+			return nil
+		}
+	}
+	return &ValidationError{Path: f.name, Err: fmt.Errorf("%s is not one of %s", strconv.Quote(text), strings.Join(f.enum, ", "))}
+}
+
+// enumValues returns the values of the enum option of a field, or nil.
+func enumValues(opts tagOptions) []string {
+	if v := opts.Value("enum"); v != "" {
+		return strings.Split(v, "|")
+	}
+	return nil
+}
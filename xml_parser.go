@@ -1,6 +1,7 @@
 package plist
 
 import (
+	"bytes"
 	"encoding/base64"
 	"encoding/xml"
 	"errors"
@@ -14,11 +15,93 @@ import (
 // xmlParser uses xml.Decoder to parse an xml plist into the corresponding plistValues
 type xmlParser struct {
 	*xml.Decoder
+
+	comments *[]Comment // comments between elements are recorded if set
+	rawDates bool       // dates are left unparsed as strings if set
+	strict   bool       // whitespace around numbers and dates is an error if set
+	trimStr  bool       // whitespace around strings is removed if set
+
+	realParser func(string) (float64, error) // parses reals ParseFloat rejects if set
+
+	locations   *[]Location // locations of values are recorded if set
+	path        []string    // key path of the value being parsed, kept when recording locations
+	tokenOffset int64       // offset of the start of the last token
+
+	limit     elementLimit
+	keyFilter map[string]bool // keys of the root dict to parse if set, see onlykeys.go
 }
 
 // newXMLParser returns a new xmlParser
 func newXMLParser(r io.Reader) *xmlParser {
-	return &xmlParser{xml.NewDecoder(r)}
+	return &xmlParser{Decoder: xml.NewDecoder(r)}
+}
+
+// Token returns the next XML token like xml.Decoder.Token, recording any
+// comment it returns.
+func (p *xmlParser) Token() (xml.Token, error) {
+	offset := p.InputOffset()
+	p.tokenOffset = offset
+	tok, err := p.Decoder.Token()
+	if c, ok := tok.(xml.Comment); ok && p.comments != nil {
+		*p.comments = append(*p.comments, Comment{Text: string(c), Offset: offset})
+	}
+	return tok, err
+}
+
+// skip reads tokens until the end of the current element, like
+// xml.Decoder.Skip, but through Token so that comments are recorded.
+func (p *xmlParser) skip() error {
+	for depth := 0; ; {
+		tok, err := p.Token()
+		if err != nil {
+			return err
+		}
+		switch tok.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			if depth == 0 {
+				return nil
+			}
+			depth--
+		}
+	}
+}
+
+// elementKinds maps the names of the XML plist value elements to their kind.
+var elementKinds = map[string]Kind{
+	"dict":    Dictionary,
+	"array":   Array,
+	"string":  String,
+	"integer": Integer,
+	"real":    Real,
+	"true":    Boolean,
+	"false":   Boolean,
+	"data":    Data,
+	"date":    Date,
+}
+
+// peekKind returns the kind of the first value element in buf, which holds
+// the start of an XML plist document.
+func peekKind(buf []byte) (Kind, error) {
+	dec := xml.NewDecoder(bytes.NewReader(buf))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF && len(buf) > 0 {
+			return Invalid, errors.New("plist: no value element found")
+		}
+		if err != nil {
+			return Invalid, err
+		}
+		el, ok := tok.(xml.StartElement)
+		if !ok || el.Name.Local == "plist" {
+			continue
+		}
+		if kind, ok := elementKinds[el.Name.Local]; ok {
+			return kind, nil
+		}
+		return Invalid, fmt.Errorf("plist: Unknown plist element %s", el.Name.Local)
+	}
 }
 
 func (p *xmlParser) parseDocument(start *xml.StartElement) (*plistValue, error) {
@@ -34,15 +117,184 @@ func (p *xmlParser) parseDocument(start *xml.StartElement) (*plistValue, error)
 			}
 		}
 	}
-	return p.parseXMLElement(start)
+	return p.parseLocated(start, Span{})
+}
+
+// parseLocated parses the value element that was the last token read,
+// recording its location at the current key path if locations are recorded.
+// key is the location of its dict key, if any.
+func (p *xmlParser) parseLocated(element *xml.StartElement, key Span) (*plistValue, error) {
+	if p.locations == nil || element.Name.Local == "plist" {
+		return p.parseXMLElement(element)
+	}
+	// The location is added before parsing, to keep the locations in
+	// document order, and completed afterwards.
+	i := len(*p.locations)
+	*p.locations = append(*p.locations, Location{
+		Path:  append([]string(nil), p.path...),
+		Key:   key,
+		Value: Span{Start: p.tokenOffset},
+	})
+	pval, err := p.parseXMLElement(element)
+	(*p.locations)[i].Value.End = p.InputOffset()
+	return pval, err
+}
+
+// parseChild parses the value element of a dict key or array index name like
+// parseLocated.
+func (p *xmlParser) parseChild(element *xml.StartElement, name string, key Span) (*plistValue, error) {
+	if p.locations == nil {
+		return p.parseXMLElement(element)
+	}
+	p.path = append(p.path, name)
+	pval, err := p.parseLocated(element, key)
+	p.path = p.path[:len(p.path)-1]
+	return pval, err
+}
+
+// parsePath parses the value at path below the root value of the document,
+// skipping the elements before and after it, so that the whole document is
+// read from the input.
+func (p *xmlParser) parsePath(path []string) (*plistValue, error) {
+	start, open, err := p.findPath(path)
+	if err != nil {
+		return nil, err
+	}
+	pval, err := p.parseXMLElement(start)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < open; i++ {
+		if err := p.skip(); err != nil {
+			return nil, err
+		}
+	}
+	return pval, nil
+}
+
+// findPath reads the document up to the start element of the value at path
+// below the root value, skipping the elements before it. It also returns the
+// number of elements around the value, which are still open.
+func (p *xmlParser) findPath(path []string) (*xml.StartElement, int, error) {
+	start, err := p.nextStart()
+	if err != nil {
+		return nil, 0, err
+	}
+	open := len(path)
+	if start != nil && start.Name.Local == "plist" {
+		if start, err = p.nextStart(); err != nil {
+			return nil, 0, err
+		}
+		open++
+	}
+	if start == nil {
+		return nil, 0, ErrEmptyDocument
+	}
+	for i, elem := range path {
+		switch start.Name.Local {
+		case "dict":
+			start, err = p.findKey(elem)
+		case "array":
+			start, err = p.findIndex(elem)
+		default:
+			start = nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if start == nil {
+			return nil, 0, errPathNotFound(path[:i+1])
+		}
+	}
+	return start, open, nil
+}
+
+// nextStart returns the next start element, or nil if the end of the current
+// element comes first.
+func (p *xmlParser) nextStart() (*xml.StartElement, error) {
+	for {
+		tok, err := p.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			return &t, nil
+		case xml.EndElement:
+			return nil, nil
+		}
+	}
+}
+
+// findKey reads the current dict up to the key, skipping the values of other
+// keys, and returns the start element of its value, or nil if the dict has no
+// such key.
+func (p *xmlParser) findKey(key string) (*xml.StartElement, error) {
+	for {
+		el, err := p.nextStart()
+		if err != nil || el == nil {
+			return nil, err
+		}
+		if el.Name.Local != "key" {
+			return nil, errMissingKey(el, p.tokenOffset)
+		}
+		keyOffset := p.tokenOffset
+		var k string
+		if err := p.DecodeElement(&k, el); err != nil {
+			return nil, err
+		}
+		value, err := p.nextStart()
+		if err != nil {
+			return nil, err
+		}
+		if value == nil || value.Name.Local == "key" {
+			return nil, errMissingValue(k, keyOffset)
+		}
+		if k == key {
+			return value, nil
+		}
+		if err := p.skip(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// findIndex reads the current array up to the element with the decimal
+// index, skipping the elements before it, and returns its start element, or
+// nil if the array is too short.
+func (p *xmlParser) findIndex(index string) (*xml.StartElement, error) {
+	n, err := strconv.Atoi(index)
+	if err != nil || n < 0 {
+		return nil, nil
+	}
+	for i := 0; ; i++ {
+		el, err := p.nextStart()
+		if err != nil || el == nil {
+			return nil, err
+		}
+		if i == n {
+			return el, nil
+		}
+		if err := p.skip(); err != nil {
+			return nil, err
+		}
+	}
 }
 
 func (p *xmlParser) parseXMLElement(element *xml.StartElement) (*plistValue, error) {
+	if err := p.limit.add(); err != nil {
+		return nil, err
+	}
+	// The filter only applies to the root value, the first one parsed.
+	var filter map[string]bool
+	if element.Name.Local != "plist" {
+		filter, p.keyFilter = p.keyFilter, nil
+	}
 	switch element.Name.Local {
 	case "plist":
 		return p.parsePlist(element)
 	case "dict":
-		return p.parseDict(element)
+		return p.parseDict(element, filter)
 	case "string":
 		return p.parseString(element)
 	case "true", "false":
@@ -72,43 +324,83 @@ func (p *xmlParser) parsePlist(element *xml.StartElement) (*plistValue, error) {
 			break
 		}
 		if el, ok := token.(xml.StartElement); ok {
-			return p.parseXMLElement(&el)
+			pval, err := p.parseLocated(&el, Span{})
+			if err != nil {
+				return nil, err
+			}
+			// Consume the rest of the document up to </plist>, so that
+			// nothing of it is left in the input.
+			if err := p.skip(); err != nil {
+				return nil, err
+			}
+			return pval, nil
 		}
 	}
-	return nil, errors.New("plist: Invalid plist")
+	return nil, ErrEmptyDocument
 }
 
-func (p *xmlParser) parseDict(element *xml.StartElement) (*plistValue, error) {
+func (p *xmlParser) parseDict(element *xml.StartElement, filter map[string]bool) (*plistValue, error) {
 	var key *string
+	var keySpan Span
 	var subvalues = make(map[string]*plistValue)
+	var duplicates, order []string
 	for {
 		token, err := p.Token()
 		if err != nil {
 			return nil, err
 		}
 		if el, ok := token.(xml.EndElement); ok && el.Name.Local == "dict" {
+			if key != nil {
+				return nil, errMissingValue(*key, keySpan.Start)
+			}
 			break
 		}
 		if el, ok := token.(xml.StartElement); ok {
 			if el.Name.Local == "key" {
+				if key != nil {
+					return nil, errMissingValue(*key, keySpan.Start)
+				}
+				if err := p.limit.add(); err != nil {
+					return nil, err
+				}
+				keySpan.Start = p.tokenOffset
 				var k string
 				if err := p.DecodeElement(&k, &el); err != nil {
 					return nil, err
 				}
+				keySpan.End = p.InputOffset()
 				key = &k
 				continue
 			}
 			if key == nil {
-				return nil, errors.New("plist: missing key in dict")
+				return nil, errMissingKey(&el, p.tokenOffset)
+			}
+			if filter != nil && !filter[*key] {
+				if err := p.skip(); err != nil {
+					return nil, err
+				}
+				key = nil
+				continue
 			}
-			subvalues[*key], err = p.parseXMLElement(&el)
+			if _, ok := subvalues[*key]; ok {
+				duplicates = append(duplicates, *key)
+			} else {
+				order = append(order, *key)
+			}
+			subvalues[*key], err = p.parseChild(&el, *key, keySpan)
 			if err != nil {
 				return nil, err
 			}
 			key = nil
 		}
 	}
-	return &plistValue{Dictionary, &dictionary{m: subvalues}}, nil
+	dict := &dictionary{m: subvalues, duplicates: duplicates, order: order}
+	if filter == nil {
+		if uid, ok := xmlUID(dict); ok {
+			return uid, nil
+		}
+	}
+	return &plistValue{Dictionary, dict}, nil
 }
 
 func (p *xmlParser) parseString(element *xml.StartElement) (*plistValue, error) {
@@ -116,11 +408,19 @@ func (p *xmlParser) parseString(element *xml.StartElement) (*plistValue, error)
 	if err := p.DecodeElement(&value, element); err != nil {
 		return nil, err
 	}
-	return &plistValue{String, value}, nil
+	return p.stringValue(value), nil
+}
+
+// stringValue returns the value of a <string> element whose text is s.
+func (p *xmlParser) stringValue(s string) *plistValue {
+	if p.trimStr {
+		s = strings.Trim(s, " \t\r\n")
+	}
+	return &plistValue{String, s}
 }
 
 func (p *xmlParser) parseBoolean(element *xml.StartElement) (*plistValue, error) {
-	if err := p.Skip(); err != nil {
+	if err := p.skip(); err != nil {
 		return nil, err
 	}
 	plistBoolean := element.Name.Local == "true"
@@ -138,7 +438,7 @@ func (p *xmlParser) parseArray(element *xml.StartElement) (*plistValue, error) {
 			break
 		}
 		if el, ok := token.(xml.StartElement); ok {
-			subv, err := p.parseXMLElement(&el)
+			subv, err := p.parseChild(&el, strconv.Itoa(len(subvalues)), Span{})
 			if err != nil {
 				return nil, err
 			}
@@ -149,11 +449,32 @@ func (p *xmlParser) parseArray(element *xml.StartElement) (*plistValue, error) {
 }
 
 func (p *xmlParser) parseReal(element *xml.StartElement) (*plistValue, error) {
-	var n float64
-	if err := p.DecodeElement(&n, element); err != nil {
+	// Decode into a string first so that the literal is kept for Number.
+	var s string
+	if err := p.DecodeElement(&s, element); err != nil {
 		return nil, err
 	}
-	return &plistValue{Real, sizedFloat{n, 64}}, nil
+	return p.realValue(element, s)
+}
+
+// realValue parses s, the text of the <real> element.
+func (p *xmlParser) realValue(element *xml.StartElement, s string) (*plistValue, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, errEmptyElement(element)
+	}
+	s = p.trim(s)
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		if p.realParser == nil || p.strict {
+			return nil, err
+		}
+		if n, err = p.realParser(s); err != nil {
+			return nil, err
+		}
+		// The text isn't a valid literal, so Number uses the value.
+		return &plistValue{Real, sizedFloat{n, 64, ""}}, nil
+	}
+	return &plistValue{Real, sizedFloat{n, 64, s}}, nil
 }
 
 func (p *xmlParser) parseInteger(element *xml.StartElement) (*plistValue, error) {
@@ -166,45 +487,127 @@ func (p *xmlParser) parseInteger(element *xml.StartElement) (*plistValue, error)
 	if err := p.DecodeElement(&s, element); err != nil {
 		return nil, err
 	}
+	return p.integerValue(element, s)
+}
+
+// integerValue parses s, the text of the <integer> element.
+func (p *xmlParser) integerValue(element *xml.StartElement, s string) (*plistValue, error) {
 	// Determine if this is a negative number by checking for minus sign.
-	s = strings.TrimSpace(s)
+	if strings.TrimSpace(s) == "" {
+		return nil, errEmptyElement(element)
+	}
+	s = p.trim(s)
 	if strings.HasPrefix(s, "-") {
 		i, err := strconv.ParseInt(s, 10, 64)
 		if err != nil {
 			return nil, err
 		}
-		return &plistValue{Integer, signedInt{uint64(i), true}}, nil
+		return &plistValue{Integer, signedInt{uint64(i), true, false}}, nil
 	}
 	// Otherwise assume positive number and put into uint64.
 	u, err := strconv.ParseUint(s, 10, 64)
 	if err != nil {
 		return nil, err
 	}
-	return &plistValue{Integer, signedInt{u, false}}, nil
+	return &plistValue{Integer, signedInt{u, false, false}}, nil
 }
 
 func (p *xmlParser) parseData(element *xml.StartElement) (*plistValue, error) {
-	replacer := strings.NewReplacer("\t", "", "\n", "", " ", "", "\r", "")
-	var data []byte
-	if err := p.DecodeElement(&data, element); err != nil {
+	var s string
+	if err := p.DecodeElement(&s, element); err != nil {
 		return nil, err
 	}
-	if len(data) == 0 {
+	return p.dataValue(s)
+}
+
+// dataValue decodes s, the base64 text of a <data> element.
+func (p *xmlParser) dataValue(s string) (*plistValue, error) {
+	if len(s) == 0 {
 		return &plistValue{Data, []byte(nil)}, nil
 	}
-	str := replacer.Replace(string(data))
+	replacer := strings.NewReplacer("\t", "", "\n", "", " ", "", "\r", "")
+	str := replacer.Replace(s)
 	decoded, err := base64.StdEncoding.DecodeString(str)
+	if err != nil && !p.strict {
+		// Some generators write URL-safe or unpadded base64.
+		for _, enc := range lenientEncodings {
+			if d, err2 := enc.DecodeString(str); err2 == nil {
+				decoded, err = d, nil
+				break
+			}
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
-	data = []byte(decoded)
-	return &plistValue{Data, data}, nil
+	return &plistValue{Data, decoded}, nil
+}
+
+// lenientEncodings are the base64 encodings tried, in order, for <data>
+// elements that aren't standard base64 unless the parser is strict.
+var lenientEncodings = []*base64.Encoding{
+	base64.URLEncoding,
+	base64.RawStdEncoding,
+	base64.RawURLEncoding,
 }
 
 func (p *xmlParser) parseDate(element *xml.StartElement) (*plistValue, error) {
+	var s string
+	if err := p.DecodeElement(&s, element); err != nil {
+		return nil, err
+	}
+	return p.dateValue(element, s)
+}
+
+// dateValue parses s, the text of the <date> element.
+func (p *xmlParser) dateValue(element *xml.StartElement, s string) (*plistValue, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, errEmptyElement(element)
+	}
+	if p.rawDates {
+		return &plistValue{Date, s}, nil
+	}
 	var date time.Time
-	if err := p.DecodeElement(&date, element); err != nil {
+	if err := date.UnmarshalText([]byte(p.trim(s))); err != nil {
 		return nil, err
 	}
 	return &plistValue{Date, date}, nil
 }
+
+// trim removes the whitespace around the text of an <integer>, <real> or
+// <date> element, such as from hand-indented files, unless the parser is
+// strict.
+func (p *xmlParser) trim(s string) string {
+	if p.strict {
+		return s
+	}
+	return strings.Trim(s, " \t\r\n")
+}
+
+// A SyntaxError describes a malformed XML plist document, such as a dict
+// whose keys and values don't alternate.
+type SyntaxError struct {
+	Msg    string
+	Offset int64 // byte offset of the element where the problem was found
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("plist: %s at offset %d", e.Msg, e.Offset)
+}
+
+// errMissingValue is returned for a dict key that isn't followed by a value.
+func errMissingValue(key string, offset int64) error {
+	return &SyntaxError{fmt.Sprintf("dict key %q has no value", key), offset}
+}
+
+// errMissingKey is returned for a dict value that isn't preceded by a key.
+func errMissingKey(element *xml.StartElement, offset int64) error {
+	return &SyntaxError{fmt.Sprintf("<%s> in dict without a key", element.Name.Local), offset}
+}
+
+// errEmptyElement is returned for empty <integer>, <real> and <date> elements,
+// which have no value to decode, like <integer/>. CoreFoundation rejects them
+// too. Empty <string> and <data> elements are valid and decode as empty.
+func errEmptyElement(element *xml.StartElement) error {
+	return fmt.Errorf("plist: empty <%s> element", element.Name.Local)
+}
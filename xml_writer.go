@@ -1,62 +1,106 @@
 package plist
 
 import (
-	"encoding/base64"
+	"bufio"
 	"encoding/xml"
-	"fmt"
 	"io"
 	"math"
 	"reflect"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// utf8BOM is the byte order mark written before the XML header with SetBOM.
+const utf8BOM = "\xef\xbb\xbf"
+
 const xmlDOCTYPE = `<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">`
 
+// xmlEncoder writes plistValues as XML. It tracks indentation itself, rather
+// than using xml.Encoder, so that self closing elements like <true/> are
+// indented the same way as every other element.
 type xmlEncoder struct {
-	writer io.Writer
-	*xml.Encoder
+	w *bufio.Writer
+
+	indent string
+	depth  int
+	// indentedIn is true when the last thing written was a start element,
+	// so that a matching end element stays on the same line.
+	indentedIn bool
+	putNewline bool
+	// sameLine is true after a key written with inlineKeys, so that its
+	// value follows on the same line.
+	sameLine bool
+
+	selfClosingEmpty bool
+	inlineKeys       bool
+	noFinalNewline   bool
+	newline          string // line ending written between elements
+	bom              bool
+	dataFormat       DataFormat
+	subsecondDates   bool
 }
 
 func newXMLEncoder(w io.Writer) *xmlEncoder {
-	return &xmlEncoder{w, xml.NewEncoder(w)}
+	return &xmlEncoder{w: bufio.NewWriter(w), newline: "\n"}
+}
+
+// Indent sets the string used for each level of indentation. An empty string
+// disables indentation.
+func (e *xmlEncoder) Indent(indent string) {
+	e.indent = indent
 }
 
 func (e *xmlEncoder) generateDocument(pval *plistValue) error {
-	// xml version=1.0
-	_, err := e.writer.Write([]byte(xml.Header))
-	if err != nil {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	if err := e.writePlistValue(pval); err != nil {
+		return err
+	}
+	return e.writeFooter()
+}
+
+// writeHeader writes everything up to and including the <plist> start tag.
+func (e *xmlEncoder) writeHeader() error {
+	if e.bom {
+		if _, err := e.w.WriteString(utf8BOM); err != nil {
+			return err
+		}
+	}
+
+	// xml version=1.0, without the newline of xml.Header
+	if _, err := e.w.WriteString(strings.TrimSuffix(xml.Header, "\n") + e.newline); err != nil {
 		return err
 	}
 
 	//!DOCTYPE plist
-	_, err = e.writer.Write([]byte(xmlDOCTYPE))
-	if err != nil {
+	if _, err := e.w.WriteString(xmlDOCTYPE); err != nil {
 		return err
 	}
 
 	// newline after doctype
 	// <plist> tag starts on new line
-	_, err = e.writer.Write([]byte("\n"))
-	if err != nil {
+	if _, err := e.w.WriteString(e.newline); err != nil {
 		return err
 	}
 
-	tokenFunc := func(pval *plistValue) error {
-		if err := e.writePlistValue(pval); err != nil {
-			return err
-		}
-		return nil
-	}
-	err = e.writeElement("plist", pval, tokenFunc)
-	if err != nil {
+	return e.writeStart(`plist version="1.0"`)
+}
+
+// writeFooter writes the </plist> end tag and flushes the document.
+func (e *xmlEncoder) writeFooter() error {
+	if err := e.writeEnd("plist"); err != nil {
 		return err
 	}
+
 	// newline at the end of a plist document
-	_, err = e.writer.Write([]byte("\n"))
-	if err != nil {
-		return err
+	if !e.noFinalNewline {
+		if _, err := e.w.WriteString(e.newline); err != nil {
+			return err
+		}
 	}
-	return nil
+	return e.w.Flush()
 }
 
 func (e *xmlEncoder) writePlistValue(pval *plistValue) error {
@@ -77,161 +121,242 @@ func (e *xmlEncoder) writePlistValue(pval *plistValue) error {
 		return e.writeRealValue(pval)
 	case Data:
 		return e.writeDataValue(pval)
+	case UIDKind:
+		return e.writeUIDValue(pval)
+	case commented:
+		c := pval.value.(commentedValue)
+		if err := e.writeComment(c.comment); err != nil {
+			return err
+		}
+		return e.writePlistValue(c.value)
 	default:
 		return &UnsupportedTypeError{reflect.ValueOf(pval.value).Type()}
 	}
 }
 
-func (e *xmlEncoder) writeDataValue(pval *plistValue) error {
-	encodedValue := base64.StdEncoding.EncodeToString(pval.value.([]byte))
-	return e.EncodeElement(encodedValue, xml.StartElement{Name: xml.Name{Local: "data"}})
+// writeIndent starts a new line at the current depth before an element.
+// depthDelta is 1 for a start element, -1 for an end element and 0 for a
+// self closing element. This mirrors the indentation done by xml.Encoder.
+func (e *xmlEncoder) writeIndent(depthDelta int) error {
+	if len(e.indent) == 0 {
+		return nil
+	}
+	if depthDelta < 0 {
+		e.depth--
+		if e.indentedIn {
+			e.indentedIn = false
+			return nil
+		}
+	}
+	e.indentedIn = false
+	if e.sameLine {
+		e.sameLine = false
+		if depthDelta > 0 {
+			e.depth++
+			e.indentedIn = true
+		}
+		return nil
+	}
+	if e.putNewline {
+		if _, err := e.w.WriteString(e.newline); err != nil {
+			return err
+		}
+	} else {
+		e.putNewline = true
+	}
+	for i := 0; i < e.depth; i++ {
+		if _, err := e.w.WriteString(e.indent); err != nil {
+			return err
+		}
+	}
+	if depthDelta > 0 {
+		e.depth++
+		e.indentedIn = true
+	}
+	return nil
 }
 
-func (e *xmlEncoder) writeRealValue(pval *plistValue) error {
-	encodedValue := pval.value
-	switch {
-	case math.IsInf(pval.value.(sizedFloat).value, 1):
-		encodedValue = "inf"
-	case math.IsInf(pval.value.(sizedFloat).value, -1):
-		encodedValue = "-inf"
-	case math.IsNaN(pval.value.(sizedFloat).value):
-		encodedValue = "nan"
-	default:
-		encodedValue = pval.value.(sizedFloat).value
+// writeStart writes a start element like <dict>. tag may include attributes.
+func (e *xmlEncoder) writeStart(tag string) error {
+	if err := e.writeIndent(1); err != nil {
+		return err
 	}
-	return e.EncodeElement(encodedValue, xml.StartElement{Name: xml.Name{Local: "real"}})
+	_, err := e.w.WriteString("<" + tag + ">")
+	return err
 }
 
-// writeElement writes an xml element like <plist>, <array> or <dict>
-func (e *xmlEncoder) writeElement(name string, pval *plistValue, valFunc func(*plistValue) error) error {
-	startElement := xml.StartElement{
-		Name: xml.Name{
-			Space: "",
-			Local: name,
-		}}
+// writeEnd writes an end element like </dict>.
+func (e *xmlEncoder) writeEnd(name string) error {
+	if err := e.writeIndent(-1); err != nil {
+		return err
+	}
+	_, err := e.w.WriteString("</" + name + ">")
+	return err
+}
 
-	if name == "plist" {
-		startElement.Attr = []xml.Attr{{
-			Name: xml.Name{
-				Space: "",
-				Local: "version"},
-			Value: "1.0"},
-		}
+// writeEmpty writes a self closing element like <true/>.
+func (e *xmlEncoder) writeEmpty(name string) error {
+	if err := e.writeIndent(0); err != nil {
+		return err
 	}
+	_, err := e.w.WriteString("<" + name + "/>")
+	return err
+}
 
-	// Encode xml.StartElement token
-	if err := e.EncodeToken(startElement); err != nil {
+// writeComment writes an XML comment, indented like a self closing element.
+func (e *xmlEncoder) writeComment(text string) error {
+	if err := e.writeIndent(0); err != nil {
 		return err
 	}
+	_, err := e.w.WriteString("<!-- " + text + " -->")
+	return err
+}
 
-	// flush
-	if err := e.Flush(); err != nil {
+// writeKey writes a dictionary key. With inlineKeys, the value that follows
+// is written on the same line.
+func (e *xmlEncoder) writeKey(key string) error {
+	if err := e.writeElement("key", escapeText(key, true)); err != nil {
 		return err
 	}
+	e.sameLine = e.inlineKeys
+	return nil
+}
 
-	// execute valFunc()
-	if err := valFunc(pval); err != nil {
+// writeElement writes a start element, the already escaped text, and an end
+// element.
+func (e *xmlEncoder) writeElement(name, text string) error {
+	if err := e.writeStart(name); err != nil {
 		return err
 	}
+	if _, err := e.w.WriteString(text); err != nil {
+		return err
+	}
+	return e.writeEnd(name)
+}
 
-	// Encode xml.EndElement token
-	if err := e.EncodeToken(startElement.End()); err != nil {
+// writeCollection writes an <array> or <dict> element, calling valFunc to
+// write the contents when there are any.
+func (e *xmlEncoder) writeCollection(name string, empty bool, valFunc func() error) error {
+	if empty && e.selfClosingEmpty {
+		return e.writeEmpty(name)
+	}
+	if err := e.writeStart(name); err != nil {
+		return err
+	}
+	if err := valFunc(); err != nil {
 		return err
 	}
+	return e.writeEnd(name)
+}
 
-	// flush
-	return e.Flush()
+func (e *xmlEncoder) writeRealValue(pval *plistValue) error {
+	var encodedValue string
+	switch f := pval.value.(sizedFloat).value; {
+	case pval.value.(sizedFloat).literal != "":
+		encodedValue = pval.value.(sizedFloat).literal
+	case math.IsInf(f, 1):
+		encodedValue = "inf"
+	case math.IsInf(f, -1):
+		encodedValue = "-inf"
+	case math.IsNaN(f):
+		encodedValue = "nan"
+	default:
+		encodedValue = strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return e.writeElement("real", encodedValue)
 }
 
 func (e *xmlEncoder) writeArrayValue(pval *plistValue) error {
-	tokenFunc := func(pval *plistValue) error {
-		encodedValue := pval.value
-		values := encodedValue.([]*plistValue)
+	values := pval.value.([]*plistValue)
+	return e.writeCollection("array", len(values) == 0, func() error {
 		for _, v := range values {
 			if err := e.writePlistValue(v); err != nil {
 				return err
 			}
 		}
 		return nil
-	}
-	return e.writeElement("array", pval, tokenFunc)
-
+	})
 }
 
 func (e *xmlEncoder) writeDictionaryValue(pval *plistValue) error {
-	tokenFunc := func(pval *plistValue) error {
-		encodedValue := pval.value
-		dict := encodedValue.(*dictionary)
-		dict.populateArrays()
+	dict := pval.value.(*dictionary)
+	dict.populateArrays()
+	return e.writeCollection("dict", len(dict.keys) == 0, func() error {
 		for i, k := range dict.keys {
-			if err := e.EncodeElement(k, xml.StartElement{Name: xml.Name{Local: "key"}}); err != nil {
+			// The comment of a commented value goes before its key.
+			value := dict.values[i]
+			if value.kind == commented {
+				c := value.value.(commentedValue)
+				if err := e.writeComment(c.comment); err != nil {
+					return err
+				}
+				value = c.value
+			}
+			if err := e.writeKey(k); err != nil {
 				return err
 			}
-			if err := e.writePlistValue(dict.values[i]); err != nil {
+			if err := e.writePlistValue(value); err != nil {
 				return err
 			}
 		}
 		return nil
-	}
-	return e.writeElement("dict", pval, tokenFunc)
+	})
 }
 
-// encode strings as CharData, which doesn't escape newline
+// strings don't escape newline
 // see https://github.com/golang/go/issues/9204
 func (e *xmlEncoder) writeStringValue(pval *plistValue) error {
-	startElement := xml.StartElement{Name: xml.Name{Local: "string"}}
-	// Encode xml.StartElement token
-	if err := e.EncodeToken(startElement); err != nil {
-		return err
-	}
-
-	// flush
-	if err := e.Flush(); err != nil {
-		return err
-	}
-
-	stringValue := pval.value.(string)
-	if err := e.EncodeToken(xml.CharData(stringValue)); err != nil {
-		return err
-	}
-
-	// flush
-	if err := e.Flush(); err != nil {
-		return err
-	}
-
-	// Encode xml.EndElement token
-	if err := e.EncodeToken(startElement.End()); err != nil {
-		return err
-	}
-
-	// flush
-	return e.Flush()
-
+	return e.writeElement("string", escapeText(pval.value.(string), false))
 }
 
 func (e *xmlEncoder) writeBoolValue(pval *plistValue) error {
-	// EncodeElement results in <true></true> instead of <true/>
-	// use writer to write self closing tags
-	b := pval.value.(bool)
-	_, err := e.writer.Write([]byte(fmt.Sprintf("<%t/>", b)))
-	if err != nil {
-		return err
+	if pval.value.(bool) {
+		return e.writeEmpty("true")
 	}
-	return nil
+	return e.writeEmpty("false")
 }
 
 func (e *xmlEncoder) writeIntegerValue(pval *plistValue) error {
-	encodedValue := pval.value
+	var encodedValue string
 	if pval.value.(signedInt).signed {
-		encodedValue = int64(pval.value.(signedInt).value)
+		encodedValue = strconv.FormatInt(int64(pval.value.(signedInt).value), 10)
 	} else {
-		encodedValue = pval.value.(signedInt).value
+		encodedValue = strconv.FormatUint(pval.value.(signedInt).value, 10)
 	}
-	return e.EncodeElement(encodedValue, xml.StartElement{Name: xml.Name{Local: "integer"}})
+	return e.writeElement("integer", encodedValue)
 }
 
 func (e *xmlEncoder) writeDateValue(pval *plistValue) error {
-	encodedValue := pval.value.(time.Time).In(time.UTC).Format(time.RFC3339)
-	return e.EncodeElement(encodedValue, xml.StartElement{Name: xml.Name{Local: "date"}})
+	// Strings tagged as dates are written as is.
+	if s, ok := pval.value.(string); ok {
+		return e.writeElement("date", escapeText(s, true))
+	}
+	return e.writeElement("date", formatDate(pval.value.(time.Time).In(time.UTC), e.subsecondDates))
+}
+
+// formatDate formats t as RFC 3339, with the fraction of its second if
+// subsecond is set and it has one. Without subsecond the fraction is
+// truncated, like Apple's tools do.
+func formatDate(t time.Time, subsecond bool) string {
+	if subsecond {
+		return t.Format(time.RFC3339Nano)
+	}
+	return t.Format(time.RFC3339)
+}
+
+// escapeText returns s with XML special characters escaped, the same way as
+// xml.EscapeText. Newlines are left as is unless escapeNewline is set.
+func escapeText(s string, escapeNewline bool) string {
+	var b strings.Builder
+	if escapeNewline {
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+	for i, line := range strings.Split(s, "\n") {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		xml.EscapeText(&b, []byte(line))
+	}
+	return b.String()
 }